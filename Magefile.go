@@ -3,6 +3,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -68,7 +69,27 @@ func buildOSArch(goos, goarch string) error {
 		"CGO_ENABLED": "0",
 	}
 
-	ldflags := strings.Join([]string{"-s", "-w"}, " ")
+	version, err := pluginVersion()
+	if err != nil {
+		return err
+	}
+	ldflags := strings.Join([]string{"-s", "-w", "-X main.version=" + version}, " ")
 	args := []string{"build", "-trimpath", "-ldflags", ldflags, "-o", out, "./cmd/grafana-catalyst-datasource"}
 	return sh.RunWithV(env, "go", args...)
 }
+
+// pluginVersion returns the version from package.json, which the backend
+// reports in its User-Agent.
+func pluginVersion() (string, error) {
+	b, err := os.ReadFile("package.json")
+	if err != nil {
+		return "", err
+	}
+	var pkg struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(b, &pkg); err != nil {
+		return "", fmt.Errorf("package.json: %w", err)
+	}
+	return pkg.Version, nil
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	// Embed the zone database so displayTimezone works on hosts without tzdata.
+	_ "time/tzdata"
+
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	log "github.com/grafana/grafana-plugin-sdk-go/backend/log"
 
 	ds "github.com/extkljajicm/grafana-catalyst-datasource/pkg/backend"
 )
 
+// version is set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
 func main() {
-	log.DefaultLogger.Info("starting grafana-catalyst-datasource backend")
+	log.DefaultLogger.Info("starting grafana-catalyst-datasource backend", "version", version)
+
+	ds.Version = version
 
 	d := ds.NewDatasource()
 
@@ -16,6 +24,7 @@ func main() {
 		CheckHealthHandler:  d,
 		QueryDataHandler:    d,
 		CallResourceHandler: d,
+		StreamHandler:       d,
 	}); err != nil {
 		log.DefaultLogger.Error("failed to start plugin", "err", err)
 	}
@@ -5,6 +5,7 @@ go 1.24.6
 require (
 	github.com/grafana/grafana-plugin-sdk-go v0.279.0
 	github.com/magefile/mage v1.15.0
+	golang.org/x/time v0.9.0
 )
 
 require (
@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is wrapped by the errors of requests an open circuit breaker
+// refuses.
+var errCircuitOpen = errors.New("circuit open")
+
+// Circuit breaker states.
+const (
+	breakerClosed   = iota // requests pass; failures are counted
+	breakerOpen            // requests fail fast until the cooldown ends
+	breakerHalfOpen        // one probe request is in flight
+)
+
+// circuitBreaker stops an instance from hammering a Catalyst Center that is
+// down. After threshold consecutive failures it opens and refuses requests
+// for cooldown; then it lets a single probe through, closing again when the
+// probe succeeds and reopening when it fails.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	state    int
+	failures int // consecutive failures while closed
+	openedAt time.Time
+}
+
+// newCircuitBreaker returns a closed breaker.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a request may be sent, as an error wrapping
+// errCircuitOpen when it may not. Once the cooldown is over the first caller
+// becomes the probe; others keep failing fast until it completes.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if wait := b.openedAt.Add(b.cooldown).Sub(b.now()); wait > 0 {
+			return fmt.Errorf("%w: Catalyst Center failed %d times in a row; retrying in %s", errCircuitOpen, b.threshold, wait.Round(time.Second))
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		return fmt.Errorf("%w: probing whether Catalyst Center is back", errCircuitOpen)
+	default:
+		return nil
+	}
+}
+
+// record counts the outcome of an allowed request.
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt = breakerOpen, b.now()
+	}
+}
+
+// abandon releases an allowed request without an outcome. A half-open
+// breaker returns to open with its cooldown over, so the next request
+// probes instead.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+// configure applies changed settings. The breaker's state is kept.
+func (b *circuitBreaker) configure(threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold, b.cooldown = threshold, cooldown
+}
+
+// breakerFor returns the circuit breaker of an instance, creating it on first
+// use. Breakers are kept per instance UID so their state survives client
+// rebuilds; changed thresholds are applied in place.
+func (d *Datasource) breakerFor(inst *dsInstance) *circuitBreaker {
+	threshold, cooldown := inst.Settings.BreakerThreshold, inst.Settings.BreakerCooldown
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+
+	d.breakersMu.Lock()
+	defer d.breakersMu.Unlock()
+	b, ok := d.breakers[inst.UID]
+	if !ok {
+		b = newCircuitBreaker(threshold, cooldown)
+		d.breakers[inst.UID] = b
+	} else {
+		b.configure(threshold, cooldown)
+	}
+	return b
+}
+
+// breakerTransport sends requests through a circuit breaker. Network errors,
+// timeouts and 5xx responses count as failures. Requests the caller
+// cancelled, and those the local rate limiter held back, never reached the
+// cluster and don't count at all.
+type breakerTransport struct {
+	next    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+// RoundTrip fails fast while the breaker is open.
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && (errors.Is(err, errRateLimitWait) || errors.Is(req.Context().Err(), context.Canceled)) {
+		// Throttled locally or given up on by the caller; that says nothing
+		// about the cluster. A deadline, such as http.Client.Timeout, does
+		// count: a hung cluster is what the breaker is for.
+		t.breaker.abandon()
+		return resp, err
+	}
+	t.breaker.record(isBreakerFailure(resp, err))
+	return resp, err
+}
+
+// isBreakerFailure reports whether a request outcome counts against the
+// cluster: any error, timeouts included, other than an open breaker's, or a
+// 5xx response.
+func isBreakerFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, errCircuitOpen)
+	}
+	return resp.StatusCode >= 500
+}
@@ -0,0 +1,170 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/time/rate"
+)
+
+func TestCircuitBreaker_OpensAndCloses(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	b := newCircuitBreaker(3, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("failure %d: allow = %v, want nil while closed", i+1, err)
+		}
+		b.record(true)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow after 3 failures = %v, want errCircuitOpen", err)
+	}
+
+	// After the cooldown one probe goes through; a failed probe reopens.
+	now = now.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe allow = %v, want nil", err)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow during probe = %v, want errCircuitOpen", err)
+	}
+	b.record(true)
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow after failed probe = %v, want errCircuitOpen", err)
+	}
+
+	// A successful probe closes the breaker and resets the count.
+	now = now.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("second probe allow = %v, want nil", err)
+	}
+	b.record(false)
+	b.record(true)
+	b.record(true)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow after success and 2 failures = %v, want nil", err)
+	}
+}
+
+func TestQueryData_CircuitBreaker(t *testing.T) {
+	var calls, healthy int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"}]}`))
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","maxRetries":0,"circuitBreakerThreshold":2}`)
+	d := NewDatasource()
+	query := func() backend.DataResponse {
+		resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: pc,
+			Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts"}`)},
+		})
+		if err != nil {
+			t.Fatalf("QueryData error: %v", err)
+		}
+		return resp.Responses["A"]
+	}
+
+	// Two failing queries open the breaker; the third fails fast.
+	query()
+	query()
+	before := atomic.LoadInt32(&calls)
+	dr := query()
+	if dr.Error == nil || !strings.Contains(dr.Error.Error(), "circuit open") {
+		t.Fatalf("error = %v, want circuit open", dr.Error)
+	}
+	if got := atomic.LoadInt32(&calls); got != before {
+		t.Fatalf("upstream calls while open = %d, want 0", got-before)
+	}
+
+	// Once the cluster is back and the cooldown over, the probe closes it.
+	atomic.StoreInt32(&healthy, 1)
+	b := d.breakers["test-uid"]
+	b.now = func() time.Time { return time.Now().Add(time.Minute) }
+	if dr := query(); dr.Error != nil {
+		t.Fatalf("query after cooldown: %v", dr.Error)
+	}
+	b.now = time.Now
+	if dr := query(); dr.Error != nil {
+		t.Fatalf("query after closing: %v", dr.Error)
+	}
+}
+
+func TestBreakerTransport_TimeoutsCountCancellationsDont(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	b := newCircuitBreaker(1, time.Minute)
+	client := &http.Client{Transport: &breakerTransport{next: http.DefaultTransport, breaker: b}}
+
+	// The caller cancelling is not the cluster's fault.
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("cancelled request succeeded")
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow after a cancelled request = %v, want nil", err)
+	}
+
+	// A hung cluster hitting http.Client.Timeout is a failure.
+	client.Timeout = 20 * time.Millisecond
+	req, _ = http.NewRequest(http.MethodGet, srv.URL, nil)
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("hung request succeeded")
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow after a timeout = %v, want errCircuitOpen", err)
+	}
+}
+
+func TestBreakerTransport_IgnoresRateLimitWait(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// One request per hour: the second can't get a token before its deadline.
+	b := newCircuitBreaker(1, time.Minute)
+	limited := &rateLimitedTransport{next: http.DefaultTransport, limiter: rate.NewLimiter(rate.Every(time.Hour), 1)}
+	client := &http.Client{Transport: &breakerTransport{next: limited, breaker: b}}
+	for i, wantErr := range []bool{false, true} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		resp, err := client.Do(req)
+		cancel()
+		if (err != nil) != wantErr {
+			t.Fatalf("request %d: err = %v, want error %t", i+1, err, wantErr)
+		}
+		if err == nil {
+			resp.Body.Close()
+		} else if !errors.Is(err, errRateLimitWait) {
+			t.Fatalf("request %d: err = %v, want errRateLimitWait", i+1, err)
+		}
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow after local throttling = %v, want nil", err)
+	}
+}
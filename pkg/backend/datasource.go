@@ -2,14 +2,23 @@
 package backend
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -22,6 +31,27 @@ import (
 // processing resource calls.
 type Datasource struct {
 	tm *tokenManager
+
+	clientsMu sync.Mutex
+	clients   map[string]cachedClient // key: instance UID
+
+	requestSeq atomic.Uint64 // numbers QueryData calls for correlation IDs
+
+	streamsMu sync.Mutex
+	streams   map[string]*streamEntry // key: channel path, see registerIssueStream
+
+	rulesMu sync.Mutex
+	rules   map[string]cachedRules // key: instance UID
+
+	breakersMu sync.Mutex
+	breakers   map[string]*circuitBreaker // key: instance UID
+}
+
+// cachedClient is an HTTP client built for one instance, together with the
+// settings fingerprint it was built from.
+type cachedClient struct {
+	key    string
+	client *http.Client
 }
 
 // dsInstance represents a single configured instance of the datasource.
@@ -34,18 +64,102 @@ type dsInstance struct {
 // NewDatasource creates a new datasource instance with its own token manager.
 func NewDatasource() *Datasource {
 	return &Datasource{
-		tm: newTokenManager(),
+		tm:       newTokenManager(),
+		clients:  make(map[string]cachedClient),
+		streams:  make(map[string]*streamEntry),
+		rules:    make(map[string]cachedRules),
+		breakers: make(map[string]*circuitBreaker),
 	}
 }
 
-// httpClientFor creates an HTTP client that respects the InsecureSkipVerify setting
-// for the given datasource instance. This is crucial for environments with
-// self-signed certificates.
+// httpClientFor builds the client used to reach the instance's Catalyst
+// Center cluster, configured from its connection settings.
 func (d *Datasource) httpClientFor(s *InstanceSettings) *http.Client {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify}, //nolint:gosec
+	tlsCfg := &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify} //nolint:gosec
+	if s.ClientCertificate != nil {
+		tlsCfg.Certificates = []tls.Certificate{*s.ClientCertificate}
+	}
+	// Without an explicit proxy, honor HTTP_PROXY/HTTPS_PROXY/NO_PROXY.
+	proxy := http.ProxyFromEnvironment
+	if s.Proxy != nil {
+		proxy = http.ProxyURL(s.Proxy)
+	}
+	if s.ProxyUsername != "" {
+		proxy = withProxyAuth(proxy, url.UserPassword(s.ProxyUsername, s.ProxyPassword))
+	}
+	var tr http.RoundTripper = &http.Transport{
+		TLSClientConfig:       tlsCfg,
+		Proxy:                 proxy,
+		IdleConnTimeout:       durationOr(s.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   durationOr(s.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: durationOr(s.ResponseHeaderTimeout, defaultResponseHeaderTimeout),
+		// The transport asks for gzip and decompresses responses itself, but
+		// only as long as no request sets Accept-Encoding; none may.
+		DisableCompression: false,
+	}
+	if limiter := newRateLimiter(s); limiter != nil {
+		tr = &rateLimitedTransport{next: tr, limiter: limiter}
+	}
+	timeout := s.HTTPTimeoutSeconds
+	if timeout <= 0 {
+		timeout = 30
+	}
+	return &http.Client{Timeout: time.Duration(timeout) * time.Second, Transport: tr}
+}
+
+// withProxyAuth returns a proxy func that adds user as the userinfo of the
+// proxy URLs of next, so the transport sends it as Proxy-Authorization.
+// Userinfo already in a proxy URL is kept.
+func withProxyAuth(next func(*http.Request) (*url.URL, error), user *url.Userinfo) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := next(req)
+		if err != nil || u == nil || u.User != nil {
+			return u, err
+		}
+		withUser := *u
+		withUser.User = user
+		return &withUser, nil
 	}
-	return &http.Client{Timeout: 30 * time.Second, Transport: tr}
+}
+
+// Transport timeouts used when the settings leave them unset.
+const (
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 20 * time.Second
+)
+
+// durationOr returns d, or def when d isn't positive.
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// clientFor returns the HTTP client for an instance, building it on first use.
+// Reusing one client per instance keeps connections pooled across queries.
+// The client is rebuilt when any setting it depends on changes. Its requests
+// pass through the instance's circuit breaker (see breakerFor), which
+// outlives rebuilds.
+func (d *Datasource) clientFor(inst *dsInstance) *http.Client {
+	key := inst.Settings.clientKey()
+
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+	if c, ok := d.clients[inst.UID]; ok {
+		if c.key == key {
+			return c.client
+		}
+		c.client.CloseIdleConnections()
+	}
+	client := d.httpClientFor(inst.Settings)
+	client.Transport = &userAgentTransport{
+		next:      &breakerTransport{next: client.Transport, breaker: d.breakerFor(inst)},
+		userAgent: inst.Settings.UserAgent,
+	}
+	d.clients[inst.UID] = cachedClient{key: key, client: client}
+	return client
 }
 
 // ---- helpers to read instance settings directly from PluginContext ----
@@ -70,13 +184,11 @@ func getInstanceFromPluginContext(pc backend.PluginContext) (*dsInstance, error)
 // ---- QueryData ----
 
 // QueryData is the primary method for handling data queries from Grafana panels.
-// It executes the following steps:
-//  1. Parses the query from the frontend.
-//  2. Paginates through the Catalyst Center API to fetch all relevant issues,
-//     respecting the user-defined limit.
-//  3. Handles token acquisition and automatic refresh on 401/403 errors.
-//  4. Optionally enriches the data by resolving site IDs to names if the `enrich` flag is set.
-//  5. Transforms the API response into a Grafana data.Frame.
+// All queries of a request share one queryCache, so sibling refIDs with
+// identical filters reuse a single upstream fetch and site names are resolved
+// at most once per request. If one query is marked as the driver, it runs
+// first and its device IDs can be used to scope sibling queries (see
+// QueryModel.Driver and QueryModel.ScopeToDriver).
 func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	resp := backend.NewQueryDataResponse()
 
@@ -84,268 +196,912 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	if err != nil {
 		return nil, err
 	}
-	settings := inst.Settings
-	httpClient := d.httpClientFor(settings)
+	httpClient := d.clientFor(inst)
+	qc := newQueryCache()
+	seq := d.requestSeq.Add(1)
 
-	for _, q := range req.Queries {
-		dr := backend.DataResponse{}
+	driver, rest := splitDriver(req.Queries)
+	if driver != nil {
+		resp.Responses[driver.RefID] = d.query(queryLogContext(ctx, inst, seq, *driver), inst, httpClient, *driver, qc)
+	}
 
-		// 1. Unmarshal the query model sent from the frontend.
-		var qm QueryModel
-		if err := json.Unmarshal(q.JSON, &qm); err != nil {
-			dr.Error = fmt.Errorf("invalid query model: %w", err)
+	// Run the remaining queries on a bounded pool of workers.
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, inst.Settings.QueryConcurrency)
+	)
+	for _, q := range rest {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(q backend.DataQuery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			dr := d.query(queryLogContext(ctx, inst, seq, q), inst, httpClient, q, qc)
+			mu.Lock()
 			resp.Responses[q.RefID] = dr
-			continue
+			mu.Unlock()
+		}(q)
+	}
+	wg.Wait()
+
+	return resp, nil
+}
+
+// queryLogContext attaches a correlation ID for one query of one QueryData
+// call to ctx, so every log line written with log.DefaultLogger.FromContext
+// while serving it, down to token and site lookups, can be filtered by it.
+// The ID has the form "<instance UID>/<call number>/<refID>".
+func queryLogContext(ctx context.Context, inst *dsInstance, seq uint64, q backend.DataQuery) context.Context {
+	id := fmt.Sprintf("%s/%d/%s", inst.UID, seq, q.RefID)
+	return log.WithContextualAttributes(ctx, []any{"correlationId", id, "refId", q.RefID})
+}
+
+// query executes a single data query: it parses the query model sent from
+// the frontend, merged over the instance default query, and dispatches on its
+// query type.
+func (d *Datasource) query(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qc *queryCache) backend.DataResponse {
+	started := time.Now()
+	dr := d.dispatchQuery(ctx, inst, httpClient, q, qc)
+	rows := 0
+	for _, f := range dr.Frames {
+		n, _ := f.RowLen()
+		rows += n
+	}
+	log.DefaultLogger.FromContext(ctx).Debug("query done", "rows", rows, "elapsedMs", time.Since(started).Milliseconds(), "failed", dr.Error != nil)
+	return dr
+}
+
+// dispatchQuery parses the query model, merged over the instance default
+// query, and runs the handler registered for its query type in
+// queryHandlers. An empty query type means alerts; unknown ones fail.
+func (d *Datasource) dispatchQuery(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qc *queryCache) backend.DataResponse {
+	raw, err := mergeDefaultQuery(inst.Settings.DefaultQuery, q.JSON)
+	if err != nil {
+		return backend.DataResponse{Error: fmt.Errorf("invalid query model: %w", err)}
+	}
+	var qm QueryModel
+	if err := json.Unmarshal(raw, &qm); err != nil {
+		return backend.DataResponse{Error: fmt.Errorf("invalid query model: %w", err)}
+	}
+	queryType := strings.TrimSpace(qm.QueryType)
+	if queryType == "" {
+		// Queries saved before query types existed are issues queries.
+		queryType = queryTypeAlerts
+	}
+	handler, ok := queryHandlers[queryType]
+	if !ok {
+		return backend.DataResponse{Error: fmt.Errorf("unknown queryType %q: want one of %s", queryType, strings.Join(knownQueryTypes(), ", "))}
+	}
+	notice, resolved := d.resolveDeviceIPFilter(ctx, httpClient, inst, qm.DeviceID, qc)
+	qm.DeviceID = resolved
+	dr := handler(d, ctx, inst, httpClient, q, qm, qc)
+	if notice != nil && len(dr.Frames) > 0 {
+		appendNotices(dr.Frames[0], *notice)
+	}
+	renameFrames(dr.Frames, q.RefID, strings.TrimSpace(qm.FrameName))
+	return dr
+}
+
+// resolveDeviceIPFilter returns the device ID of the device whose management
+// IP is deviceID, for a deviceId filter set to an IP address. Any other value
+// is returned as it is. Resolutions are reused from qc. When the lookup
+// fails the IP is returned as it is, with a warning notice for the query.
+func (d *Datasource) resolveDeviceIPFilter(ctx context.Context, httpClient *http.Client, inst *dsInstance, deviceID string, qc *queryCache) (*data.Notice, string) {
+	ip := strings.TrimSpace(deviceID)
+	if net.ParseIP(ip) == nil {
+		return nil, deviceID
+	}
+	if id := qc.deviceIDsByIP.lookup([]string{ip})[ip]; id != "" {
+		return nil, id
+	}
+	id, err := d.getDeviceIDByIP(ctx, httpClient, inst, ip)
+	if err != nil {
+		log.DefaultLogger.FromContext(ctx).Warn("failed to resolve device IP", "ip", ip, "err", err)
+		return &data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("could not resolve device IP %s to a device ID, filtering by it as is: %v", ip, err),
+		}, deviceID
+	}
+	qc.deviceIDsByIP.store(map[string]string{ip: id})
+	return nil, id
+}
+
+// getDeviceIDByIP looks up the ID of the network device with the
+// management IP ip.
+func (d *Datasource) getDeviceIDByIP(ctx context.Context, httpClient *http.Client, inst *dsInstance, ip string) (string, error) {
+	reqURL, err := NetworkDeviceByIPURL(inst.Settings.BaseURL, ip)
+	if err != nil {
+		return "", fmt.Errorf("bad device baseUrl: %w", err)
+	}
+
+	_, body, err := d.authedGet(ctx, httpClient, inst, "device", reqURL)
+	if err != nil {
+		return "", err
+	}
+
+	var envelope DeviceByIPEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return "", fmt.Errorf("failed to decode device response: %w", err)
+	}
+	if envelope.Response.ID == "" {
+		return "", fmt.Errorf("no device with management IP %s", ip)
+	}
+	return envelope.Response.ID, nil
+}
+
+// renameFrames applies a query's FrameName to its frames: the primary frame
+// is named name instead of refID, and additional frames "<name>/<kind>". All
+// of them keep refID in Frame.RefID. An empty name leaves them as they are.
+func renameFrames(frames data.Frames, refID, name string) {
+	if name == "" {
+		return
+	}
+	for _, f := range frames {
+		switch {
+		case f.Name == refID:
+			f.Name = name
+		case strings.HasPrefix(f.Name, refID+"/"):
+			f.Name = name + strings.TrimPrefix(f.Name, refID)
 		}
-		if strings.TrimSpace(qm.QueryType) != "alerts" {
-			dr.Frames = append(dr.Frames, data.NewFrame(q.RefID))
-			resp.Responses[q.RefID] = dr
-			continue
+		f.RefID = refID
+	}
+}
+
+// queryHandler runs one query of a given query type.
+type queryHandler func(d *Datasource, ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel, qc *queryCache) backend.DataResponse
+
+// queryHandlers maps each known query type to its handler. Query types not
+// listed here are rejected with an error rather than run as issues queries.
+var queryHandlers = map[string]queryHandler{
+	queryTypeAlerts:         (*Datasource).queryIssues,
+	queryTypeIssueCount:     (*Datasource).queryIssueCount,
+	queryTypeIssueCountFast: (*Datasource).queryIssueCountFast,
+	queryTypeIssueTrend:     (*Datasource).queryIssueTrend,
+	queryTypeRaw:            (*Datasource).queryRawIssues,
+	queryTypeClientHealth: func(d *Datasource, ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel, _ *queryCache) backend.DataResponse {
+		return d.queryClientHealth(ctx, inst, httpClient, q, qm)
+	},
+	queryTypeNetworkHealth: func(d *Datasource, ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel, _ *queryCache) backend.DataResponse {
+		return d.queryNetworkHealth(ctx, inst, httpClient, q, qm)
+	},
+}
+
+// knownQueryTypes returns the registered query types, sorted.
+func knownQueryTypes() []string {
+	types := make([]string, 0, len(queryHandlers))
+	for t := range queryHandlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// issueSet holds the issues collected for one query, after scoping and
+// filtering, together with the notices raised while collecting them.
+type issueSet struct {
+	issues   []map[string]any
+	limit    int64 // hard limit the fetch ran with
+	limitHit bool  // the fetch stopped at limit; more issues may exist upstream
+	notices  []data.Notice
+}
+
+// collectIssues fetches the issues of an issues-based query and applies the
+// driver scoping and minimum-age filter. It executes the following steps:
+//  1. Resolves the query's limit and caps the scan at the instance's
+//     MaxScanPages.
+//  2. Fetches the pages with fetchIssues, reusing an identical sibling fetch,
+//     and drops duplicate issues.
+//  3. Scopes the issues to the driver query's devices and hides those younger
+//     than MinAgeSeconds, adding notices for anything the user should know.
+//
+// Issues collected before a failure are returned alongside the error.
+func (d *Datasource) collectIssues(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel, qc *queryCache) (issueSet, error) {
+	set := issueSet{limit: int64(clampLimit(inst.Settings.DefaultLimit, 100, 1, 10000))}
+	if qm.Limit != nil && *qm.Limit > 0 {
+		set.limit = *qm.Limit
+	}
+
+	// The instance's page cap bounds the scan whatever the limit asks for.
+	scanLimit := set.limit
+	if maxPages := inst.Settings.MaxScanPages; maxPages > 0 {
+		pageSize := clampLimit(inst.Settings.DefaultPageSize, 100, 1, 1000)
+		scanLimit = min(scanLimit, int64(maxPages)*int64(pageSize))
+	}
+
+	// Fetch all pages, reusing an identical sibling fetch when possible.
+	from, to := q.TimeRange.From.UnixMilli(), q.TimeRange.To.UnixMilli()
+	key := issuesCacheKey(qm, from, to, scanLimit)
+	allIssues, err := qc.issuesOnce(key, func() ([]map[string]any, error) {
+		return d.fetchIssues(ctx, inst, httpClient, qm, from, to, scanLimit)
+	})
+	var ue *APIError
+	if errors.As(err, &ue) {
+		set.notices = append(set.notices, data.Notice{
+			Severity: data.NoticeSeverityError,
+			Text:     "Catalyst Center: " + ue.Message,
+		})
+	}
+	set.limitHit = int64(len(allIssues)) >= set.limit
+	if scanLimit < set.limit && int64(len(allIssues)) >= scanLimit {
+		set.notices = append(set.notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("stopped after %d pages (%d issues), the data source's maxScanPages; results may be incomplete", inst.Settings.MaxScanPages, scanLimit),
+		})
+	}
+	allIssues = dedupeIssues(allIssues)
+
+	if _, rejected := buildAssuranceParamsFromQuery(qm, from, to, 0, 0); len(rejected) > 0 {
+		set.notices = append(set.notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "invalid filter values ignored: " + strings.Join(rejected, ", "),
+		})
+	}
+
+	if qm.Driver {
+		qc.setDriverDevices(allIssues)
+	}
+	if qm.ScopeToDriver {
+		if devices, ok := qc.driverDeviceSet(); ok {
+			allIssues = scopeToDevices(allIssues, devices)
+		} else {
+			set.notices = append(set.notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     "scopeToDriver is set but no driver query ran in this request; results are unscoped",
+			})
 		}
+	}
 
-		issuesURL, err := IssuesURL(settings.BaseURL)
+	// Hide issues that are too recent to be actionable, aged by the same
+	// time field as the Age column.
+	set.issues = filterMinAge(allIssues, qm.MinAgeSeconds, knownTimeField(qm.TimeField), from, ageReferenceMs(q.TimeRange))
+	return set, err
+}
+
+// queryIssues executes an issues ("alerts") query: it collects the issues,
+// optionally enriches them by resolving site IDs to names if the `enrich`
+// flag is set, and transforms them into a Grafana data.Frame.
+func (d *Datasource) queryIssues(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel, qc *queryCache) backend.DataResponse {
+	dr := backend.DataResponse{}
+
+	set, err := d.collectIssues(ctx, inst, httpClient, q, qm, qc)
+	if err != nil {
+		dr.Error = err
+	}
+	allIssues := set.issues
+	from := q.TimeRange.From.UnixMilli()
+
+	// An abandoned query skips the lookups; its frame is discarded anyway.
+	if err := ctx.Err(); err != nil {
+		dr.Error = err
+		return dr
+	}
+	opts, notices := d.issueFrameOptions(ctx, inst, httpClient, qm, allIssues, qc)
+	opts.AgeReferenceMs = ageReferenceMs(q.TimeRange)
+	set.notices = append(set.notices, notices...)
+
+	// Transform the issues into a Grafana data.Frame: either the full
+	// rows, or only the distinct values of one field for template variables.
+	var frame *data.Frame
+	if qm.Distinct != "" {
+		frame, err = distinctFrame(q.RefID, qm.Distinct, buildIssueRows(allIssues, opts, from))
 		if err != nil {
 			dr.Error = err
-			resp.Responses[q.RefID] = dr
-			continue
+			return dr
 		}
+	} else {
+		frame = issuesToFrame(q.RefID, allIssues, opts, from)
+	}
+	if qm.Stream && qm.Distinct == "" {
+		if frame.Meta == nil {
+			frame.SetMeta(&data.FrameMeta{})
+		}
+		frame.Meta.Channel = d.registerIssueStream(inst, qm, q.TimeRange, firstNonEmpty(strings.TrimSpace(qm.FrameName), q.RefID))
+	}
+	appendNotices(frame, set.notices...)
+	dr.Frames = append(dr.Frames, frame)
+	return dr
+}
 
-		// 2. Set up pagination. We'll loop until we either hit the hard limit
-		//    or the API returns fewer results than the page size.
-		pageSize := 25
-		offset := 0
-		var hardLimit int64 = 25
-		if qm.Limit != nil && *qm.Limit > 0 {
-			hardLimit = *qm.Limit
-		}
-
-		type row struct {
-			TimeMs   int64
-			ID       string
-			Title    string
-			Severity string
-			Status   string
-			Category string
-			Device   string
-			MAC      string
-			Site     string
-			Rule     string
-			Details  string
-		}
-		issueRows := make([]row, 0, 256)
-		allIssues := make([]map[string]any, 0, 256)
-
-		for int64(len(allIssues)) < hardLimit {
-			limitForThisPage := pageSize
-			remaining := int(hardLimit - int64(len(allIssues)))
-			if remaining < limitForThisPage {
-				limitForThisPage = remaining
+// issueFrameOptions builds the frame options of an alerts query over issues.
+// If the 'enrich' flag is set, site and device IDs are resolved to names.
+// This is done after collecting all issues to batch the lookups into one API
+// call each. Issue streams reuse it, so their frames have the columns of the
+// query frame they extend.
+func (d *Datasource) issueFrameOptions(ctx context.Context, inst *dsInstance, httpClient *http.Client, qm QueryModel, allIssues []map[string]any, qc *queryCache) (frameOptions, []data.Notice) {
+	var notices []data.Notice
+	opts := frameOptions{
+		DisplayLocation:   inst.Settings.DisplayLocation,
+		TitleTemplate:     strings.TrimSpace(qm.TitleTemplate),
+		IssueLinkTemplate: inst.Settings.IssueLinkTemplate,
+		Fields:            qm.Fields,
+		IncludeRaw:        qm.IncludeRaw,
+	}
+	if tf := strings.TrimSpace(qm.TimeField); tf != "" {
+		if _, ok := timeFields[tf]; ok {
+			opts.TimeField = tf
+		} else {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("unknown timeField %q ignored", tf),
+			})
+		}
+	}
+	// LinkBaseURL only fails for a malformed BaseURL, which already failed
+	// the fetch; links are then just relative.
+	opts.LinkBase, _ = LinkBaseURL(inst.Settings.BaseURL)
+	if qm.Enrich {
+		opts.SiteHierarchies = map[string]string{}
+		opts.SitePathSeparator = qm.SitePathSeparator
+		opts.DeviceNames = map[string]string{}
+		if len(allIssues) > 0 {
+			opts.SiteNames, opts.SiteHierarchies = d.resolveSites(ctx, httpClient, inst, allIssues, qc)
+			opts.DeviceNames = d.resolveDeviceNames(ctx, httpClient, inst, allIssues, qc)
+			if n := len(uniqueIssueValues(allIssues, "siteId")); n > 0 {
+				notices = append(notices, siteCoverageNotice(len(opts.SiteNames), n))
 			}
+		}
+	}
+	// Management IPs are a cheaper, standalone lookup that doesn't need Enrich.
+	if qm.ResolveDeviceIP {
+		opts.DeviceIPs = map[string]string{}
+		if len(allIssues) > 0 {
+			opts.DeviceIPs = d.resolveDeviceIPs(ctx, httpClient, inst, allIssues, qc)
+		}
+	}
+	return opts, notices
+}
 
-			params := buildAssuranceParamsFromQuery(
-				qm,
-				q.TimeRange.From.UnixMilli(),
-				q.TimeRange.To.UnixMilli(),
-				limitForThisPage,
-				offset+1,
-			)
+// queryIssueCount executes an issueCount query: it collects issues like an
+// alerts query, with Limit capping how many are scanned, and returns their
+// counts per priority as a single row.
+func (d *Datasource) queryIssueCount(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel, qc *queryCache) backend.DataResponse {
+	dr := backend.DataResponse{}
 
-			// 3. Get a valid token, either from cache or by fetching a new one.
-			token, err := d.tm.getToken(ctx, inst.UID, settings, httpClient)
+	set, err := d.collectIssues(ctx, inst, httpClient, q, qm, qc)
+	if err != nil {
+		dr.Error = err
+	}
+	frame := issueCountFrame(q.RefID, set.issues)
+	if set.limitHit {
+		set.notices = append(set.notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("scan limit of %d issues reached; counts may be incomplete", set.limit),
+		})
+	}
+	appendNotices(frame, set.notices...)
+	dr.Frames = append(dr.Frames, frame)
+	return dr
+}
+
+// errCountUnsupported reports that the Catalyst Center release has no issue
+// count endpoint.
+var errCountUnsupported = errors.New("issue count endpoint not available")
+
+// queryIssueCountFast executes an issueCountFast query: it asks the issue
+// count endpoint for the number of issues matching the filters, without
+// fetching them, and returns it as a single "Total" row. Releases without
+// the endpoint (404), and queries whose scopeToDriver or minAgeSeconds can
+// only be applied to fetched issues, fall back to counting a scan like
+// issueCount, with Limit capping it.
+func (d *Datasource) queryIssueCountFast(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel, qc *queryCache) backend.DataResponse {
+	dr := backend.DataResponse{}
+	from, to := q.TimeRange.From.UnixMilli(), q.TimeRange.To.UnixMilli()
+
+	var notices []data.Notice
+	if qm.ScopeToDriver || qm.MinAgeSeconds > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     "scopeToDriver and minAgeSeconds need the issues themselves; counted by scanning them",
+		})
+	} else {
+		total, err := d.fetchIssueCount(ctx, inst, httpClient, qm, from, to)
+		if !errors.Is(err, errCountUnsupported) {
 			if err != nil {
-				dr.Error = fmt.Errorf("token: %w", err)
-				break
+				dr.Error = err
+				return dr
+			}
+			frame := issueTotalFrame(q.RefID, total)
+			if _, rejected := buildAssuranceParamsFromQuery(qm, from, to, 0, 0); len(rejected) > 0 {
+				appendNotices(frame, data.Notice{
+					Severity: data.NoticeSeverityWarning,
+					Text:     "invalid filter values ignored: " + strings.Join(rejected, ", "),
+				})
 			}
+			dr.Frames = append(dr.Frames, frame)
+			return dr
+		}
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     "Catalyst Center has no issue count endpoint; counted by scanning issues",
+		})
+	}
 
-			reqURL := issuesURL + "?" + params.Encode()
-			httpReq, _ := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
-			httpReq.Header.Set("X-Auth-Token", token)
+	set, err := d.collectIssues(ctx, inst, httpClient, q, qm, qc)
+	if err != nil {
+		dr.Error = err
+	}
+	frame := issueTotalFrame(q.RefID, int64(len(set.issues)))
+	if set.limitHit {
+		set.notices = append(set.notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("scan limit of %d issues reached; counts may be incomplete", set.limit),
+		})
+	}
+	appendNotices(frame, append(notices, set.notices...)...)
+	dr.Frames = append(dr.Frames, frame)
+	return dr
+}
 
-			httpResp, err := httpClient.Do(httpReq)
-			if err != nil {
-				dr.Error = fmt.Errorf("issues request failed: %w", err)
-				break
-			}
-			body, _ := io.ReadAll(httpResp.Body)
-			httpResp.Body.Close()
-
-			// If the token has expired, the API will return 401 or 403.
-			// In this case, we force a token refresh and retry the request once.
-			if httpResp.StatusCode == http.StatusUnauthorized || httpResp.StatusCode == http.StatusForbidden {
-				log.DefaultLogger.Warn("Unauthorized; refreshing token and retrying")
-				d.tm.set(inst.UID, "") // Force refresh by clearing the cached token.
-				token, err = d.tm.getToken(ctx, inst.UID, settings, httpClient)
-				if err != nil {
-					dr.Error = fmt.Errorf("token refresh: %w", err)
-					break
-				}
-				httpReq, _ = http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
-				httpReq.Header.Set("X-Auth-Token", token)
-				httpResp, err = httpClient.Do(httpReq)
-				if err != nil {
-					dr.Error = fmt.Errorf("issues request retry failed: %w", err)
-					break
+// fetchIssueCount returns the number of issues matching the filters of qm
+// from the issue count endpoint, whose response is a CountEnvelope or a bare
+// number. It returns errCountUnsupported when the endpoint answers 404.
+func (d *Datasource) fetchIssueCount(ctx context.Context, inst *dsInstance, httpClient *http.Client, qm QueryModel, from, to int64) (int64, error) {
+	countURL, err := IssuesCountURL(inst.Settings.BaseURL)
+	if err != nil {
+		return 0, err
+	}
+	reqURL := countURL + "?" + buildAssuranceCountParams(qm, from, to).Encode()
+	_, body, err := d.authedGet(ctx, httpClient, inst, "issue count", reqURL)
+	if apiStatus(err, "issue count") == http.StatusNotFound {
+		return 0, errCountUnsupported
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if err := json.Unmarshal(body, &n); err == nil {
+		return n, nil
+	}
+	var env CountEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return 0, fmt.Errorf("failed to decode issue count response: %w", err)
+	}
+	return env.Response, nil
+}
+
+// queryIssueTrend executes an issueTrend query: it collects issues like an
+// alerts query, with Limit capping how many are scanned, and counts them per
+// status in time buckets of BucketSeconds, for graph panels.
+func (d *Datasource) queryIssueTrend(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel, qc *queryCache) backend.DataResponse {
+	dr := backend.DataResponse{}
+
+	set, err := d.collectIssues(ctx, inst, httpClient, q, qm, qc)
+	if err != nil {
+		dr.Error = err
+	}
+	timeField := strings.TrimSpace(qm.TimeField)
+	if _, ok := timeFields[timeField]; timeField != "" && !ok {
+		set.notices = append(set.notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("unknown timeField %q ignored", timeField),
+		})
+		timeField = ""
+	}
+	from, to := q.TimeRange.From.UnixMilli(), q.TimeRange.To.UnixMilli()
+	bucketMs, widened := trendBucketMs(qm.BucketSeconds, from, to)
+	if widened {
+		set.notices = append(set.notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("buckets widened to %ds to fit the time range", bucketMs/1000),
+		})
+	}
+	frame := issueTrendFrame(q.RefID, set.issues, timeField, from, to, bucketMs)
+	if set.limitHit {
+		set.notices = append(set.notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("scan limit of %d issues reached; counts may be incomplete", set.limit),
+		})
+	}
+	appendNotices(frame, set.notices...)
+	dr.Frames = append(dr.Frames, frame)
+	return dr
+}
+
+// queryRawIssues executes a "raw" query: the issues are collected like for an
+// issues query, but returned with their JSON fields as columns, unmapped.
+func (d *Datasource) queryRawIssues(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel, qc *queryCache) backend.DataResponse {
+	dr := backend.DataResponse{}
+
+	set, err := d.collectIssues(ctx, inst, httpClient, q, qm, qc)
+	if err != nil {
+		dr.Error = err
+	}
+	frame := rawIssuesFrame(q.RefID, set.issues)
+	appendNotices(frame, set.notices...)
+	dr.Frames = append(dr.Frames, frame)
+	return dr
+}
+
+// pageConcurrency bounds how many issue pages of one fetch are in flight.
+const pageConcurrency = 4
+
+// fetchIssues pages through the issues endpoint until it either hits the hard
+// limit or the API returns fewer results than the page size. When the first
+// page reports the total number of matching issues, pages beyond it are not
+// requested at all. The first page is fetched alone; when the limit calls for
+// more, the remaining pages are fetched concurrently and merged in page
+// order. Issues collected before a failing page are returned alongside the
+// error. Once ctx is done, no further pages are requested and the context
+// error is returned.
+func (d *Datasource) fetchIssues(ctx context.Context, inst *dsInstance, httpClient *http.Client, qm QueryModel, from, to, hardLimit int64) ([]map[string]any, error) {
+	settings := inst.Settings
+	issuesURL, err := IssuesURL(settings.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get a valid token once up front, either from cache or by fetching a new
+	// one, so concurrent pages don't race to authenticate.
+	token, err := d.tm.getToken(ctx, inst.UID, settings, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	auth := &pageAuth{d: d, inst: inst, client: httpClient, token: token}
+
+	pageSize := clampLimit(inst.Settings.DefaultPageSize, 100, 1, 1000)
+	// With UseQueryBody the filters travel in a JSON body and only paging
+	// and sorting stay in the URL.
+	var queryBody []byte
+	if qm.UseQueryBody {
+		if issuesURL, err = IssuesQueryURL(settings.BaseURL); err != nil {
+			return nil, err
+		}
+		if queryBody, err = json.Marshal(buildAssuranceQueryBody(qm, from, to)); err != nil {
+			return nil, err
+		}
+	}
+	fetchPage := func(offset, limit int) ([]map[string]any, int64, error) {
+		params, _ := buildAssuranceParamsFromQuery(qm, from, to, limit, offset+1)
+		if qm.UseQueryBody {
+			paging := url.Values{}
+			for _, k := range queryBodyPagingParams {
+				if v := params.Get(k); v != "" {
+					paging.Set(k, v)
 				}
-				body, _ = io.ReadAll(httpResp.Body)
-				httpResp.Body.Close()
 			}
+			params = paging
+		}
+		return d.fetchIssuesPage(ctx, inst, httpClient, issuesURL+"?"+params.Encode(), queryBody, auth)
+	}
 
-			if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
-				dr.Error = fmt.Errorf("issues endpoint returned %s: %s", httpResp.Status, string(body))
-				break
-			}
+	// Offsets and sizes of all pages the hard limit allows for.
+	type page struct{ offset, limit int }
+	var pages []page
+	for off := int64(0); off < hardLimit; off += int64(pageSize) {
+		pages = append(pages, page{int(off), int(min(int64(pageSize), hardLimit-off))})
+	}
+	if len(pages) == 0 {
+		return []map[string]any{}, nil
+	}
 
-			var env IssuesEnvelope
-			var arr []map[string]any
-			if err := json.Unmarshal(body, &env); err == nil && len(env.Response) > 0 {
-				arr = env.Response
-			} else {
-				// Some API versions might return a raw array instead of an envelope.
-				_ = json.Unmarshal(body, &arr)
+	first, total, err := fetchPage(pages[0].offset, pages[0].limit)
+	rest := pages[1:]
+	if total >= 0 {
+		for len(rest) > 0 && int64(rest[len(rest)-1].offset) >= total {
+			rest = rest[:len(rest)-1]
+		}
+	}
+	if err != nil || len(first) < pageSize || len(rest) == 0 {
+		return first, err
+	}
+
+	// Fetch the remaining pages on a bounded pool. Once a page comes back
+	// short, pages after it are skipped: they can only be empty.
+	results := make([][]map[string]any, len(rest))
+	errs := make([]error, len(rest))
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		lastIdx = len(rest) - 1 // index of the last page worth fetching
+		sem     = make(chan struct{}, pageConcurrency)
+	)
+	for i, p := range rest {
+		// Stop paging as soon as the query is abandoned.
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, p page) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			mu.Lock()
+			skip := i > lastIdx
+			mu.Unlock()
+			if skip {
+				return
 			}
-			if len(arr) == 0 {
-				// No more results, exit the pagination loop.
-				break
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				mu.Lock()
+				lastIdx = min(lastIdx, i)
+				mu.Unlock()
+				return
 			}
-
-			allIssues = append(allIssues, arr...)
-			if len(arr) < pageSize {
-				// The API returned fewer items than we asked for, so this is the last page.
-				break
+			arr, _, err := fetchPage(p.offset, p.limit)
+			results[i], errs[i] = arr, err
+			if err != nil || len(arr) < pageSize {
+				mu.Lock()
+				lastIdx = min(lastIdx, i)
+				mu.Unlock()
 			}
-			offset += pageSize
+		}(i, p)
+	}
+	wg.Wait()
+
+	allIssues := make([]map[string]any, 0, int(hardLimit))
+	allIssues = append(allIssues, first...)
+	for i := range rest {
+		if errs[i] != nil {
+			return allIssues, errs[i]
+		}
+		allIssues = append(allIssues, results[i]...)
+		if len(results[i]) < pageSize {
+			// The API returned fewer items than we asked for, so this is the last page.
+			break
 		}
+	}
+	return allIssues, nil
+}
 
-		// 4. Site Name Enrichment: If the 'enrich' flag is set, resolve site IDs to names.
-		// This is done after collecting all issues to batch the site ID lookups into one API call.
-		siteIDToNameMap := make(map[string]string)
-		if qm.Enrich && len(allIssues) > 0 {
-			uniqueSiteIDs := make(map[string]struct{})
-			for _, issue := range allIssues {
-				if siteID, ok := issue["siteId"].(string); ok && siteID != "" {
-					uniqueSiteIDs[siteID] = struct{}{}
-				}
-			}
+// pageAuth holds the token shared by the pages of one issues fetch. A page
+// rejected with 401/403 triggers at most one token refresh per fetch; pages
+// that fail concurrently wait for and reuse it.
+type pageAuth struct {
+	d      *Datasource
+	inst   *dsInstance
+	client *http.Client
+
+	mu        sync.Mutex
+	token     string
+	refreshed bool
+	err       error
+}
 
-			var siteIDs []string
-			for id := range uniqueSiteIDs {
-				siteIDs = append(siteIDs, id)
-			}
+// current returns the token pages should use.
+func (a *pageAuth) current() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.token
+}
 
-			if len(siteIDs) > 0 {
-				var err error
-				siteIDToNameMap, err = d.getSiteNamesByID(ctx, httpClient, inst, siteIDs)
-				if err != nil {
-					log.DefaultLogger.Warn("failed to resolve site names", "err", err)
-				}
-			}
+// refresh replaces a token the API rejected. Only the first call fetches a
+// new token; later calls return the outcome of that refresh.
+func (a *pageAuth) refresh(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.refreshed {
+		a.refreshed = true
+		a.d.tm.set(a.inst.UID, "", 0) // Force refresh by clearing the cached token.
+		a.token, a.err = a.d.tm.getToken(ctx, a.inst.UID, a.inst.Settings, a.client)
+	}
+	return a.token, a.err
+}
+
+// fetchIssuesPage fetches and decodes a single page of issues, along with the
+// total number of matching issues from the X-Total-Count header or the
+// envelope's totalCount; the total is -1 when the API reports neither. If the
+// token has expired, the API returns 401 or 403; the token is then refreshed
+// (once per fetch, see pageAuth) and the request retried once. A non-nil
+// payload is POSTed as JSON; otherwise the page is fetched with GET. A 429
+// response is retried once after its Retry-After (see doWithRateLimitRetry).
+func (d *Datasource) fetchIssuesPage(ctx context.Context, inst *dsInstance, httpClient *http.Client, reqURL string, payload []byte, auth *pageAuth) ([]map[string]any, int64, error) {
+	settings := inst.Settings
+	token := auth.current()
+	newReq := func() (*http.Request, error) {
+		method, reqBody := http.MethodGet, io.Reader(nil)
+		if payload != nil {
+			method, reqBody = http.MethodPost, bytes.NewReader(payload)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		settings.setAuthHeader(httpReq.Header, token)
+		if payload != nil {
+			httpReq.Header.Set("Content-Type", "application/json")
 		}
+		return httpReq, nil
+	}
 
-		// 5. Data Transformation: Convert the raw API response into a structured format
-		//    that can be used to build the Grafana data.Frame.
-		for _, it := range allIssues {
-			getStr := func(k string) string {
-				if v, ok := it[k]; ok && v != nil {
-					if s, ok2 := v.(string); ok2 {
-						return s
-					}
-				}
-				return ""
-			}
-			getNum := func(k string) int64 {
-				if v, ok := it[k]; ok && v != nil {
-					switch x := v.(type) {
-					case float64:
-						return int64(x)
-					case int64:
-						return x
-					case json.Number:
-						n, _ := x.Int64()
-						return n
-					}
-				}
-				return 0
-			}
+	logger := log.DefaultLogger.FromContext(ctx)
+	started := time.Now()
+	httpResp, body, err := doAPIRequest(ctx, httpClient, newReq, settings.RetryPolicy, "issues")
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+		logger.Warn("Unauthorized; refreshing token and retrying")
+		token, err = auth.refresh(ctx)
+		if err != nil {
+			return nil, -1, fmt.Errorf("token refresh: %w", err)
+		}
+		httpResp, body, err = doAPIRequest(ctx, httpClient, newReq, settings.RetryPolicy, "issues")
+	}
+	if err != nil {
+		return nil, -1, err
+	}
 
-			siteID := getStr("siteId")
-			siteName := siteID // Fallback to ID if enrichment is disabled or fails.
-			if name, ok := siteIDToNameMap[siteID]; ok {
-				siteName = name // Use resolved name if available.
-			}
+	total := int64(-1)
+	if n, err := strconv.ParseInt(strings.TrimSpace(httpResp.Header.Get("X-Total-Count")), 10, 64); err == nil && n >= 0 {
+		total = n
+	}
+	arr, envTotal, err := decodeIssuesPage(httpResp, body)
+	if err != nil {
+		return nil, -1, err
+	}
+	if total < 0 && envTotal != nil && *envTotal >= 0 {
+		total = *envTotal
+	}
+	logger.Debug("issues page fetched", "endpoint", redactedURL(reqURL), "status", httpResp.StatusCode, "rows", len(arr), "elapsedMs", time.Since(started).Milliseconds())
+	return arr, total, nil
+}
+
+// decodeIssuesPage decodes the body of a successful issues response: an
+// IssuesEnvelope, or a bare array on API versions without the envelope.
+// Some versions answer 200 with an object in "response" instead of an array;
+// an error-shaped object is returned as an APIError and a single issue
+// as a one-row page, so neither is mistaken for an empty result.
+func decodeIssuesPage(resp *http.Response, body []byte) ([]map[string]any, *int64, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, nil, nil
+	}
+	if trimmed[0] == '[' {
+		var arr []map[string]any
+		if err := json.Unmarshal(trimmed, &arr); err != nil {
+			return nil, nil, fmt.Errorf("decode issues response: %w", err)
+		}
+		return arr, nil, nil
+	}
 
-			r := row{
-				TimeMs:   firstNonZero(getNum("timestamp"), getNum("firstOccurredTime"), getNum("startTime")),
-				ID:       firstNonEmpty(getStr("issueId"), getStr("id"), getStr("instanceId")),
-				Title:    firstNonEmpty(getStr("name"), getStr("title"), getStr("issueTitle")),
-				Severity: firstNonEmpty(getStr("priority"), getStr("severity")),
-				Status:   firstNonEmpty(getStr("issueStatus"), getStr("status")),
-				Category: firstNonEmpty(getStr("category"), getStr("type")),
-				Device:   firstNonEmpty(getStr("deviceId"), getStr("deviceIp"), getStr("device")),
-				MAC:      firstNonEmpty(getStr("macAddress"), getStr("clientMac")),
-				Site:     siteName,
-				Rule:     getStr("ruleId"),
-				Details:  firstNonEmpty(getStr("description"), getStr("details"), getStr("issueDescription")),
+	var env struct {
+		Response   json.RawMessage `json:"response"`
+		TotalCount *int64          `json:"totalCount"`
+	}
+	if err := json.Unmarshal(trimmed, &env); err != nil {
+		return nil, nil, fmt.Errorf("decode issues response: %w", err)
+	}
+	raw := bytes.TrimSpace(env.Response)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		return nil, env.TotalCount, nil
+	case raw[0] == '[':
+		var arr []map[string]any
+		if err := json.Unmarshal(raw, &arr); err != nil {
+			return nil, nil, fmt.Errorf("decode issues response: %w", err)
+		}
+		return arr, env.TotalCount, nil
+	case raw[0] == '{':
+		var obj map[string]any
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, nil, fmt.Errorf("decode issues response: %w", err)
+		}
+		if _, ok := obj["issueId"]; ok {
+			return []map[string]any{obj}, env.TotalCount, nil
+		}
+		for _, k := range []string{"errorCode", "message", "detail", "error"} {
+			if _, ok := obj[k]; ok {
+				return nil, nil, newAPIError("issues", resp, trimmed)
 			}
-			if r.TimeMs == 0 {
-				r.TimeMs = q.TimeRange.From.UnixMilli()
+		}
+		return nil, nil, errors.New("issues endpoint returned an object instead of a list of issues")
+	default:
+		return nil, nil, errors.New("issues endpoint returned an unexpected response instead of a list of issues")
+	}
+}
+
+// resolveSites resolves the unique site IDs referenced by issues to site
+// names and name hierarchies. IDs already resolved earlier in the same request
+// are served from qc, so only the remaining ones are looked up.
+func (d *Datasource) resolveSites(ctx context.Context, httpClient *http.Client, inst *dsInstance, issues []map[string]any, qc *queryCache) (names, hierarchies map[string]string) {
+	siteIDs := uniqueIssueValues(issues, "siteId")
+	if missing := qc.siteNames.missing(siteIDs); len(missing) > 0 {
+		sites, err := d.getSitesByID(ctx, httpClient, inst, missing)
+		if err != nil {
+			log.DefaultLogger.FromContext(ctx).Warn("failed to resolve site names", "err", err)
+		}
+		names := make(map[string]string, len(sites))
+		hierarchies := make(map[string]string, len(sites))
+		for id, site := range sites {
+			names[id] = site.Name
+			if site.NameHierarchy != "" {
+				hierarchies[id] = site.NameHierarchy
 			}
-			issueRows = append(issueRows, r)
-		}
-
-		// 6. Build the Grafana data.Frame, which is the final structure that gets
-		//    sent back to the frontend for rendering.
-		frame := data.NewFrame(q.RefID)
-		fTime := data.NewField("Time", nil, make([]time.Time, 0, len(issueRows)))
-		fID := data.NewField("Issue ID", nil, make([]string, 0, len(issueRows)))
-		fTitle := data.NewField("Title", nil, make([]string, 0, len(issueRows)))
-		fSeverity := data.NewField("Priority", nil, make([]string, 0, len(issueRows)))
-		fStatus := data.NewField("Status", nil, make([]string, 0, len(issueRows)))
-		fCategory := data.NewField("Category", nil, make([]string, 0, len(issueRows)))
-		fDevice := data.NewField("Device ID", nil, make([]string, 0, len(issueRows)))
-		fMAC := data.NewField("MAC", nil, make([]string, 0, len(issueRows)))
-		fSite := data.NewField("Site Name", nil, make([]string, 0, len(issueRows)))
-		fRule := data.NewField("Rule", nil, make([]string, 0, len(issueRows)))
-		fDetails := data.NewField("Details", nil, make([]string, 0, len(issueRows)))
-
-		for _, r := range issueRows {
-			fTime.Append(time.UnixMilli(r.TimeMs))
-			fID.Append(r.ID)
-			fTitle.Append(r.Title)
-			fSeverity.Append(r.Severity)
-			fStatus.Append(r.Status)
-			fCategory.Append(r.Category)
-			fDevice.Append(r.Device)
-			fMAC.Append(r.MAC)
-			fSite.Append(r.Site)
-			fRule.Append(r.Rule)
-			fDetails.Append(r.Details)
-		}
-
-		frame.Fields = append(frame.Fields,
-			fTime, fID, fTitle, fSeverity, fStatus, fCategory, fDevice, fMAC, fSite, fRule, fDetails,
-		)
-
-		if len(issueRows) == 0 {
-			frame.SetMeta(&data.FrameMeta{
-				Notices: []data.Notice{
-					{
-						Severity: data.NoticeSeverityInfo,
-						Text:     "No issues found for the selected time range/filters",
-					},
-				},
-			})
 		}
+		qc.siteNames.store(names)
+		qc.siteHierarchies.store(hierarchies)
+	}
+	return qc.siteNames.lookup(siteIDs), qc.siteHierarchies.lookup(siteIDs)
+}
 
-		dr.Frames = append(dr.Frames, frame)
-		resp.Responses[q.RefID] = dr
+// siteCoverageNotice summarizes how many of the requested unique site IDs
+// enrichment resolved to names: informational when all did, a warning when
+// some are left showing their raw IDs.
+func siteCoverageNotice(resolved, requested int) data.Notice {
+	severity := data.NoticeSeverityInfo
+	if resolved < requested {
+		severity = data.NoticeSeverityWarning
+	}
+	return data.Notice{
+		Severity: severity,
+		Text:     fmt.Sprintf("Resolved %d/%d site names.", resolved, requested),
 	}
+}
 
-	return resp, nil
+// resolveDeviceNames resolves the unique device IDs referenced by issues to
+// hostnames, reusing earlier resolutions from qc. Unresolved IDs are left
+// out, so the frame shows the ID instead.
+func (d *Datasource) resolveDeviceNames(ctx context.Context, httpClient *http.Client, inst *dsInstance, issues []map[string]any, qc *queryCache) map[string]string {
+	deviceIDs := uniqueIssueValues(issues, "deviceId")
+	if missing := qc.deviceNames.missing(deviceIDs); len(missing) > 0 {
+		names, err := d.getDeviceNamesByID(ctx, httpClient, inst, missing)
+		if err != nil {
+			log.DefaultLogger.FromContext(ctx).Warn("failed to resolve device names", "err", err)
+		}
+		qc.deviceNames.store(names)
+	}
+	return qc.deviceNames.lookup(deviceIDs)
+}
+
+// resolveDeviceIPs resolves the unique device IDs referenced by issues to
+// their management IPs, reusing earlier resolutions from qc.
+func (d *Datasource) resolveDeviceIPs(ctx context.Context, httpClient *http.Client, inst *dsInstance, issues []map[string]any, qc *queryCache) map[string]string {
+	deviceIDs := uniqueIssueValues(issues, "deviceId")
+	if missing := qc.deviceIPs.missing(deviceIDs); len(missing) > 0 {
+		ips, err := d.getDeviceIPsByID(ctx, httpClient, inst, missing)
+		if err != nil {
+			log.DefaultLogger.FromContext(ctx).Warn("failed to resolve device management IPs", "err", err)
+		}
+		qc.deviceIPs.store(ips)
+	}
+	return qc.deviceIPs.lookup(deviceIDs)
+}
+
+// Site lookups send at most siteBatchSize IDs per request, so queries
+// spanning hundreds of sites don't exceed URL length limits (414), with up
+// to siteBatchConcurrency requests in flight.
+const (
+	siteBatchSize        = 50
+	siteBatchConcurrency = 4
+)
+
+// getSitesByID resolves a list of site IDs to their corresponding sites, in
+// concurrent batches of siteBatchSize. Sites without a name are left out. A
+// failed batch doesn't fail the others: the sites of the batches that
+// succeeded are returned along with the errors of those that failed.
+func (d *Datasource) getSitesByID(ctx context.Context, httpClient *http.Client, inst *dsInstance, siteIDs []string) (map[string]Site, error) {
+	var batches [][]string
+	for ids := range slices.Chunk(siteIDs, siteBatchSize) {
+		batches = append(batches, ids)
+	}
+	results := make([]map[string]Site, len(batches))
+	errs := make([]error, len(batches))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, siteBatchConcurrency)
+	for i, ids := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = d.getSiteBatch(ctx, httpClient, inst, ids)
+		}()
+	}
+	wg.Wait()
+
+	siteMap := make(map[string]Site)
+	for _, sites := range results {
+		maps.Copy(siteMap, sites)
+	}
+	return siteMap, errors.Join(errs...)
 }
 
-// getSiteNamesByID performs a batch lookup to resolve a list of site IDs to their
-// corresponding site names. This is more efficient than making one request per site.
-func (d *Datasource) getSiteNamesByID(ctx context.Context, httpClient *http.Client, inst *dsInstance, siteIDs []string) (map[string]string, error) {
+// getSiteBatch performs a batch lookup to resolve a list of site IDs to their
+// corresponding sites. This is more efficient than making one request per site.
+// Sites without a name are left out.
+func (d *Datasource) getSiteBatch(ctx context.Context, httpClient *http.Client, inst *dsInstance, siteIDs []string) (map[string]Site, error) {
 	siteURL, err := SiteURL(inst.Settings.BaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("bad site baseUrl: %w", err)
@@ -356,38 +1112,110 @@ func (d *Datasource) getSiteNamesByID(ctx context.Context, httpClient *http.Clie
 	params.Set("siteId", strings.Join(siteIDs, ","))
 	reqURL := siteURL + "?" + params.Encode()
 
-	token, err := d.tm.getToken(ctx, inst.UID, inst.Settings, httpClient)
+	started := time.Now()
+	httpResp, body, err := d.authedGet(ctx, httpClient, inst, "site", reqURL)
 	if err != nil {
-		return nil, fmt.Errorf("token for site lookup: %w", err)
+		return nil, err
 	}
 
-	httpReq, _ := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
-	httpReq.Header.Set("X-Auth-Token", token)
-	httpReq.Header.Set("Accept", "application/json")
+	var envelope SiteEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode site response: %w", err)
+	}
 
-	httpResp, err := httpClient.Do(httpReq)
+	siteMap := make(map[string]Site)
+	for _, site := range envelope.Response {
+		if site.ID != "" && site.Name != "" {
+			siteMap[site.ID] = site
+		}
+	}
+	log.DefaultLogger.FromContext(ctx).Debug("sites resolved", "endpoint", siteURL, "status", httpResp.StatusCode, "rows", len(siteMap), "elapsedMs", time.Since(started).Milliseconds())
+	return siteMap, nil
+}
+
+// getSiteIDsByHierarchy returns the IDs of the sites whose name hierarchy
+// matches hierarchy, e.g. "Global/USA/NYC". The site API answers an unknown
+// hierarchy with 404, which is reported as no match rather than an error.
+func (d *Datasource) getSiteIDsByHierarchy(ctx context.Context, httpClient *http.Client, inst *dsInstance, hierarchy string) ([]string, error) {
+	siteURL, err := SiteURL(inst.Settings.BaseURL)
 	if err != nil {
-		return nil, fmt.Errorf("site request failed: %w", err)
+		return nil, fmt.Errorf("bad site baseUrl: %w", err)
 	}
-	defer httpResp.Body.Close()
+	params := url.Values{}
+	params.Set("groupNameHierarchy", hierarchy)
+	reqURL := siteURL + "?" + params.Encode()
 
-	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
-		body, _ := io.ReadAll(httpResp.Body)
-		return nil, fmt.Errorf("site endpoint returned %s: %s", httpResp.Status, string(body))
+	_, body, err := d.authedGet(ctx, httpClient, inst, "site", reqURL)
+	ids := []string{}
+	if apiStatus(err, "site") == http.StatusNotFound {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	var envelope SiteEnvelope
-	if err := json.NewDecoder(httpResp.Body).Decode(&envelope); err != nil {
+	if err := json.Unmarshal(body, &envelope); err != nil {
 		return nil, fmt.Errorf("failed to decode site response: %w", err)
 	}
+	for _, site := range envelope.Response {
+		if site.ID != "" {
+			ids = append(ids, site.ID)
+		}
+	}
+	return ids, nil
+}
+
+// getDeviceIPsByID performs a batch lookup of management IPs for a list of
+// device IDs. It is independent of Enrich; see getDevicesByID.
+func (d *Datasource) getDeviceIPsByID(ctx context.Context, httpClient *http.Client, inst *dsInstance, deviceIDs []string) (map[string]string, error) {
+	devices, err := d.getDevicesByID(ctx, httpClient, inst, deviceIDs)
+	ipMap := make(map[string]string)
+	for _, dev := range devices {
+		if dev.ID != "" && dev.ManagementIP != "" {
+			ipMap[dev.ID] = dev.ManagementIP
+		}
+	}
+	return ipMap, err
+}
 
+// getDeviceNamesByID performs a batch lookup to resolve a list of device IDs
+// to their hostnames, mirroring getSitesByID for sites.
+func (d *Datasource) getDeviceNamesByID(ctx context.Context, httpClient *http.Client, inst *dsInstance, deviceIDs []string) (map[string]string, error) {
+	devices, err := d.getDevicesByID(ctx, httpClient, inst, deviceIDs)
 	nameMap := make(map[string]string)
-	for _, site := range envelope.Response {
-		if site.ID != "" && site.Name != "" {
-			nameMap[site.ID] = site.Name
+	for _, dev := range devices {
+		if dev.ID != "" && dev.Hostname != "" {
+			nameMap[dev.ID] = dev.Hostname
 		}
 	}
-	return nameMap, nil
+	return nameMap, err
+}
+
+// getDevicesByID performs a batch lookup of network devices by ID. Only the
+// few Device attributes are decoded, which keeps this much cheaper than a
+// full device inventory fetch.
+func (d *Datasource) getDevicesByID(ctx context.Context, httpClient *http.Client, inst *dsInstance, deviceIDs []string) ([]Device, error) {
+	deviceURL, err := NetworkDeviceURL(inst.Settings.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad device baseUrl: %w", err)
+	}
+
+	// The network-device endpoint accepts a comma-separated list of IDs.
+	params := url.Values{}
+	params.Set("id", strings.Join(deviceIDs, ","))
+	reqURL := deviceURL + "?" + params.Encode()
+
+	_, body, err := d.authedGet(ctx, httpClient, inst, "device", reqURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope DeviceEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode device response: %w", err)
+	}
+	return envelope.Response, nil
 }
 
 // ---- CheckHealth ----
@@ -404,50 +1232,136 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 		}, nil
 	}
 	settings := inst.Settings
-	httpClient := d.httpClientFor(settings)
+	httpClient := d.clientFor(inst)
+
+	details := healthDetails{TokenSource: "fetched", Paths: resolvePaths(settings)}
+	if settings.APIToken != "" {
+		details.TokenSource = "manual"
+	}
 
 	// 1. Verify that we can obtain an authentication token.
-	if _, err := d.tm.getToken(ctx, inst.UID, settings, httpClient); err != nil {
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "token: " + err.Error(),
-		}, nil
+	tok, err := d.tm.getToken(ctx, inst.UID, settings, httpClient)
+	if err != nil {
+		return details.result(backend.HealthStatusError, "token: "+err.Error()), nil
 	}
 
 	issuesURL, err := IssuesURL(settings.BaseURL)
 	if err != nil {
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "invalid base URL",
-		}, nil
+		return details.result(backend.HealthStatusError, "invalid base URL"), nil
 	}
 
-	// 2. Make a lightweight test query to the issues endpoint.
-	u := issuesURL + "?limit=1"
-	reqHTTP, _ := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
-	tok, _ := d.tm.getToken(ctx, inst.UID, settings, httpClient)
-	reqHTTP.Header.Set("X-Auth-Token", tok)
+	// 2. Make a lightweight test query to the issues endpoint. Together with
+	// the token this is a hard requirement.
+	details.IssuesURL = issuesURL + "?limit=1"
+	checks := []healthCheck{{Name: "token"}}
+	started := time.Now()
+	status, err := probeEndpoint(ctx, httpClient, settings, details.IssuesURL, tok)
+	details.HTTPStatus, details.LatencyMs = status, time.Since(started).Milliseconds()
+	checks = append(checks, healthCheck{Name: "issues", Err: err})
+	if err != nil {
+		return details.result(backend.HealthStatusError, "Failed to query Catalyst Center issues\n"+formatHealthChecks(checks)), nil
+	}
+
+	// 3. Probe the site endpoint used by enrichment. Accounts whose role
+	// can't read sites still work, so a failure only degrades the result.
+	siteCheck := healthCheck{Name: "site"}
+	if siteURL, err := SiteURL(settings.BaseURL); err != nil {
+		siteCheck.Err = err
+	} else {
+		_, siteCheck.Err = probeEndpoint(ctx, httpClient, settings, siteURL+"?limit=1", tok)
+	}
+	checks = append(checks, siteCheck)
+
+	msg := "Successfully connected to Catalyst Center"
+	if siteCheck.Err != nil {
+		msg = "Connected to Catalyst Center, but site enrichment is unavailable"
+	}
+	msg += "\n" + formatHealthChecks(checks)
+	if _, err := parseIssueLinkTemplate(settings.IssueLinkTemplate); err != nil {
+		log.DefaultLogger.Warn("invalid issue link template", "err", err)
+		msg += "\nwarning: invalid issue link template, the default is used: " + err.Error()
+	}
+	return details.result(backend.HealthStatusOk, msg), nil
+}
+
+// healthDetails are the CheckHealth diagnostics returned as JSONDetails, to
+// help debug e.g. a wrong proxy prefix from the config page.
+type healthDetails struct {
+	TokenSource string        `json:"tokenSource"` // "manual" or "fetched"
+	Paths       resolvedPaths `json:"paths"`
+	IssuesURL   string        `json:"issuesUrl,omitempty"`
+	HTTPStatus  int           `json:"httpStatus,omitempty"`
+	LatencyMs   int64         `json:"latencyMs"`
+}
+
+// resolvedPaths are the endpoint URLs the base URL and token path resolve
+// to, so admins can check the reverse proxy prefix was applied as intended
+// before debugging connectivity.
+type resolvedPaths struct {
+	Token         string `json:"token"`
+	Issues        string `json:"issues"`
+	Site          string `json:"site"`
+	ClientHealth  string `json:"clientHealth"`
+	NetworkHealth string `json:"networkHealth"`
+}
+
+// resolvePaths resolves the endpoint URLs of s without calling them. URLs
+// that can't be built are left empty.
+func resolvePaths(s *InstanceSettings) resolvedPaths {
+	var p resolvedPaths
+	p.Token, _ = TokenURL(s.BaseURL, s.TokenPath)
+	p.Issues, _ = IssuesURL(s.BaseURL)
+	p.Site, _ = SiteURL(s.BaseURL)
+	p.ClientHealth, _ = ClientHealthURL(s.BaseURL)
+	p.NetworkHealth, _ = NetworkHealthURL(s.BaseURL)
+	return p
+}
+
+// result builds a CheckHealthResult carrying the details.
+func (h healthDetails) result(status backend.HealthStatus, msg string) *backend.CheckHealthResult {
+	b, _ := json.Marshal(h)
+	return &backend.CheckHealthResult{Status: status, Message: msg, JSONDetails: b}
+}
 
-	httpResp, err := httpClient.Do(reqHTTP)
+// healthCheck is the outcome of one CheckHealth sub-check; a nil Err means
+// it passed.
+type healthCheck struct {
+	Name string
+	Err  error
+}
+
+// formatHealthChecks lists the sub-check results one per line, e.g.
+// "- site: 403 Forbidden".
+func formatHealthChecks(checks []healthCheck) string {
+	lines := make([]string, 0, len(checks))
+	for _, c := range checks {
+		result := "OK"
+		if c.Err != nil {
+			result = c.Err.Error()
+		}
+		lines = append(lines, "- "+c.Name+": "+result)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// probeEndpoint GETs reqURL with the token and returns the HTTP status. It
+// reports a transport error, or a non-2xx status with the start of the
+// response body.
+func probeEndpoint(ctx context.Context, httpClient *http.Client, s *InstanceSettings, reqURL, token string) (int, error) {
+	httpReq, err := jsonGetRequest(ctx, s, reqURL, token)()
 	if err != nil {
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "issues probe failed: " + err.Error(),
-		}, nil
+		return 0, err
+	}
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return 0, err
 	}
 	defer httpResp.Body.Close()
-
-	if httpResp.StatusCode >= 200 && httpResp.StatusCode < 300 {
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusOk,
-			Message: "Successfully connected to Catalyst Center (issues)",
-		}, nil
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(httpResp.Body, 256))
+		return httpResp.StatusCode, fmt.Errorf("%s: %s", httpResp.Status, strings.TrimSpace(string(b)))
 	}
-	b, _ := io.ReadAll(httpResp.Body)
-	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusError,
-		Message: fmt.Sprintf("issues probe %s: %s", httpResp.Status, string(b)),
-	}, nil
+	return httpResp.StatusCode, nil
 }
 
 // ---- CallResource passthrough (honors TLS flag as well) ----
@@ -463,13 +1377,30 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 			Body:   []byte("instance error: " + err.Error()),
 		})
 	}
-	settings := inst.Settings
-	httpClient := d.httpClientFor(settings)
+	httpClient := d.clientFor(inst)
 
 	switch req.Path {
 	case "issues":
 		// The 'issues' resource path is used by the frontend to populate template variables.
 		return d.resourceIssues(ctx, inst, req, sender, httpClient)
+	case "sites":
+		return d.resourceSites(ctx, inst, req, sender, httpClient)
+	case "devices":
+		return d.resourceDevices(ctx, inst, req, sender, httpClient)
+	case "siteId":
+		return d.resourceSiteID(ctx, inst, req, sender, httpClient)
+	case "issue-detail":
+		return d.resourceIssueDetail(ctx, inst, req, sender, httpClient)
+	case "token/info", "token-status":
+		return d.resourceTokenInfo(inst, req, sender)
+	case "refresh-token":
+		return d.resourceRefreshToken(ctx, inst, req, sender, httpClient)
+	case "filter-options":
+		return d.resourceFilterOptions(req, sender)
+	case "rules":
+		return d.resourceRules(ctx, inst, req, sender, httpClient)
+	case "health":
+		return d.resourceHealth(ctx, req, sender)
 	default:
 		return sender.Send(&backend.CallResourceResponse{
 			Status: http.StatusNotFound,
@@ -479,31 +1410,146 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 }
 
 // resourceIssues handles requests to the /issues resource path. It forwards the
-// query parameters from the frontend to the Catalyst Center issues API.
+// query parameters from the frontend to the Catalyst Center issues API, with
+// page/pageSize translated to its limit and one-based offset (see
+// normalizeResourcePaging) and the filters normalized like those of panel
+// queries (see normalizeResourceFilters).
 func (d *Datasource) resourceIssues(ctx context.Context, inst *dsInstance, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, httpClient *http.Client) error {
 	issuesURL, err := IssuesURL(inst.Settings.BaseURL)
 	if err != nil {
 		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadRequest, Body: []byte("bad baseUrl")})
 	}
 
-	var rawQuery string
-	if req.URL != "" {
-		if u, err := url.Parse(req.URL); err == nil {
-			rawQuery = u.RawQuery
+	q := ""
+	params := normalizeResourceFilters(normalizeResourcePaging(resourceQuery(req), inst.Settings.DefaultPageSize))
+	if rawQuery := params.Encode(); rawQuery != "" {
+		q = "?" + rawQuery
+	}
+	return d.proxyGet(ctx, inst, sender, httpClient, issuesURL+q)
+}
+
+// resourceSites handles requests to the /sites resource path, used to build
+// site variables. Only the site API's "type" and "name" filters are passed
+// on; the raw JSON response is returned.
+func (d *Datasource) resourceSites(ctx context.Context, inst *dsInstance, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, httpClient *http.Client) error {
+	siteURL, err := SiteURL(inst.Settings.BaseURL)
+	if err != nil || inst.Settings.BaseURL == "" {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadRequest, Body: []byte("bad baseUrl")})
+	}
+
+	in := resourceQuery(req)
+	params := url.Values{}
+	for _, k := range []string{"type", "name"} {
+		if v := strings.TrimSpace(in.Get(k)); v != "" {
+			params.Set(k, v)
 		}
 	}
+	if len(params) > 0 {
+		siteURL += "?" + params.Encode()
+	}
+	return d.proxyGet(ctx, inst, sender, httpClient, siteURL)
+}
+
+// resourceDevices handles requests to the /devices resource path, used to
+// build device variables. Like /issues, all query parameters (offset, limit
+// and the network-device filters such as hostname or family) are forwarded.
+func (d *Datasource) resourceDevices(ctx context.Context, inst *dsInstance, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, httpClient *http.Client) error {
+	deviceURL, err := NetworkDeviceURL(inst.Settings.BaseURL)
+	if err != nil || inst.Settings.BaseURL == "" {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadRequest, Body: []byte("bad baseUrl")})
+	}
 
-	q := ""
-	if rawQuery != "" {
-		q = "?" + rawQuery
+	if rawQuery := resourceQuery(req).Encode(); rawQuery != "" {
+		deviceURL += "?" + rawQuery
 	}
+	return d.proxyGet(ctx, inst, sender, httpClient, deviceURL)
+}
 
-	httpReq, _ := http.NewRequestWithContext(ctx, http.MethodGet, issuesURL+q, nil)
+// resourceSiteID handles GET /siteId?hierarchy=Global/USA/NYC. It resolves a
+// site name hierarchy to the matching site ID(s) via the site API's
+// groupNameHierarchy filter, so variables can hold human-readable paths. A
+// hierarchy that matches nothing yields an empty list with 200.
+func (d *Datasource) resourceSiteID(ctx context.Context, inst *dsInstance, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, httpClient *http.Client) error {
+	hierarchy := strings.Trim(strings.TrimSpace(resourceQuery(req).Get("hierarchy")), "/")
+	if hierarchy == "" {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadRequest, Body: []byte("missing hierarchy")})
+	}
+	if inst.Settings.BaseURL == "" {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadRequest, Body: []byte("bad baseUrl")})
+	}
+
+	ids, err := d.getSiteIDsByHierarchy(ctx, httpClient, inst, hierarchy)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadGateway, Body: []byte(err.Error())})
+	}
+	body, err := json.Marshal(struct {
+		Hierarchy string   `json:"hierarchy"`
+		SiteIDs   []string `json:"siteIds"`
+	}{hierarchy, ids})
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusInternalServerError, Body: []byte(err.Error())})
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  http.StatusOK,
+		Body:    body,
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+	})
+}
+
+// resourceIssueDetail handles GET /issue-detail?id=<issueId>. It returns the
+// full JSON of one issue, for drilldown panels. An issue the API doesn't
+// know is answered with 404; other upstream failures with 502.
+func (d *Datasource) resourceIssueDetail(ctx context.Context, inst *dsInstance, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, httpClient *http.Client) error {
+	if req.Method != "" && req.Method != http.MethodGet {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusMethodNotAllowed, Body: []byte("method not allowed")})
+	}
+	id := strings.TrimSpace(resourceQuery(req).Get("id"))
+	if id == "" {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadRequest, Body: []byte("missing id")})
+	}
+	reqURL, err := IssueDetailURL(inst.Settings.BaseURL, id)
+	if err != nil || inst.Settings.BaseURL == "" {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadRequest, Body: []byte("bad baseUrl")})
+	}
+
+	httpResp, body, err := d.authedGet(ctx, httpClient, inst, "issue detail", reqURL)
+	if apiStatus(err, "issue detail") == http.StatusNotFound {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusNotFound, Body: []byte(fmt.Sprintf("issue %q not found", id))})
+	}
+	if err != nil {
+		status := http.StatusBadGateway
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Endpoint == "token" {
+			status = tokenFailureStatus(err)
+		}
+		return sender.Send(&backend.CallResourceResponse{Status: status, Body: []byte(err.Error())})
+	}
+	headers := forwardedHeaders(httpResp.Header, inst.Settings.ForwardHeaders)
+	headers["Content-Type"] = []string{"application/json"}
+	return sender.Send(&backend.CallResourceResponse{Status: http.StatusOK, Body: body, Headers: headers})
+}
+
+// resourceQuery returns the query parameters of a resource request.
+func resourceQuery(req *backend.CallResourceRequest) url.Values {
+	if req.URL == "" {
+		return url.Values{}
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return url.Values{}
+	}
+	return u.Query()
+}
+
+// proxyGet performs an authenticated GET of reqURL and sends the upstream
+// status and body back, along with the forwarded headers.
+func (d *Datasource) proxyGet(ctx context.Context, inst *dsInstance, sender backend.CallResourceResponseSender, httpClient *http.Client, reqURL string) error {
+	httpReq, _ := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	tok, err := d.tm.getToken(ctx, inst.UID, inst.Settings, httpClient)
 	if err != nil {
-		return sender.Send(&backend.CallResourceResponse{Status: http.StatusUnauthorized, Body: []byte("token: " + err.Error())})
+		return sender.Send(&backend.CallResourceResponse{Status: tokenFailureStatus(err), Body: []byte("token: " + err.Error())})
 	}
-	httpReq.Header.Set("X-Auth-Token", tok)
+	inst.Settings.setAuthHeader(httpReq.Header, tok)
 
 	httpResp, err := httpClient.Do(httpReq)
 	if err != nil {
@@ -512,15 +1558,300 @@ func (d *Datasource) resourceIssues(ctx context.Context, inst *dsInstance, req *
 	defer httpResp.Body.Close()
 	body, _ := io.ReadAll(httpResp.Body)
 
+	headers := forwardedHeaders(httpResp.Header, inst.Settings.ForwardHeaders)
+	headers["Content-Type"] = []string{"application/json"}
 	return sender.Send(&backend.CallResourceResponse{
 		Status:  httpResp.StatusCode,
 		Body:    body,
+		Headers: headers,
+	})
+}
+
+// resourceTokenInfo handles GET /token/info and its alias /token-status. It
+// reports how the cached token's expiry was derived and how long it has left,
+// never the token itself. With a manual API token configured there is no
+// expiry to report.
+func (d *Datasource) resourceTokenInfo(inst *dsInstance, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if req.Method != "" && req.Method != http.MethodGet {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusMethodNotAllowed, Body: []byte("method not allowed")})
+	}
+
+	var out struct {
+		Manual bool `json:"manual"`
+		tokenInfo
+	}
+	out.Manual = strings.TrimSpace(inst.Settings.APIToken) != ""
+	if !out.Manual {
+		out.tokenInfo = d.tm.info(inst.UID)
+	}
+
+	body, err := json.Marshal(out)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusInternalServerError, Body: []byte(err.Error())})
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  http.StatusOK,
+		Body:    body,
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+	})
+}
+
+// resourceFilterOptions handles GET /filter-options. It returns the filter
+// values queries accept (see filterOptions), e.g. for editor dropdowns.
+func (d *Datasource) resourceFilterOptions(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if req.Method != "" && req.Method != http.MethodGet {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusMethodNotAllowed, Body: []byte("method not allowed")})
+	}
+	body, err := json.Marshal(knownFilterOptions())
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusInternalServerError, Body: []byte(err.Error())})
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  http.StatusOK,
+		Body:    body,
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+	})
+}
+
+// resourceRefreshToken handles POST /refresh-token. It drops the cached token
+// of the instance and fetches a fresh one right away, so rotated credentials
+// take effect without waiting for a 401. With a manual API token configured
+// there is nothing to refresh and the call is a no-op.
+func (d *Datasource) resourceRefreshToken(ctx context.Context, inst *dsInstance, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, httpClient *http.Client) error {
+	if req.Method != http.MethodPost {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusMethodNotAllowed, Body: []byte("method not allowed")})
+	}
+
+	var out struct {
+		Refreshed bool   `json:"refreshed"`
+		Message   string `json:"message"`
+		tokenInfo
+	}
+	status := http.StatusOK
+	if strings.TrimSpace(inst.Settings.APIToken) != "" {
+		out.Message = "a manual API token is configured; nothing to refresh"
+	} else {
+		// An empty token counts as a cache miss, forcing getToken to fetch.
+		d.tm.set(inst.UID, "", 0)
+		if _, err := d.tm.getToken(ctx, inst.UID, inst.Settings, httpClient); err != nil {
+			status = http.StatusBadGateway
+			out.Message = "token refresh failed: " + err.Error()
+		} else {
+			out.Refreshed = true
+			out.Message = "token refreshed"
+			out.tokenInfo = d.tm.info(inst.UID)
+		}
+	}
+
+	body, err := json.Marshal(out)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusInternalServerError, Body: []byte(err.Error())})
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  status,
+		Body:    body,
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+	})
+}
+
+// resourceHealth handles GET /health. It runs the CheckHealth probes and
+// returns the result as JSON, with 200 when healthy and 503 otherwise, so the
+// query editor can show a connection indicator.
+func (d *Datasource) resourceHealth(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if req.Method != "" && req.Method != http.MethodGet {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusMethodNotAllowed, Body: []byte("method not allowed")})
+	}
+
+	res, _ := d.CheckHealth(ctx, &backend.CheckHealthRequest{PluginContext: req.PluginContext})
+	out := struct {
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details,omitempty"`
+	}{res.Status.String(), res.Message, res.JSONDetails}
+
+	body, err := json.Marshal(out)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusInternalServerError, Body: []byte(err.Error())})
+	}
+	status := http.StatusOK
+	if res.Status != backend.HealthStatusOk {
+		status = http.StatusServiceUnavailable
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  status,
+		Body:    body,
 		Headers: map[string][]string{"Content-Type": {"application/json"}},
 	})
 }
 
 // ---- helpers ----
 
+// APIError is a failed response from a Catalyst Center endpoint: a non-2xx
+// status, or a 2xx whose body is an error. When the body is the standard
+// error envelope, Message holds its decoded message and detail; otherwise it
+// holds the raw body. Callers tell failures apart with errors.As, e.g. a
+// rejected token from an unavailable cluster.
+type APIError struct {
+	Endpoint   string // e.g. "issues" or "token"
+	StatusCode int    // e.g. 403
+	Status     string // e.g. "403 Forbidden"
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s endpoint returned %s: %s", e.Endpoint, e.Status, e.Message)
+}
+
+// newAPIError builds an APIError for resp, decoding body when it is an
+// ErrorEnvelope, e.g. "Invalid input: siteId is not valid (NCND00009)".
+func newAPIError(endpoint string, resp *http.Response, body []byte) *APIError {
+	e := &APIError{Endpoint: endpoint, StatusCode: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(body))}
+	var env ErrorEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return e
+	}
+	r := env.Response
+	var parts []string
+	for _, p := range []string{r.Message, r.Detail} {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return e
+	}
+	e.Message = strings.Join(parts, ": ")
+	if r.ErrorCode != "" {
+		e.Message += " (" + r.ErrorCode + ")"
+	}
+	return e
+}
+
+// tokenFailureStatus returns the status a resource call answers a token
+// failure with: 502 when the token endpoint failed for another reason than
+// rejecting the credentials (e.g. a 5xx), 401 otherwise.
+func tokenFailureStatus(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode != http.StatusUnauthorized && apiErr.StatusCode != http.StatusForbidden {
+		return http.StatusBadGateway
+	}
+	return http.StatusUnauthorized
+}
+
+// redactedURL returns rawURL with any password masked, for logging.
+func redactedURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// apiStatus returns the status code of the APIError from endpoint in err's
+// chain, or 0 when there is none.
+func apiStatus(err error, endpoint string) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Endpoint == endpoint {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// doAPIRequest sends the request built by newReq with the retry policy (see
+// doWithRateLimitRetry) and reads the response body. A non-2xx response is
+// returned as an APIError for endpoint; other failures are wrapped as
+// "<endpoint> request failed".
+func doAPIRequest(ctx context.Context, httpClient *http.Client, newReq func() (*http.Request, error), policy RetryPolicy, endpoint string) (*http.Response, []byte, error) {
+	httpResp, err := doWithRateLimitRetry(ctx, httpClient, newReq, policy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s request failed: %w", endpoint, err)
+	}
+	body, _ := io.ReadAll(httpResp.Body)
+	httpResp.Body.Close()
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		return nil, nil, newAPIError(endpoint, httpResp, body)
+	}
+	return httpResp, body, nil
+}
+
+// authedGet performs an authenticated JSON GET of reqURL with the instance's
+// retry policy and returns the response with its body already read. If the
+// API rejects the token with 401 or 403, the cached token is dropped, a fresh
+// one fetched and the request retried once, as the issue pages do; a 429 is
+// retried once after its Retry-After, like them too. Failures are reported
+// as doAPIRequest does.
+func (d *Datasource) authedGet(ctx context.Context, httpClient *http.Client, inst *dsInstance, endpoint, reqURL string) (*http.Response, []byte, error) {
+	token, err := d.tm.getToken(ctx, inst.UID, inst.Settings, httpClient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s request failed: token: %w", endpoint, err)
+	}
+	httpResp, body, err := doAPIRequest(ctx, httpClient, jsonGetRequest(ctx, inst.Settings, reqURL, token), inst.Settings.RetryPolicy, endpoint)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || (apiErr.StatusCode != http.StatusUnauthorized && apiErr.StatusCode != http.StatusForbidden) {
+		return httpResp, body, err
+	}
+
+	log.DefaultLogger.FromContext(ctx).Warn("Unauthorized; refreshing token and retrying", "endpoint", redactedURL(reqURL))
+	d.tm.set(inst.UID, "", 0) // Force refresh by clearing the cached token.
+	token, err = d.tm.getToken(ctx, inst.UID, inst.Settings, httpClient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s request failed: token refresh: %w", endpoint, err)
+	}
+	return doAPIRequest(ctx, httpClient, jsonGetRequest(ctx, inst.Settings, reqURL, token), inst.Settings.RetryPolicy, endpoint)
+}
+
+// jsonGetRequest returns a factory for authenticated GET requests that accept JSON.
+func jsonGetRequest(ctx context.Context, s *InstanceSettings, reqURL, token string) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.setAuthHeader(httpReq.Header, token)
+		httpReq.Header.Set("Accept", "application/json")
+		return httpReq, nil
+	}
+}
+
+// defaultForwardHeaders are the upstream response headers resource calls
+// forward by default: request IDs and rate-limit state.
+var defaultForwardHeaders = []string{
+	"X-Request-Id",
+	"X-Correlation-Id",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+	"Retry-After",
+}
+
+// neverForwardHeaders are withheld from resource responses even when listed
+// in the allow-list, as they carry session or credential material.
+var neverForwardHeaders = map[string]struct{}{
+	"Set-Cookie":          {},
+	"Cookie":              {},
+	"Authorization":       {},
+	"Proxy-Authorization": {},
+	"Www-Authenticate":    {},
+	"Proxy-Authenticate":  {},
+	"X-Auth-Token":        {},
+}
+
+// forwardedHeaders returns the upstream headers named in allow, minus those
+// in neverForwardHeaders. Header names are matched case-insensitively.
+func forwardedHeaders(upstream http.Header, allow []string) map[string][]string {
+	out := make(map[string][]string)
+	for _, name := range allow {
+		key := http.CanonicalHeaderKey(name)
+		if _, blocked := neverForwardHeaders[key]; blocked {
+			continue
+		}
+		if vals := upstream.Values(key); len(vals) > 0 {
+			out[key] = append([]string(nil), vals...)
+		}
+	}
+	return out
+}
+
 // firstNonEmpty returns the first non-empty string from a list of arguments.
 // This is useful for coalescing values from multiple possible API fields.
 func firstNonEmpty(vals ...string) string {
@@ -0,0 +1,1918 @@
+package backend
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// testPluginContext returns a plugin context for an instance pointing at baseURL
+// that uses a manual API token, so no auth round-trip is needed.
+func testPluginContext(baseURL string) backend.PluginContext {
+	return backend.PluginContext{
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			UID:                     "test-uid",
+			JSONData:                []byte(`{"baseUrl":"` + baseURL + `"}`),
+			DecryptedSecureJSONData: map[string]string{"apiToken": "tok"},
+		},
+	}
+}
+
+// testPagedPluginContext is testPluginContext with a page size of 25, so
+// paging tests stay small.
+func testPagedPluginContext(baseURL string) backend.PluginContext {
+	pc := testPluginContext(baseURL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + baseURL + `","defaultPageSize":25}`)
+	return pc
+}
+
+func testQuery(refID, jsonModel string) backend.DataQuery {
+	now := time.Now()
+	return backend.DataQuery{
+		RefID:     refID,
+		JSON:      json.RawMessage(jsonModel),
+		TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+	}
+}
+
+func TestQueryData_SharesIdenticalFetch(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1","deviceId":"d1"}]}`))
+	}))
+	defer srv.Close()
+
+	q := `{"queryType":"alerts","priority":["P1"]}`
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", q), testQuery("B", q)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("upstream calls = %d, want 1", got)
+	}
+	for _, ref := range []string{"A", "B"} {
+		if n, _ := resp.Responses[ref].Frames[0].RowLen(); n != 1 {
+			t.Fatalf("%s rows = %d, want 1", ref, n)
+		}
+	}
+}
+
+func TestQueryData_ScopeToDriver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("priority") == "P1" {
+			_, _ = w.Write([]byte(`{"response":[{"issueId":"i1","deviceId":"d1"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i2","deviceId":"d1"},{"issueId":"i3","deviceId":"d2"}]}`))
+	}))
+	defer srv.Close()
+
+	// The driver is listed last on purpose; it must still run first.
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries: []backend.DataQuery{
+			testQuery("B", `{"queryType":"alerts","scopeToDriver":true}`),
+			testQuery("A", `{"queryType":"alerts","priority":["P1"],"driver":true}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	frame := resp.Responses["B"].Frames[0]
+	if n, _ := frame.RowLen(); n != 1 {
+		t.Fatalf("scoped rows = %d, want 1", n)
+	}
+	if id := frame.Fields[1].At(0).(string); id != "i2" {
+		t.Fatalf("scoped issue = %q, want i2", id)
+	}
+}
+
+// callResource invokes d.CallResource and returns the single response sent.
+func callResource(t *testing.T, d *Datasource, req *backend.CallResourceRequest) *backend.CallResourceResponse {
+	t.Helper()
+	var got *backend.CallResourceResponse
+	err := d.CallResource(context.Background(), req, backend.CallResourceResponseSenderFunc(func(r *backend.CallResourceResponse) error {
+		got = r
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("CallResource error: %v", err)
+	}
+	if got == nil {
+		t.Fatal("CallResource sent no response")
+	}
+	return got
+}
+
+func TestResourceTokenInfo(t *testing.T) {
+	d := NewDatasource()
+	pc := backend.PluginContext{
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			UID:                     "uid-1",
+			JSONData:                []byte(`{"baseUrl":"https://dnac.local"}`),
+			DecryptedSecureJSONData: map[string]string{"username": "u", "password": "p"},
+		},
+	}
+	expAt := time.Now().Add(10 * time.Minute).Unix()
+	d.tm.setWithExpiry("uid-1", "secret-token", expAt, expirySourceHeader, 0)
+
+	resp := callResource(t, d, &backend.CallResourceRequest{PluginContext: pc, Path: "token/info", Method: http.MethodGet})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.Status)
+	}
+	if strings.Contains(string(resp.Body), "secret-token") {
+		t.Fatalf("token value leaked: %s", resp.Body)
+	}
+	var got struct {
+		Manual           bool   `json:"manual"`
+		Cached           bool   `json:"cached"`
+		ExpiresAt        int64  `json:"expiresAt"`
+		Source           string `json:"source"`
+		SecondsRemaining int64  `json:"secondsRemaining"`
+	}
+	if err := json.Unmarshal(resp.Body, &got); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	if got.Manual || !got.Cached || got.ExpiresAt != expAt || got.Source != "header" {
+		t.Fatalf("unexpected info: %+v", got)
+	}
+	if got.SecondsRemaining <= 0 || got.SecondsRemaining > 600 {
+		t.Fatalf("secondsRemaining = %d, want (0,600]", got.SecondsRemaining)
+	}
+}
+
+func TestQueryData_ResolveDeviceIP(t *testing.T) {
+	var deviceCalls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dna/data/api/v1/assuranceIssues":
+			_, _ = w.Write([]byte(`{"response":[{"issueId":"i1","deviceId":"d1"},{"issueId":"i2","deviceId":"d2"},{"issueId":"i3","deviceId":"d1"}]}`))
+		case "/dna/intent/api/v1/network-device":
+			atomic.AddInt32(&deviceCalls, 1)
+			if got := r.URL.Query().Get("id"); got != "d1,d2" {
+				t.Errorf("device ids = %q, want d1,d2", got)
+			}
+			_, _ = w.Write([]byte(`{"response":[{"id":"d1","managementIpAddress":"10.0.0.1"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","resolveDeviceIP":true}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if got := atomic.LoadInt32(&deviceCalls); got != 1 {
+		t.Fatalf("device lookups = %d, want 1", got)
+	}
+	frame := resp.Responses["A"].Frames[0]
+	f, idx := frame.FieldByName("Device IP")
+	if idx < 0 {
+		t.Fatal("missing Device IP field")
+	}
+	want := []string{"10.0.0.1", "", "10.0.0.1"}
+	for i, w := range want {
+		if got := f.At(i).(string); got != w {
+			t.Errorf("row %d Device IP = %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestQueryData_ConcurrentResultsMapToRefIDs(t *testing.T) {
+	var inFlight, maxInFlight int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		// Echo the site filter back as the issue ID.
+		site := r.URL.Query().Get("siteId")
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"` + site + `"}]}`))
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","queryConcurrency":2}`)
+
+	refs := []string{"A", "B", "C", "D", "E", "F"}
+	queries := make([]backend.DataQuery, 0, len(refs))
+	for _, ref := range refs {
+		queries = append(queries, testQuery(ref, `{"queryType":"alerts","siteId":"site-`+ref+`"}`))
+	}
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{PluginContext: pc, Queries: queries})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	for _, ref := range refs {
+		frame := resp.Responses[ref].Frames[0]
+		if got := frame.Fields[1].At(0).(string); got != "site-"+ref {
+			t.Errorf("%s issue = %q, want site-%s", ref, got, ref)
+		}
+	}
+	if got := atomic.LoadInt32(&maxInFlight); got > 2 {
+		t.Fatalf("max in-flight requests = %d, want <= 2", got)
+	}
+}
+
+func TestClientFor_ReusesAndRebuilds(t *testing.T) {
+	d := NewDatasource()
+	inst := &dsInstance{UID: "uid", Settings: &InstanceSettings{BaseURL: "https://dnac.local", HTTPTimeoutSeconds: 30}}
+
+	c1 := d.clientFor(inst)
+	same := &dsInstance{UID: "uid", Settings: &InstanceSettings{BaseURL: "https://dnac.local", HTTPTimeoutSeconds: 30}}
+	if c2 := d.clientFor(same); c2 != c1 {
+		t.Fatal("identical settings should reuse the cached client")
+	}
+
+	changed := &dsInstance{UID: "uid", Settings: &InstanceSettings{BaseURL: "https://dnac.local", HTTPTimeoutSeconds: 30, InsecureSkipVerify: true}}
+	if c3 := d.clientFor(changed); c3 == c1 {
+		t.Fatal("changing InsecureSkipVerify should rebuild the client")
+	}
+}
+
+func TestQueryData_IssueCount(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"response":[
+			{"issueId":"i1","priority":"P1"},
+			{"issueId":"i2","priority":"P1"},
+			{"issueId":"i3","priority":"p3"},
+			{"issueId":"i4","severity":"P4"},
+			{"issueId":"i5"}]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries: []backend.DataQuery{
+			testQuery("A", `{"queryType":"issueCount"}`),
+			testQuery("B", `{"queryType":"issueCount","limit":5}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	frame := resp.Responses["A"].Frames[0]
+	want := map[string]int64{"P1": 2, "P2": 0, "P3": 1, "P4": 1, "Total": 5}
+	for name, w := range want {
+		f, idx := frame.FieldByName(name)
+		if idx < 0 {
+			t.Fatalf("missing field %q", name)
+		}
+		if got := f.At(0).(int64); got != w {
+			t.Errorf("%s = %d, want %d", name, got, w)
+		}
+	}
+	if frame.Meta != nil {
+		t.Fatalf("unexpected notices: %+v", frame.Meta.Notices)
+	}
+
+	// Scanning exactly up to the limit warns that counts may be incomplete.
+	if meta := resp.Responses["B"].Frames[0].Meta; meta == nil || len(meta.Notices) != 1 {
+		t.Fatalf("expected a limit notice, got %+v", meta)
+	}
+}
+
+func TestResourceIssues_ForwardsAllowedHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-1")
+		w.Header().Set("X-RateLimit-Remaining", "9")
+		w.Header().Set("X-Internal", "secret")
+		w.Header().Set("Set-Cookie", "session=abc")
+		_, _ = w.Write([]byte(`{"response":[]}`))
+	}))
+	defer srv.Close()
+
+	d := NewDatasource()
+	pc := testPluginContext(srv.URL)
+	resp := callResource(t, d, &backend.CallResourceRequest{PluginContext: pc, Path: "issues", Method: http.MethodGet})
+	if got := resp.Headers["X-Request-Id"]; len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("X-Request-Id = %v, want [req-1]", got)
+	}
+	if got := resp.Headers["X-Ratelimit-Remaining"]; len(got) != 1 || got[0] != "9" {
+		t.Errorf("X-RateLimit-Remaining = %v, want [9]", got)
+	}
+	for _, h := range []string{"X-Internal", "Set-Cookie"} {
+		if _, ok := resp.Headers[h]; ok {
+			t.Errorf("%s should not be forwarded by default", h)
+		}
+	}
+
+	// A custom allow-list replaces the default, and Set-Cookie stays blocked.
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","forwardHeaders":["x-internal","Set-Cookie"]}`)
+	resp = callResource(t, d, &backend.CallResourceRequest{PluginContext: pc, Path: "issues", Method: http.MethodGet})
+	if got := resp.Headers["X-Internal"]; len(got) != 1 || got[0] != "secret" {
+		t.Errorf("X-Internal = %v, want [secret]", got)
+	}
+	if _, ok := resp.Headers["Set-Cookie"]; ok {
+		t.Error("Set-Cookie must never be forwarded")
+	}
+	if _, ok := resp.Headers["X-Request-Id"]; ok {
+		t.Error("X-Request-Id is not in the custom allow-list")
+	}
+}
+
+func TestCheckHealth_WarnsOnInvalidIssueLinkTemplate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"response":[]}`))
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","issueLinkTemplate":"{{.BaseURL"}`)
+	res, err := NewDatasource().CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: pc})
+	if err != nil {
+		t.Fatalf("CheckHealth error: %v", err)
+	}
+	if res.Status != backend.HealthStatusOk || !strings.Contains(res.Message, "issue link template") {
+		t.Fatalf("CheckHealth = (%v, %q), want OK with a template warning", res.Status, res.Message)
+	}
+}
+
+func TestQueryData_EnrichDeviceNames(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dna/data/api/v1/assuranceIssues":
+			_, _ = w.Write([]byte(`{"response":[{"issueId":"i1","deviceId":"d1"},{"issueId":"i2","deviceId":"d2"}]}`))
+		case "/dna/intent/api/v1/network-device":
+			if got := r.URL.Query().Get("id"); got != "d1,d2" {
+				t.Errorf("device ids = %q, want d1,d2", got)
+			}
+			_, _ = w.Write([]byte(`{"response":[{"id":"d1","hostname":"edge-sw1"}]}`))
+		case "/dna/intent/api/v1/site":
+			_, _ = w.Write([]byte(`{"response":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","enrich":true}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	f, idx := resp.Responses["A"].Frames[0].FieldByName("Device Name")
+	if idx < 0 {
+		t.Fatal("missing Device Name field")
+	}
+	// d2 is unknown upstream and falls back to its ID.
+	for i, want := range []string{"edge-sw1", "d2"} {
+		if got := f.At(i).(string); got != want {
+			t.Errorf("row %d Device Name = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestQueryData_ConcurrentPagesMergeInOrder(t *testing.T) {
+	var inFlight, maxInFlight, calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		// Later pages answer faster, so completion order differs from page order.
+		time.Sleep(time.Duration(200-offset) * time.Millisecond)
+		items := make([]string, 0, limit)
+		for i := 0; i < limit; i++ {
+			items = append(items, `{"issueId":"`+strconv.Itoa(offset+i)+`"}`)
+		}
+		_, _ = w.Write([]byte(`{"response":[` + strings.Join(items, ",") + `]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPagedPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","limit":140}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 6 {
+		t.Fatalf("page requests = %d, want 6", got)
+	}
+	if got := atomic.LoadInt32(&maxInFlight); got < 2 || got > pageConcurrency {
+		t.Fatalf("max in-flight pages = %d, want 2..%d", got, pageConcurrency)
+	}
+	frame := resp.Responses["A"].Frames[0]
+	if n, _ := frame.RowLen(); n != 140 {
+		t.Fatalf("rows = %d, want 140", n)
+	}
+	for i := 0; i < 140; i++ {
+		// Offsets are one-based, so row i carries ID i+1.
+		if got := frame.Fields[1].At(i).(string); got != strconv.Itoa(i+1) {
+			t.Fatalf("row %d issue = %q, want %d", i, got, i+1)
+		}
+	}
+}
+
+func TestQueryData_ConcurrentPagesStopAfterShortPage(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if offset > 1 {
+			// Only the first page has data.
+			_, _ = w.Write([]byte(`{"response":[]}`))
+			return
+		}
+		items := make([]string, 25)
+		for i := range items {
+			items[i] = `{"issueId":"i` + strconv.Itoa(i) + `"}`
+		}
+		_, _ = w.Write([]byte(`{"response":[` + strings.Join(items, ",") + `]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPagedPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","limit":1000}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if n, _ := resp.Responses["A"].Frames[0].RowLen(); n != 25 {
+		t.Fatalf("rows = %d, want 25", n)
+	}
+	// The first page plus at most one wave of the pool.
+	if got := atomic.LoadInt32(&calls); got > 1+pageConcurrency {
+		t.Fatalf("page requests = %d, want <= %d", got, 1+pageConcurrency)
+	}
+}
+
+func TestQueryData_DedupesAcrossPages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") == "1" {
+			// A full first page whose last issue reappears on the second page,
+			// as happens when an issue arrives mid-scan.
+			items := make([]string, 25)
+			for i := range items {
+				items[i] = `{"issueId":"i` + strconv.Itoa(i) + `"}`
+			}
+			_, _ = w.Write([]byte(`{"response":[` + strings.Join(items, ",") + `]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i24"},{"issueId":"i25"},{"name":"no id"},{"name":"no id"}]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPagedPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","limit":50}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	frame := resp.Responses["A"].Frames[0]
+	// 25 + i25 + the two ID-less issues; the repeated i24 is dropped.
+	if n, _ := frame.RowLen(); n != 28 {
+		t.Fatalf("rows = %d, want 28", n)
+	}
+	if got := frame.Fields[1].At(25).(string); got != "i25" {
+		t.Fatalf("row 25 = %q, want i25", got)
+	}
+}
+
+func TestQueryData_RejectedFilterNotice(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1","priority":"P1"}]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","priority":["P1","P7"],"issueStatus":"open"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	meta := resp.Responses["A"].Frames[0].Meta
+	if meta == nil || len(meta.Notices) != 1 {
+		t.Fatalf("meta = %+v, want one notice", meta)
+	}
+	if got := meta.Notices[0].Text; !strings.Contains(got, `priority "P7"`) || !strings.Contains(got, `issueStatus "open"`) {
+		t.Fatalf("notice = %q, want both rejected values", got)
+	}
+}
+
+func TestQueryData_EnrichSiteHierarchy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dna/data/api/v1/assuranceIssues":
+			_, _ = w.Write([]byte(`{"response":[{"issueId":"i1","siteId":"s1"},{"issueId":"i2","siteId":"s2"}]}`))
+		case "/dna/intent/api/v1/site":
+			_, _ = w.Write([]byte(`{"response":[
+				{"id":"s1","siteName":"Floor 1","siteNameHierarchy":"Global/US/NYC/Floor 1"},
+				{"id":"s2","siteName":"Floor 1"}
+			]}`))
+		default:
+			_, _ = w.Write([]byte(`{"response":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","enrich":true}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	frame := resp.Responses["A"].Frames[0]
+	site, idx := frame.FieldByName("Site Name")
+	if idx < 0 {
+		t.Fatal("missing Site Name field")
+	}
+	short, idx := frame.FieldByName("Site Short Name")
+	if idx < 0 {
+		t.Fatal("missing Site Short Name field")
+	}
+	// s2 has no hierarchy and falls back to its plain name.
+	for i, want := range []string{"Global/US/NYC/Floor 1", "Floor 1"} {
+		if got := site.At(i).(string); got != want {
+			t.Errorf("row %d Site Name = %q, want %q", i, got, want)
+		}
+		if got := short.At(i).(string); got != "Floor 1" {
+			t.Errorf("row %d Site Short Name = %q, want Floor 1", i, got)
+		}
+	}
+}
+
+func TestQueryData_EnrichSiteCoverageNotice(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dna/data/api/v1/assuranceIssues":
+			_, _ = w.Write([]byte(`{"response":[{"issueId":"i1","siteId":"s1"},{"issueId":"i2","siteId":"s2"},{"issueId":"i3","siteId":"s3"},{"issueId":"i4","siteId":"s1"}]}`))
+		case "/dna/intent/api/v1/site":
+			_, _ = w.Write([]byte(`{"response":[{"id":"s1","siteName":"HQ"},{"id":"s2","siteName":"Branch"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"response":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		query  string
+		notice bool
+	}{
+		{`{"queryType":"alerts","enrich":true}`, true},
+		{`{"queryType":"alerts"}`, false},
+	} {
+		resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: testPluginContext(srv.URL),
+			Queries:       []backend.DataQuery{testQuery("A", tt.query)},
+		})
+		if err != nil {
+			t.Fatalf("QueryData error: %v", err)
+		}
+		var found *data.Notice
+		if meta := resp.Responses["A"].Frames[0].Meta; meta != nil {
+			for i, n := range meta.Notices {
+				if strings.HasPrefix(n.Text, "Resolved ") {
+					found = &meta.Notices[i]
+				}
+			}
+		}
+		if !tt.notice {
+			if found != nil {
+				t.Fatalf("%s: unexpected coverage notice %q", tt.query, found.Text)
+			}
+			continue
+		}
+		if found == nil || found.Text != "Resolved 2/3 site names." || found.Severity != data.NoticeSeverityWarning {
+			t.Fatalf("%s: coverage notice = %+v, want a warning \"Resolved 2/3 site names.\"", tt.query, found)
+		}
+	}
+}
+
+func TestCheckHealth_SiteForbiddenIsDegraded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dna/intent/api/v1/site" {
+			http.Error(w, "insufficient role", http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[]}`))
+	}))
+	defer srv.Close()
+
+	res, err := NewDatasource().CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: testPluginContext(srv.URL)})
+	if err != nil {
+		t.Fatalf("CheckHealth error: %v", err)
+	}
+	if res.Status != backend.HealthStatusOk {
+		t.Fatalf("status = %v, want OK; message %q", res.Status, res.Message)
+	}
+	for _, want := range []string{"- token: OK", "- issues: OK", "- site: 403 Forbidden: insufficient role"} {
+		if !strings.Contains(res.Message, want) {
+			t.Errorf("message %q lacks %q", res.Message, want)
+		}
+	}
+}
+
+func TestCheckHealth_JSONDetails(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		status     int
+		wantHealth backend.HealthStatus
+	}{
+		{"success", http.StatusOK, backend.HealthStatusOk},
+		{"failure", http.StatusNotFound, backend.HealthStatusError},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			_, _ = w.Write([]byte(`{"response":[]}`))
+		}))
+
+		res, err := NewDatasource().CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: testPluginContext(srv.URL)})
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: CheckHealth error: %v", tt.name, err)
+		}
+		if res.Status != tt.wantHealth {
+			t.Fatalf("%s: status = %v, want %v", tt.name, res.Status, tt.wantHealth)
+		}
+		var details map[string]any
+		if err := json.Unmarshal(res.JSONDetails, &details); err != nil {
+			t.Fatalf("%s: JSONDetails %q: %v", tt.name, res.JSONDetails, err)
+		}
+		if details["tokenSource"] != "manual" {
+			t.Errorf("%s: tokenSource = %v, want manual", tt.name, details["tokenSource"])
+		}
+		if details["issuesUrl"] != srv.URL+"/dna/data/api/v1/assuranceIssues?limit=1" {
+			t.Errorf("%s: issuesUrl = %v", tt.name, details["issuesUrl"])
+		}
+		if details["httpStatus"] != float64(tt.status) {
+			t.Errorf("%s: httpStatus = %v, want %d", tt.name, details["httpStatus"], tt.status)
+		}
+		if _, ok := details["latencyMs"]; !ok {
+			t.Errorf("%s: latencyMs missing", tt.name)
+		}
+	}
+}
+
+func TestResourceTokenStatus_States(t *testing.T) {
+	pc := func(secure map[string]string) backend.PluginContext {
+		return backend.PluginContext{
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				UID:                     "uid-1",
+				JSONData:                []byte(`{"baseUrl":"https://dnac.local"}`),
+				DecryptedSecureJSONData: secure,
+			},
+		}
+	}
+	creds := map[string]string{"username": "u", "password": "p"}
+
+	cached := NewDatasource()
+	cached.tm.setWithExpiry("uid-1", "secret-token", time.Now().Add(time.Hour).Unix(), expirySourceJSON, 0)
+
+	for _, tt := range []struct {
+		name   string
+		d      *Datasource
+		secure map[string]string
+		want   string
+	}{
+		{"absent", NewDatasource(), creds, `{"manual":false,"cached":false,`},
+		{"manual", cached, map[string]string{"apiToken": "manual-token"}, `{"manual":true,"cached":false,`},
+		{"cached", cached, creds, `"manual":false,"cached":true`},
+	} {
+		resp := callResource(t, tt.d, &backend.CallResourceRequest{PluginContext: pc(tt.secure), Path: "token-status"})
+		if resp.Status != http.StatusOK || !strings.Contains(string(resp.Body), tt.want) {
+			t.Fatalf("%s: token-status = (%d, %s), want %s", tt.name, resp.Status, resp.Body, tt.want)
+		}
+		if strings.Contains(string(resp.Body), "secret-token") || strings.Contains(string(resp.Body), "manual-token") {
+			t.Fatalf("%s: token value leaked: %s", tt.name, resp.Body)
+		}
+	}
+}
+
+func TestResourceRefreshToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"Token":"new-token","expiresIn":3600}`))
+	}))
+	defer srv.Close()
+
+	d := NewDatasource()
+	pc := backend.PluginContext{
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			UID:                     "uid-1",
+			JSONData:                []byte(`{"baseUrl":"` + srv.URL + `"}`),
+			DecryptedSecureJSONData: map[string]string{"username": "u", "password": "p"},
+		},
+	}
+	d.tm.setWithExpiry("uid-1", "old-token", time.Now().Add(time.Hour).Unix(), expirySourceJSON, 0)
+
+	if resp := callResource(t, d, &backend.CallResourceRequest{PluginContext: pc, Path: "refresh-token", Method: http.MethodGet}); resp.Status != http.StatusMethodNotAllowed {
+		t.Fatalf("GET status = %d, want 405", resp.Status)
+	}
+
+	resp := callResource(t, d, &backend.CallResourceRequest{PluginContext: pc, Path: "refresh-token", Method: http.MethodPost})
+	if resp.Status != http.StatusOK || !strings.Contains(string(resp.Body), `"refreshed":true`) {
+		t.Fatalf("refresh = (%d, %s), want refreshed", resp.Status, resp.Body)
+	}
+	if strings.Contains(string(resp.Body), "new-token") {
+		t.Fatalf("token value leaked: %s", resp.Body)
+	}
+	if e := d.tm.cache["uid-1"]; e.Token != "new-token" {
+		t.Fatalf("cached token = %q, want new-token", e.Token)
+	}
+
+	// A manual token is left alone.
+	pc.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{"apiToken": "manual"}
+	resp = callResource(t, d, &backend.CallResourceRequest{PluginContext: pc, Path: "refresh-token", Method: http.MethodPost})
+	if resp.Status != http.StatusOK || !strings.Contains(string(resp.Body), `"refreshed":false`) || !strings.Contains(string(resp.Body), "manual API token") {
+		t.Fatalf("manual refresh = (%d, %s), want a no-op message", resp.Status, resp.Body)
+	}
+}
+
+func TestResourceHealth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"response":[]}`))
+	}))
+	defer srv.Close()
+
+	resp := callResource(t, NewDatasource(), &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "health", Method: http.MethodGet})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body %s", resp.Status, resp.Body)
+	}
+	var got struct {
+		Status  string         `json:"status"`
+		Message string         `json:"message"`
+		Details map[string]any `json:"details"`
+	}
+	if err := json.Unmarshal(resp.Body, &got); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	if got.Status != "OK" || !strings.Contains(got.Message, "- issues: OK") || got.Details["tokenSource"] != "manual" {
+		t.Fatalf("unexpected health: %+v", got)
+	}
+
+	// An unreachable instance reports an error status.
+	srv.Close()
+	resp = callResource(t, NewDatasource(), &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "health"})
+	if resp.Status != http.StatusServiceUnavailable || !strings.Contains(string(resp.Body), `"status":"ERROR"`) {
+		t.Fatalf("unreachable = (%d, %s), want 503 with ERROR", resp.Status, resp.Body)
+	}
+
+	if resp := callResource(t, NewDatasource(), &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "nope"}); resp.Status != http.StatusNotFound {
+		t.Fatalf("unknown path status = %d, want 404", resp.Status)
+	}
+}
+
+func TestResourceSites_ForwardsFilters(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dna/intent/api/v1/site" {
+			t.Errorf("path = %q, want the site API", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("type") != "building" || q.Get("name") != "Global/US" || q.Has("offset") {
+			t.Errorf("query = %v, want only type and name", q)
+		}
+		if r.Header.Get("X-Auth-Token") != "tok" {
+			t.Errorf("missing token header")
+		}
+		_, _ = w.Write([]byte(`{"response":[{"id":"s1","siteName":"HQ"}]}`))
+	}))
+	defer srv.Close()
+
+	resp := callResource(t, NewDatasource(), &backend.CallResourceRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Path:          "sites",
+		URL:           "sites?type=building&name=Global%2FUS&offset=5",
+	})
+	if resp.Status != http.StatusOK || !strings.Contains(string(resp.Body), `"siteName":"HQ"`) {
+		t.Fatalf("sites = (%d, %s), want the upstream JSON", resp.Status, resp.Body)
+	}
+
+	if resp := callResource(t, NewDatasource(), &backend.CallResourceRequest{PluginContext: testPluginContext(""), Path: "sites"}); resp.Status != http.StatusBadRequest {
+		t.Fatalf("missing baseUrl status = %d, want 400", resp.Status)
+	}
+}
+
+func TestResourceDevices_ForwardsParams(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dna/intent/api/v1/network-device" {
+			t.Errorf("path = %q, want the network-device API", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("offset") != "1" || q.Get("limit") != "500" || q.Get("family") != "Switches and Hubs" {
+			t.Errorf("query = %v, want offset, limit and family forwarded", q)
+		}
+		w.Header().Set("X-Request-Id", "req-9")
+		_, _ = w.Write([]byte(`{"response":[{"id":"d1","hostname":"edge-sw1"}]}`))
+	}))
+	defer srv.Close()
+
+	resp := callResource(t, NewDatasource(), &backend.CallResourceRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Path:          "devices",
+		URL:           "devices?offset=1&limit=500&family=Switches+and+Hubs",
+	})
+	if resp.Status != http.StatusOK || !strings.Contains(string(resp.Body), `"hostname":"edge-sw1"`) {
+		t.Fatalf("devices = (%d, %s), want the upstream JSON", resp.Status, resp.Body)
+	}
+	if got := resp.Headers["Content-Type"]; len(got) != 1 || got[0] != "application/json" {
+		t.Fatalf("Content-Type = %v", got)
+	}
+	if got := resp.Headers["X-Request-Id"]; len(got) != 1 || got[0] != "req-9" {
+		t.Fatalf("X-Request-Id = %v, want forwarded", got)
+	}
+}
+
+func TestQueryData_TotalCountStopsPaging(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		header bool
+	}{{"header", true}, {"envelope", false}} {
+		var calls int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			items := make([]string, 25)
+			for i := range items {
+				items[i] = `{"issueId":"i` + strconv.Itoa(offset+i) + `"}`
+			}
+			// 50 matching issues: two full pages, so only the total tells
+			// that a third page would be empty.
+			if tt.header {
+				w.Header().Set("X-Total-Count", "50")
+				_, _ = w.Write([]byte(`{"response":[` + strings.Join(items, ",") + `]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"totalCount":50,"response":[` + strings.Join(items, ",") + `]}`))
+		}))
+
+		resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: testPagedPluginContext(srv.URL),
+			Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","limit":200}`)},
+		})
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: QueryData error: %v", tt.name, err)
+		}
+		if n, _ := resp.Responses["A"].Frames[0].RowLen(); n != 50 {
+			t.Fatalf("%s: rows = %d, want 50", tt.name, n)
+		}
+		if got := atomic.LoadInt32(&calls); got != 2 {
+			t.Fatalf("%s: page requests = %d, want 2", tt.name, got)
+		}
+	}
+}
+
+// recordingLogger is a log.Logger that records each message with its fields,
+// including contextual attributes picked up through FromContext.
+type recordingLogger struct {
+	mu     *sync.Mutex
+	lines  *[]map[string]any
+	fields []any
+}
+
+func newRecordingLogger() recordingLogger {
+	return recordingLogger{mu: &sync.Mutex{}, lines: &[]map[string]any{}}
+}
+
+func (l recordingLogger) record(msg string, args []any) {
+	line := map[string]any{"msg": msg}
+	all := append(append([]any{}, l.fields...), args...)
+	for i := 0; i+1 < len(all); i += 2 {
+		line[fmt.Sprint(all[i])] = all[i+1]
+	}
+	l.mu.Lock()
+	*l.lines = append(*l.lines, line)
+	l.mu.Unlock()
+}
+
+func (l recordingLogger) Debug(msg string, args ...any) { l.record(msg, args) }
+func (l recordingLogger) Info(msg string, args ...any)  { l.record(msg, args) }
+func (l recordingLogger) Warn(msg string, args ...any)  { l.record(msg, args) }
+func (l recordingLogger) Error(msg string, args ...any) { l.record(msg, args) }
+func (l recordingLogger) Level() log.Level              { return log.Debug }
+func (l recordingLogger) With(args ...any) log.Logger {
+	l.fields = append(append([]any{}, l.fields...), args...)
+	return l
+}
+func (l recordingLogger) FromContext(ctx context.Context) log.Logger {
+	return l.With(log.ContextualAttributesFromContext(ctx)...)
+}
+
+func TestQueryData_LogsCarryCorrelationID(t *testing.T) {
+	rec := newRecordingLogger()
+	prev := log.DefaultLogger
+	log.DefaultLogger = rec
+	defer func() { log.DefaultLogger = prev }()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dna/system/api/v1/auth/token":
+			_, _ = w.Write([]byte(`{"Token":"t","expiresIn":3600}`))
+		case "/dna/intent/api/v1/site":
+			_, _ = w.Write([]byte(`{"response":[{"id":"s1","siteName":"HQ"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"response":[{"issueId":"i1","siteId":"s1"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{"username": "u", "password": "p"}
+	if _, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pc,
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","enrich":true}`)},
+	}); err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+
+	seen := map[string]map[string]any{}
+	for _, line := range *rec.lines {
+		seen[line["msg"].(string)] = line
+	}
+	for _, msg := range []string{"token request done", "issues page fetched", "sites resolved", "query done"} {
+		line, ok := seen[msg]
+		if !ok {
+			t.Errorf("no %q log line", msg)
+			continue
+		}
+		if line["correlationId"] != "test-uid/1/A" || line["refId"] != "A" {
+			t.Errorf("%q fields = %v, want correlationId test-uid/1/A and refId A", msg, line)
+		}
+		if _, ok := line["elapsedMs"]; !ok {
+			t.Errorf("%q lacks elapsedMs", msg)
+		}
+	}
+	if line := seen["issues page fetched"]; line != nil && (line["status"] != 200 || line["rows"] != 1) {
+		t.Errorf("issues page fields = %v, want status 200 and 1 row", line)
+	}
+}
+
+func TestQueryData_InstanceLimitDefaults(t *testing.T) {
+	var mu sync.Mutex
+	var limits []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		mu.Lock()
+		limits = append(limits, r.URL.Query().Get("offset")+":"+strconv.Itoa(limit))
+		mu.Unlock()
+		items := make([]string, limit)
+		for i := range items {
+			items[i] = `{"issueId":"` + r.URL.Query().Get("offset") + `-` + strconv.Itoa(i) + `"}`
+		}
+		_, _ = w.Write([]byte(`{"response":[` + strings.Join(items, ",") + `]}`))
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","defaultPageSize":20,"defaultLimit":30}`)
+	for _, tt := range []struct {
+		query      string
+		wantRows   int
+		wantLimits []string
+	}{
+		{`{"queryType":"alerts"}`, 30, []string{"1:20", "21:10"}},
+		{`{"queryType":"alerts","limit":5}`, 5, []string{"1:5"}},
+	} {
+		limits = nil
+		resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: pc,
+			Queries:       []backend.DataQuery{testQuery("A", tt.query)},
+		})
+		if err != nil {
+			t.Fatalf("%s: QueryData error: %v", tt.query, err)
+		}
+		if n, _ := resp.Responses["A"].Frames[0].RowLen(); n != tt.wantRows {
+			t.Errorf("%s: rows = %d, want %d", tt.query, n, tt.wantRows)
+		}
+		if strings.Join(limits, ",") != strings.Join(tt.wantLimits, ",") {
+			t.Errorf("%s: page requests = %v, want %v", tt.query, limits, tt.wantLimits)
+		}
+	}
+}
+
+func TestQueryData_DecodesErrorEnvelope(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"response":{"errorCode":"NCND00009","message":"Invalid input","detail":"siteId 'abc' is not a valid UUID","href":"/dna/data/api/v1/assuranceIssues"},"version":"1.0"}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","siteId":"abc"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	dr := resp.Responses["A"]
+	want := "Invalid input: siteId 'abc' is not a valid UUID (NCND00009)"
+	if dr.Error == nil || dr.Error.Error() != "issues endpoint returned 400 Bad Request: "+want {
+		t.Fatalf("error = %v, want the decoded message", dr.Error)
+	}
+	found := false
+	if meta := dr.Frames[0].Meta; meta != nil {
+		for _, n := range meta.Notices {
+			found = found || (n.Severity == data.NoticeSeverityError && n.Text == "Catalyst Center: "+want)
+		}
+	}
+	if !found {
+		t.Fatalf("meta = %+v, want an error notice with the decoded message", dr.Frames[0].Meta)
+	}
+
+	// Bodies that aren't an error envelope are passed through.
+	if e := newAPIError("issues", &http.Response{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"}, []byte("<html>proxy error</html>")); e.Message != "<html>proxy error</html>" {
+		t.Fatalf("raw fallback = %q", e.Message)
+	}
+}
+
+func TestQueryData_UseQueryBody(t *testing.T) {
+	now := time.Now()
+	from, to := now.Add(-time.Hour), now
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/dna/data/api/v1/assuranceIssues/query" {
+			t.Errorf("request = %s %s, want POST to the query endpoint", r.Method, r.URL.Path)
+		}
+		if got := r.URL.Query(); got.Get("limit") != "10" || got.Get("offset") != "1" || got.Get("sortBy") != "priority" || got.Has("priority") {
+			t.Errorf("URL query = %v, want only paging and sorting", got)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		var body IssuesQueryBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("body: %v", err)
+		}
+		want := IssuesQueryBody{
+			StartTime: from.UnixMilli(),
+			EndTime:   to.UnixMilli(),
+			Filters: []IssueFilter{
+				{Key: "priority", Operator: "in", Value: []any{"P1", "P2"}},
+				{Key: "siteId", Operator: "eq", Value: "s1"},
+				{Key: "status", Operator: "eq", Value: "active"},
+			},
+		}
+		if !reflect.DeepEqual(body, want) {
+			t.Errorf("body = %+v, want %+v", body, want)
+		}
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1","priority":"P1"},{"issueId":"i2","priority":"P2"}]}`))
+	}))
+	defer srv.Close()
+
+	q := testQuery("A", `{"queryType":"alerts","useQueryBody":true,"limit":10,"siteId":"s1","priority":["P1","P2"],"issueStatus":"ACTIVE","sortBy":"priority"}`)
+	q.TimeRange = backend.TimeRange{From: from, To: to}
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{q},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if dr := resp.Responses["A"]; dr.Error != nil {
+		t.Fatalf("query error: %v", dr.Error)
+	}
+	if n, _ := resp.Responses["A"].Frames[0].RowLen(); n != 2 {
+		t.Fatalf("rows = %d, want 2", n)
+	}
+}
+
+func TestQueryData_CancelStopsPaging(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		items := make([]string, 25)
+		for i := range items {
+			items[i] = `{"issueId":"` + r.URL.Query().Get("offset") + `-` + strconv.Itoa(i) + `"}`
+		}
+		_, _ = w.Write([]byte(`{"response":[` + strings.Join(items, ",") + `]}`))
+		// The user navigates away once the first page is served.
+		cancel()
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(ctx, &backend.QueryDataRequest{
+		PluginContext: testPagedPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","limit":200}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if dr := resp.Responses["A"]; !errors.Is(dr.Error, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", dr.Error)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("page requests = %d, want 1", got)
+	}
+}
+
+func TestResourceSiteID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dna/intent/api/v1/site" {
+			t.Errorf("path = %q, want the site API", r.URL.Path)
+		}
+		switch r.URL.Query().Get("groupNameHierarchy") {
+		case "Global/USA/NYC":
+			_, _ = w.Write([]byte(`{"response":[{"id":"s-nyc","siteName":"NYC","siteNameHierarchy":"Global/USA/NYC"}]}`))
+		case "Global/Nowhere":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"response":{"errorCode":"NCGR10008","message":"Site not found"}}`))
+		default:
+			_, _ = w.Write([]byte(`{"response":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	var got struct {
+		Hierarchy string   `json:"hierarchy"`
+		SiteIDs   []string `json:"siteIds"`
+	}
+	resp := callResource(t, NewDatasource(), &backend.CallResourceRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Path:          "siteId",
+		URL:           "siteId?hierarchy=Global%2FUSA%2FNYC",
+	})
+	if err := json.Unmarshal(resp.Body, &got); resp.Status != http.StatusOK || err != nil {
+		t.Fatalf("siteId = (%d, %s), want 200 JSON", resp.Status, resp.Body)
+	}
+	if !reflect.DeepEqual(got.SiteIDs, []string{"s-nyc"}) {
+		t.Fatalf("siteIds = %v, want [s-nyc]", got.SiteIDs)
+	}
+
+	for _, h := range []string{"Global%2FNowhere", "Global%2FEmpty"} {
+		resp = callResource(t, NewDatasource(), &backend.CallResourceRequest{
+			PluginContext: testPluginContext(srv.URL),
+			Path:          "siteId",
+			URL:           "siteId?hierarchy=" + h,
+		})
+		if resp.Status != http.StatusOK || !strings.Contains(string(resp.Body), `"siteIds":[]`) {
+			t.Fatalf("%s: siteId = (%d, %s), want 200 with no IDs", h, resp.Status, resp.Body)
+		}
+	}
+
+	resp = callResource(t, NewDatasource(), &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "siteId"})
+	if resp.Status != http.StatusBadRequest {
+		t.Fatalf("missing hierarchy status = %d, want 400", resp.Status)
+	}
+}
+
+func TestQueryData_QueryTypeRegistry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"}]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries: []backend.DataQuery{
+			testQuery("A", `{"queryType":"alertz"}`),
+			testQuery("B", `{}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	a := resp.Responses["A"]
+	if a.Error == nil || !strings.Contains(a.Error.Error(), `unknown queryType "alertz"`) || len(a.Frames) != 0 {
+		t.Fatalf("unknown type = (%v, %d frames), want an explicit error", a.Error, len(a.Frames))
+	}
+	// A missing query type runs an issues query, as before query types existed.
+	b := resp.Responses["B"]
+	if b.Error != nil || len(b.Frames) != 1 {
+		t.Fatalf("empty type = (%v, %d frames), want an issues frame", b.Error, len(b.Frames))
+	}
+	if n, _ := b.Frames[0].RowLen(); n != 1 {
+		t.Fatalf("empty type rows = %d, want 1", n)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("upstream calls = %d, want 1 (none for the unknown type)", got)
+	}
+}
+
+func TestQueryData_FrameName(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"}]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries: []backend.DataQuery{
+			testQuery("A", `{"queryType":"alerts","frameName":" Core P1s "}`),
+			testQuery("B", `{"queryType":"alerts"}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if f := resp.Responses["A"].Frames[0]; f.Name != "Core P1s" || f.RefID != "A" {
+		t.Fatalf("frame = (%q, refId %q), want (Core P1s, A)", f.Name, f.RefID)
+	}
+	if f := resp.Responses["B"].Frames[0]; f.Name != "B" {
+		t.Fatalf("default frame name = %q, want B", f.Name)
+	}
+}
+
+func TestQueryData_AuthHeaderFormat(t *testing.T) {
+	var got, legacy atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Get("Authorization"))
+		legacy.Store(r.Header.Get("X-Auth-Token"))
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"}]}`))
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","authHeaderName":"Authorization","authHeaderFormat":"Bearer %s"}`)
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pc,
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if r := resp.Responses["A"]; r.Error != nil {
+		t.Fatalf("query error: %v", r.Error)
+	}
+	if v, _ := got.Load().(string); v != "Bearer tok" {
+		t.Fatalf("Authorization = %q, want %q", v, "Bearer tok")
+	}
+	if v, _ := legacy.Load().(string); v != "" {
+		t.Fatalf("X-Auth-Token = %q, want it unset", v)
+	}
+}
+
+func TestQueryData_ObjectResponseEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		rows    int
+		wantErr string
+	}{
+		{"empty", `{"response":[]}`, 0, ""},
+		{"error object", `{"response":{"errorCode":"NCND01001","message":"Request failed"}}`, 0, "issues endpoint returned 200 OK: Request failed (NCND01001)"},
+		{"single issue", `{"response":{"issueId":"i1"}}`, 1, ""},
+		{"other object", `{"response":{"status":"pending"}}`, 0, "issues endpoint returned an object instead of a list of issues"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: testPluginContext(srv.URL),
+				Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts"}`)},
+			})
+			if err != nil {
+				t.Fatalf("QueryData error: %v", err)
+			}
+			dr := resp.Responses["A"]
+			if tt.wantErr != "" {
+				if dr.Error == nil || dr.Error.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", dr.Error, tt.wantErr)
+				}
+				return
+			}
+			if dr.Error != nil {
+				t.Fatalf("unexpected error: %v", dr.Error)
+			}
+			if n, _ := dr.Frames[0].RowLen(); n != tt.rows {
+				t.Fatalf("rows = %d, want %d", n, tt.rows)
+			}
+		})
+	}
+}
+
+func TestResourceIssues_NormalizesPaging(t *testing.T) {
+	var got atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.URL.Query())
+		_, _ = w.Write([]byte(`{"response":[]}`))
+	}))
+	defer srv.Close()
+
+	d := NewDatasource()
+	tests := []struct {
+		query               string
+		limit, offset, prio string
+	}{
+		{"page=2&pageSize=10&priority=P1", "10", "11", "P1"},
+		{"page=1", "100", "1", ""},
+		{"limit=5000&offset=0", "1000", "1", ""},
+		{"limit=20&offset=41", "20", "41", ""},
+	}
+	for _, tt := range tests {
+		resp := callResource(t, d, &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "issues", Method: http.MethodGet, URL: "issues?" + tt.query})
+		if resp.Status != http.StatusOK {
+			t.Fatalf("%s: status = %d", tt.query, resp.Status)
+		}
+		q := got.Load().(url.Values)
+		if q.Get("limit") != tt.limit || q.Get("offset") != tt.offset || q.Get("priority") != tt.prio {
+			t.Errorf("%s: forwarded %v, want limit=%s offset=%s priority=%q", tt.query, q, tt.limit, tt.offset, tt.prio)
+		}
+		if q.Has("page") || q.Has("pageSize") {
+			t.Errorf("%s: page params forwarded: %v", tt.query, q)
+		}
+	}
+}
+
+func TestHTTPClientFor_TransportTimeouts(t *testing.T) {
+	transport := func(s *InstanceSettings) *http.Transport {
+		t.Helper()
+		rt := NewDatasource().httpClientFor(s).Transport
+		if rl, ok := rt.(*rateLimitedTransport); ok {
+			rt = rl.next
+		}
+		tr, ok := rt.(*http.Transport)
+		if !ok {
+			t.Fatalf("transport = %T, want *http.Transport", rt)
+		}
+		return tr
+	}
+
+	s, err := ParseInstanceSettings([]byte(`{"baseUrl":"https://dnac","idleConnTimeoutSeconds":30,"tlsHandshakeTimeoutSeconds":5,"responseHeaderTimeoutSeconds":15,"requestsPerSecond":5}`), nil)
+	if err != nil {
+		t.Fatalf("ParseInstanceSettings error: %v", err)
+	}
+	tr := transport(s)
+	if tr.IdleConnTimeout != 30*time.Second || tr.TLSHandshakeTimeout != 5*time.Second || tr.ResponseHeaderTimeout != 15*time.Second {
+		t.Fatalf("timeouts = %v/%v/%v, want 30s/5s/15s", tr.IdleConnTimeout, tr.TLSHandshakeTimeout, tr.ResponseHeaderTimeout)
+	}
+
+	// Settings without them get the defaults.
+	tr = transport(&InstanceSettings{BaseURL: "https://dnac"})
+	if tr.IdleConnTimeout != 90*time.Second || tr.TLSHandshakeTimeout != 10*time.Second || tr.ResponseHeaderTimeout != 20*time.Second {
+		t.Fatalf("default timeouts = %v/%v/%v, want 90s/10s/20s", tr.IdleConnTimeout, tr.TLSHandshakeTimeout, tr.ResponseHeaderTimeout)
+	}
+}
+
+func TestResourceFilterOptions(t *testing.T) {
+	resp := callResource(t, NewDatasource(), &backend.CallResourceRequest{PluginContext: testPluginContext("https://dnac"), Path: "filter-options", Method: http.MethodGet})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.Status)
+	}
+	want := `{"priority":["P1","P2","P3","P4"],"issueStatus":["ACTIVE","IGNORED","RESOLVED"],` +
+		`"deviceRole":["ACCESS","AP","BORDER","CORE","DISTRIBUTION"],"deviceReachability":["Ping Reachable","Reachable","Unreachable"],` +
+		`"sortBy":["category","endTime","mostRecentOccurredTime","name","priority","startTime","status"],"sortOrder":["asc","desc"],"clientType":["ALL","WIRED","WIRELESS"]}`
+	if string(resp.Body) != want {
+		t.Fatalf("body = %s\nwant %s", resp.Body, want)
+	}
+
+	// Every listed value passes the filter normalization.
+	opts := knownFilterOptions()
+	for _, p := range opts.Priority {
+		if _, ok := normalizePriority(p, ""); !ok {
+			t.Errorf("priority %q rejected", p)
+		}
+	}
+	for _, st := range opts.IssueStatus {
+		if _, ok := normalizeIssueStatus(st, ""); !ok {
+			t.Errorf("status %q rejected", st)
+		}
+	}
+	for _, r := range opts.DeviceReachability {
+		if _, ok := normalizeDeviceReachability(r); !ok {
+			t.Errorf("reachability %q rejected", r)
+		}
+	}
+
+	resp = callResource(t, NewDatasource(), &backend.CallResourceRequest{PluginContext: testPluginContext("https://dnac"), Path: "filter-options", Method: http.MethodPost})
+	if resp.Status != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status = %d, want 405", resp.Status)
+	}
+}
+
+func TestQueryData_RetriesRateLimitedPage(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"}]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	dr := resp.Responses["A"]
+	if dr.Error != nil {
+		t.Fatalf("query error: %v", dr.Error)
+	}
+	if n, _ := dr.Frames[0].RowLen(); n != 1 {
+		t.Fatalf("rows = %d, want 1", n)
+	}
+}
+
+func TestQueryData_MaxScanPagesCapsScan(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		// Every page is full, so only the cap ends the scan.
+		issues := make([]map[string]any, 25)
+		for i := range issues {
+			issues[i] = map[string]any{"issueId": fmt.Sprintf("i%d-%d", n, i)}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"response": issues})
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","defaultPageSize":25,"maxScanPages":2}`)
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pc,
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","limit":1000}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	dr := resp.Responses["A"]
+	if dr.Error != nil {
+		t.Fatalf("query error: %v", dr.Error)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("upstream calls = %d, want 2", got)
+	}
+	frame := dr.Frames[0]
+	if n, _ := frame.RowLen(); n != 50 {
+		t.Fatalf("rows = %d, want 50", n)
+	}
+	var found bool
+	for _, n := range frame.Meta.Notices {
+		found = found || strings.Contains(n.Text, "maxScanPages")
+	}
+	if !found {
+		t.Fatalf("notices = %+v, want one about maxScanPages", frame.Meta.Notices)
+	}
+}
+
+func TestQueryData_IssueCountFast(t *testing.T) {
+	var paths []string
+	var countQuery url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/count") {
+			countQuery = r.URL.Query()
+			_, _ = w.Write([]byte(`{"response":1234,"version":"1.0"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"issueCountFast","priority":["P1"],"sortBy":"priority"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	dr := resp.Responses["A"]
+	if dr.Error != nil {
+		t.Fatalf("query error: %v", dr.Error)
+	}
+	if len(paths) != 1 || paths[0] != "/dna/data/api/v1/assuranceIssues/count" {
+		t.Fatalf("upstream paths = %v, want only the count endpoint", paths)
+	}
+	if countQuery.Get("priority") != "P1" || countQuery.Has("limit") || countQuery.Has("offset") || countQuery.Has("sortBy") {
+		t.Fatalf("count query = %v, want filters without paging or sorting", countQuery)
+	}
+	frame := dr.Frames[0]
+	if len(frame.Fields) != 1 || frame.Fields[0].Name != "Total" {
+		t.Fatalf("fields = %v, want a single Total", frame.Fields)
+	}
+	if got := frame.Fields[0].At(0).(int64); got != 1234 {
+		t.Fatalf("Total = %d, want 1234", got)
+	}
+}
+
+func TestQueryData_IssueCountFastFallsBackToScan(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/count") {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"},{"issueId":"i2"},{"issueId":"i3"}]}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"issueCountFast"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	dr := resp.Responses["A"]
+	if dr.Error != nil {
+		t.Fatalf("query error: %v", dr.Error)
+	}
+	frame := dr.Frames[0]
+	if got := frame.Fields[0].At(0).(int64); got != 3 {
+		t.Fatalf("Total = %d, want 3", got)
+	}
+	if frame.Meta == nil || len(frame.Meta.Notices) == 0 || !strings.Contains(frame.Meta.Notices[0].Text, "no issue count endpoint") {
+		t.Fatalf("notices = %+v, want the fallback notice", frame.Meta)
+	}
+}
+
+func TestQueryData_GzipResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("Accept-Encoding = %q, want gzip", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		_, _ = zw.Write([]byte(`{"response":[{"issueId":"i1"},{"issueId":"i2"}]}`))
+		_ = zw.Close()
+	}))
+	defer srv.Close()
+
+	d := NewDatasource()
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	dr := resp.Responses["A"]
+	if dr.Error != nil {
+		t.Fatalf("query error: %v", dr.Error)
+	}
+	if n, _ := dr.Frames[0].RowLen(); n != 2 {
+		t.Fatalf("rows = %d, want 2", n)
+	}
+
+	// Proxied resources are decompressed too.
+	res := callResource(t, d, &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "issues", Method: http.MethodGet})
+	if !strings.HasPrefix(string(res.Body), `{"response":`) {
+		t.Fatalf("resource body = %q, want decompressed JSON", res.Body)
+	}
+}
+
+func TestResourceIssues_NormalizesFilters(t *testing.T) {
+	var got atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.URL.Query())
+		_, _ = w.Write([]byte(`{"response":[]}`))
+	}))
+	defer srv.Close()
+
+	resp := callResource(t, NewDatasource(), &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "issues", Method: http.MethodGet, URL: "issues?priority=p2&status=Resolved&category=Onboarding"})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("status = %d", resp.Status)
+	}
+	q := got.Load().(url.Values)
+	if q.Get("priority") != "P2" || q.Get("status") != "resolved" || q.Get("category") != "Onboarding" {
+		t.Fatalf("forwarded %v, want priority=P2 status=resolved category=Onboarding", q)
+	}
+}
+
+func TestAPIError_TokenForbidden(t *testing.T) {
+	var status int32 = http.StatusForbidden
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		_, _ = w.Write([]byte(`{"response":{"errorCode":"NCGR10008","message":"Authentication failed"}}`))
+	}))
+	defer srv.Close()
+
+	s, err := ParseInstanceSettings([]byte(`{"baseUrl":"`+srv.URL+`","maxRetries":0}`), map[string]string{"username": "u", "password": "p"})
+	if err != nil {
+		t.Fatalf("ParseInstanceSettings error: %v", err)
+	}
+	_, err = newTokenManager().getToken(context.Background(), "test-uid", s, srv.Client())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("getToken error = %v, want an APIError", err)
+	}
+	if apiErr.Endpoint != "token" || apiErr.StatusCode != http.StatusForbidden || apiErr.Message != "Authentication failed (NCGR10008)" {
+		t.Fatalf("APIError = %+v, want token 403 with the decoded message", apiErr)
+	}
+
+	// Resource calls answer rejected credentials with 401, a failing token
+	// endpoint with 502.
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","maxRetries":0}`)
+	pc.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{"username": "u", "password": "p"}
+	req := &backend.CallResourceRequest{PluginContext: pc, Path: "devices", Method: http.MethodGet}
+	if resp := callResource(t, NewDatasource(), req); resp.Status != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", resp.Status)
+	}
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	if resp := callResource(t, NewDatasource(), req); resp.Status != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", resp.Status)
+	}
+}
+
+func TestAPIError_IssuesServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"response":{"message":"Internal error","detail":"database unavailable"}}`))
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","maxRetries":0}`)
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pc,
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(resp.Responses["A"].Error, &apiErr) {
+		t.Fatalf("query error = %v, want an APIError", resp.Responses["A"].Error)
+	}
+	if apiErr.Endpoint != "issues" || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Status != "500 Internal Server Error" || apiErr.Message != "Internal error: database unavailable" {
+		t.Fatalf("APIError = %+v, want issues 500 with the decoded message", apiErr)
+	}
+}
+
+func TestAPIError_UnauthorizedRefreshesOnce(t *testing.T) {
+	var tokens, calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dna/system/api/v1/auth/token" {
+			n := atomic.AddInt32(&tokens, 1)
+			_, _ = fmt.Fprintf(w, `{"Token":"t%d","expiresIn":3600}`, n)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "token rejected", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","maxRetries":0}`)
+	pc.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{"username": "u", "password": "p"}
+	d := NewDatasource()
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pc,
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(resp.Responses["A"].Error, &apiErr) || apiErr.Endpoint != "issues" || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("query error = %v, want an issues 401 APIError", resp.Responses["A"].Error)
+	}
+	if got := atomic.LoadInt32(&tokens); got != 2 {
+		t.Fatalf("token requests = %d, want 2 (one refresh)", got)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("issues requests = %d, want 2", got)
+	}
+
+	// authedGet, used by every other endpoint, refreshes the same way.
+	inst, err := getInstanceFromPluginContext(pc)
+	if err != nil {
+		t.Fatalf("instance error: %v", err)
+	}
+	atomic.StoreInt32(&tokens, 0)
+	atomic.StoreInt32(&calls, 0)
+	_, _, err = d.authedGet(context.Background(), srv.Client(), inst, "site", srv.URL+"/dna/intent/api/v1/site")
+	if !errors.As(err, &apiErr) || apiErr.Endpoint != "site" || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("authedGet error = %v, want a site 401 APIError", err)
+	}
+	if got := atomic.LoadInt32(&tokens); got != 1 {
+		t.Fatalf("token requests = %d, want 1 refresh", got)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("site requests = %d, want 2", got)
+	}
+}
+
+func TestQueryData_ResolvesDeviceIPFilter(t *testing.T) {
+	var lookups int32
+	var mu sync.Mutex
+	var filters []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip, ok := strings.CutPrefix(r.URL.Path, "/dna/intent/api/v1/network-device/ip-address/"); ok {
+			atomic.AddInt32(&lookups, 1)
+			if ip != "10.0.0.1" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(`{"response":{"id":"dev-1","managementIpAddress":"10.0.0.1"}}`))
+			return
+		}
+		mu.Lock()
+		filters = append(filters, r.URL.Query().Get("deviceId"))
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"}]}`))
+	}))
+	defer srv.Close()
+
+	// Run A and B one after the other so B reuses A's lookup of 10.0.0.1.
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"baseUrl":"` + srv.URL + `","queryConcurrency":1}`)
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pc,
+		Queries: []backend.DataQuery{
+			testQuery("A", `{"queryType":"alerts","deviceId":"10.0.0.1","priority":["P1"]}`),
+			testQuery("B", `{"queryType":"alerts","deviceId":" 10.0.0.1 ","priority":["P2"]}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if got := atomic.LoadInt32(&lookups); got != 1 {
+		t.Fatalf("IP lookups = %d, want 1", got)
+	}
+	if len(filters) != 2 || filters[0] != "dev-1" || filters[1] != "dev-1" {
+		t.Fatalf("deviceId filters = %q, want dev-1 twice", filters)
+	}
+	if n := resp.Responses["A"].Frames[0].Meta; n != nil && len(n.Notices) > 0 {
+		t.Fatalf("unexpected notices %+v", n.Notices)
+	}
+
+	// An unknown IP is sent as it is, with a warning.
+	filters = nil
+	resp, err = NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("C", `{"queryType":"alerts","deviceId":"10.0.0.9"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	if len(filters) != 1 || filters[0] != "10.0.0.9" {
+		t.Fatalf("deviceId filters = %q, want the IP as is", filters)
+	}
+	meta := resp.Responses["C"].Frames[0].Meta
+	if meta == nil || len(meta.Notices) != 1 || meta.Notices[0].Severity != data.NoticeSeverityWarning {
+		t.Fatalf("notices = %+v, want one warning", meta)
+	}
+}
+
+func TestGetSitesByID_Batches(t *testing.T) {
+	var calls int32
+	var failBatch atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		ids := strings.Split(r.URL.Query().Get("siteId"), ",")
+		if len(ids) > siteBatchSize {
+			t.Errorf("batch of %d IDs, want at most %d", len(ids), siteBatchSize)
+		}
+		if failBatch.Load() && slices.Contains(ids, "s60") {
+			http.Error(w, "boom", http.StatusBadRequest)
+			return
+		}
+		var sites []string
+		for _, id := range ids {
+			sites = append(sites, fmt.Sprintf(`{"id":%q,"siteName":"name-%s"}`, id, id))
+		}
+		_, _ = w.Write([]byte(`{"response":[` + strings.Join(sites, ",") + `]}`))
+	}))
+	defer srv.Close()
+
+	ids := make([]string, 120)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("s%d", i)
+	}
+	d := NewDatasource()
+	inst := &dsInstance{UID: "uid", Settings: &InstanceSettings{BaseURL: srv.URL, APIToken: "tok"}}
+	sites, err := d.getSitesByID(context.Background(), srv.Client(), inst, ids)
+	if err != nil {
+		t.Fatalf("getSitesByID error: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("site requests = %d, want 3", got)
+	}
+	if len(sites) != 120 || sites["s119"].Name != "name-s119" {
+		t.Fatalf("merged %d sites (s119 = %+v), want all 120", len(sites), sites["s119"])
+	}
+
+	// A failed batch leaves the other batches' sites resolved.
+	failBatch.Store(true)
+	sites, err = d.getSitesByID(context.Background(), srv.Client(), inst, ids)
+	if err == nil {
+		t.Fatal("getSitesByID succeeded, want the failed batch's error")
+	}
+	if len(sites) != 70 || sites["s60"].Name != "" || sites["s0"].Name != "name-s0" {
+		t.Fatalf("resolved %d sites, want the 70 of the batches that succeeded", len(sites))
+	}
+}
+
+func TestResourceIssueDetail(t *testing.T) {
+	const detail = `{"response":[{"issueId":"a/b 1","name":"AP down","impactedHosts":[{"hostName":"ap1"}]}]}`
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		switch {
+		case strings.HasSuffix(gotPath, "/missing"):
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+		case strings.HasSuffix(gotPath, "/broken"):
+			http.Error(w, `{"message":"boom"}`, http.StatusBadRequest)
+		default:
+			w.Header().Set("X-Request-Id", "req-1")
+			_, _ = w.Write([]byte(detail))
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDatasource()
+	get := func(query string) *backend.CallResourceResponse {
+		return callResource(t, d, &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "issue-detail", Method: http.MethodGet, URL: "issue-detail?" + query})
+	}
+
+	resp := get("id=" + url.QueryEscape("a/b 1"))
+	if resp.Status != http.StatusOK || string(resp.Body) != detail {
+		t.Fatalf("response = %d %s, want the upstream issue JSON", resp.Status, resp.Body)
+	}
+	if want := "/dna/data/api/v1/assuranceIssues/a%2Fb%201"; gotPath != want {
+		t.Fatalf("upstream path = %q, want %q", gotPath, want)
+	}
+	if got := resp.Headers["X-Request-Id"]; len(got) != 1 || got[0] != "req-1" {
+		t.Fatalf("X-Request-Id = %v, want forwarded", got)
+	}
+
+	if resp := get("id=missing"); resp.Status != http.StatusNotFound {
+		t.Fatalf("unknown issue status = %d, want 404", resp.Status)
+	}
+	if resp := get("id=broken"); resp.Status != http.StatusBadGateway {
+		t.Fatalf("upstream failure status = %d, want 502", resp.Status)
+	}
+	if resp := get(""); resp.Status != http.StatusBadRequest {
+		t.Fatalf("missing id status = %d, want 400", resp.Status)
+	}
+}
+
+func TestResolvePaths_Prefixed(t *testing.T) {
+	got := resolvePaths(&InstanceSettings{BaseURL: "https://gw.example.com/proxy/dnac/dna/intent/api/v1"})
+	want := resolvedPaths{
+		Token:         "https://gw.example.com/proxy/dnac/dna/system/api/v1/auth/token",
+		Issues:        "https://gw.example.com/proxy/dnac/dna/data/api/v1/assuranceIssues",
+		Site:          "https://gw.example.com/proxy/dnac/dna/intent/api/v1/site",
+		ClientHealth:  "https://gw.example.com/proxy/dnac/dna/intent/api/v1/client-health",
+		NetworkHealth: "https://gw.example.com/proxy/dnac/dna/intent/api/v1/network-health",
+	}
+	if got != want {
+		t.Fatalf("resolvePaths = %+v, want %+v", got, want)
+	}
+
+	// CheckHealth reports them even when the checks fail.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	res, err := NewDatasource().CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: testPluginContext(srv.URL + "/catalyst")})
+	if err != nil {
+		t.Fatalf("CheckHealth error: %v", err)
+	}
+	var details struct {
+		Paths resolvedPaths `json:"paths"`
+	}
+	if err := json.Unmarshal(res.JSONDetails, &details); err != nil {
+		t.Fatalf("JSONDetails %q: %v", res.JSONDetails, err)
+	}
+	if want := srv.URL + "/catalyst/dna/data/api/v1/assuranceIssues"; details.Paths.Issues != want {
+		t.Fatalf("paths.issues = %q, want %q", details.Paths.Issues, want)
+	}
+}
@@ -0,0 +1,413 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryClientHealth executes a clientHealth query: it fetches the client
+// health scores at the end of the time range and flattens them into one row
+// per site and client type, keeping only qm.ClientType when it is set. An
+// unknown client type is ignored with a warning.
+func (d *Datasource) queryClientHealth(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel) backend.DataResponse {
+	if err := ctx.Err(); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	var notices []data.Notice
+	clientType := ""
+	if raw := strings.TrimSpace(qm.ClientType); raw != "" {
+		if ct, ok := normalizeClientType(raw); ok {
+			clientType = ct
+		} else {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("unknown clientType %q ignored; want WIRED, WIRELESS or ALL", raw),
+			})
+		}
+	}
+
+	tsMs := ageReferenceMs(q.TimeRange)
+	sites, err := d.getClientHealth(ctx, httpClient, inst, clientHealthParams(tsMs))
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	frame := clientHealthToFrame(q.RefID, scopeClientType(sites, clientType), tsMs)
+	appendNotices(frame, notices...)
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// scopeClientType keeps only the client type buckets of clientType in each
+// site, matched case-insensitively. An empty clientType keeps them all.
+func scopeClientType(sites []ClientHealthSite, clientType string) []ClientHealthSite {
+	if clientType == "" {
+		return sites
+	}
+	out := make([]ClientHealthSite, 0, len(sites))
+	for _, site := range sites {
+		scoped := ClientHealthSite{SiteID: site.SiteID}
+		for _, detail := range site.ScoreDetail {
+			if strings.EqualFold(detail.ScoreCategory.Value, clientType) {
+				scoped.ScoreDetail = append(scoped.ScoreDetail, detail)
+			}
+		}
+		out = append(out, scoped)
+	}
+	return out
+}
+
+// clientHealthParams builds the client health query parameters. The endpoint
+// reports a single point in time, given as epoch milliseconds in "timestamp".
+func clientHealthParams(tsMs int64) url.Values {
+	v := url.Values{}
+	if tsMs > 0 {
+		v.Set("timestamp", strconv.FormatInt(tsMs, 10))
+	}
+	return v
+}
+
+// Paging of the client health endpoint, which reports one entry per site.
+const (
+	clientHealthPageSize = 25   // sites per page
+	clientHealthMaxSites = 1000 // hard limit across all pages
+)
+
+// getClientHealth fetches the client health scores of all sites, paging with
+// one-based offsets like the issues endpoint until a short page, the hard
+// limit or a page without new sites (a release that ignores paging) ends it.
+func (d *Datasource) getClientHealth(ctx context.Context, httpClient *http.Client, inst *dsInstance, params url.Values) ([]ClientHealthSite, error) {
+	healthURL, err := ClientHealthURL(inst.Settings.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad client health baseUrl: %w", err)
+	}
+
+	var all []ClientHealthSite
+	seen := make(map[string]struct{})
+	for offset := 1; len(all) < clientHealthMaxSites; offset += clientHealthPageSize {
+		if err := ctx.Err(); err != nil {
+			return all, err
+		}
+		pageParams := url.Values{}
+		for k, v := range params {
+			pageParams[k] = v
+		}
+		pageParams.Set("limit", strconv.Itoa(clientHealthPageSize))
+		pageParams.Set("offset", strconv.Itoa(offset))
+
+		page, err := d.getClientHealthPage(ctx, httpClient, inst, healthURL+"?"+pageParams.Encode())
+		if err != nil {
+			return all, err
+		}
+		added := 0
+		for _, site := range page {
+			if _, dup := seen[site.SiteID]; dup && site.SiteID != "" {
+				continue
+			}
+			seen[site.SiteID] = struct{}{}
+			all = append(all, site)
+			added++
+		}
+		if len(page) < clientHealthPageSize || added == 0 {
+			break
+		}
+	}
+	if len(all) > clientHealthMaxSites {
+		all = all[:clientHealthMaxSites]
+	}
+	return all, nil
+}
+
+// getClientHealthPage fetches one page of client health scores.
+func (d *Datasource) getClientHealthPage(ctx context.Context, httpClient *http.Client, inst *dsInstance, reqURL string) ([]ClientHealthSite, error) {
+	_, body, err := d.authedGet(ctx, httpClient, inst, "client health", reqURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope ClientHealthEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode client health response: %w", err)
+	}
+	return envelope.Response, nil
+}
+
+// clientHealthToFrame flattens client health scores into a frame with one
+// row per site and client type. Score buckets missing from the response are
+// reported as zero clients; a missing score or client count is a null.
+func clientHealthToFrame(refID string, sites []ClientHealthSite, tsMs int64) *data.Frame {
+	fTime := data.NewField("Time", nil, []time.Time{})
+	fSite := data.NewField("Site ID", nil, []string{})
+	fType := data.NewField("Client Type", nil, []string{})
+	fCount := data.NewField("Client Count", nil, []*int64{})
+	fGood := data.NewField("Good Clients", nil, []int64{})
+	fFair := data.NewField("Fair Clients", nil, []int64{})
+	fPoor := data.NewField("Poor Clients", nil, []int64{})
+	for _, f := range []*data.Field{fCount, fGood, fFair, fPoor} {
+		f.Config = &data.FieldConfig{Unit: "short", Decimals: ptrUint16(0)}
+	}
+
+	ts := time.UnixMilli(tsMs).UTC()
+	var scores []*float64
+	for _, site := range sites {
+		for _, detail := range site.ScoreDetail {
+			buckets := make(map[string]int64, len(detail.ScoreList))
+			for _, b := range detail.ScoreList {
+				if b.ClientCount != nil {
+					buckets[strings.ToUpper(b.ScoreCategory.Value)] = *b.ClientCount
+				}
+			}
+			fTime.Append(ts)
+			fSite.Append(site.SiteID)
+			fType.Append(detail.ScoreCategory.Value)
+			scores = append(scores, detail.ScoreValue)
+			fCount.Append(detail.ClientCount)
+			fGood.Append(buckets["GOOD"])
+			fFair.Append(buckets["FAIR"])
+			fPoor.Append(buckets["POOR"])
+		}
+	}
+	fScore := metricField("Health Score", scores)
+	fScore.Config = healthScoreConfig()
+	return data.NewFrame(frameName(refID, frameKindClientHealth, true), fTime, fSite, fType, fScore, fCount, fGood, fFair, fPoor)
+}
+
+// queryNetworkHealth executes a networkHealth query: it fetches the overall
+// network health buckets for the time range as a time series. The endpoint
+// can't be scoped by client type, so a set qm.ClientType only adds a notice.
+func (d *Datasource) queryNetworkHealth(ctx context.Context, inst *dsInstance, httpClient *http.Client, q backend.DataQuery, qm QueryModel) backend.DataResponse {
+	if err := ctx.Err(); err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	buckets, err := d.getNetworkHealth(ctx, httpClient, inst, networkHealthParams(q.TimeRange.From.UnixMilli(), q.TimeRange.To.UnixMilli()))
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	frame := networkHealthToFrame(q.RefID, buckets, qm.HealthMetrics...)
+	if ct := strings.TrimSpace(qm.ClientType); ct != "" && !strings.EqualFold(ct, "ALL") {
+		appendNotices(frame, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("clientType %q ignored: network health covers all clients", ct),
+		})
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// networkHealthParams forwards the query time range (epoch milliseconds) to
+// the network health endpoint. Zero bounds are omitted.
+func networkHealthParams(startTime, endTime int64) url.Values {
+	v := url.Values{}
+	if startTime > 0 {
+		v.Set("startTime", strconv.FormatInt(startTime, 10))
+	}
+	if endTime > 0 {
+		v.Set("endTime", strconv.FormatInt(endTime, 10))
+	}
+	return v
+}
+
+// getNetworkHealth fetches the network health buckets.
+func (d *Datasource) getNetworkHealth(ctx context.Context, httpClient *http.Client, inst *dsInstance, params url.Values) ([]map[string]any, error) {
+	healthURL, err := NetworkHealthURL(inst.Settings.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad network health baseUrl: %w", err)
+	}
+	reqURL := healthURL
+	if len(params) > 0 {
+		reqURL += "?" + params.Encode()
+	}
+
+	_, body, err := d.authedGet(ctx, httpClient, inst, "network health", reqURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope NetworkHealthEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode network health response: %w", err)
+	}
+	return envelope.Response, nil
+}
+
+// networkHealthToFrame turns network health buckets into a time series with
+// a Time and a Health Score field, ordered by time, plus a field per
+// additional bucket key in metrics, named after the key and labelled by
+// healthMetricConfig. Buckets without a usable time are skipped. Scores and
+// metrics are nullable, see metricField.
+func networkHealthToFrame(refID string, buckets []map[string]any, metrics ...string) *data.Frame {
+	type point struct {
+		ms     int64
+		bucket map[string]any
+	}
+	points := make([]point, 0, len(buckets))
+	for _, b := range buckets {
+		if ms, ok := bucketTimeMs(b); ok {
+			points = append(points, point{ms: ms, bucket: b})
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].ms < points[j].ms })
+
+	fTime := data.NewField("Time", nil, make([]time.Time, 0, len(points)))
+	for _, p := range points {
+		fTime.Append(time.UnixMilli(p.ms).UTC())
+	}
+	values := func(key string) []*float64 {
+		out := make([]*float64, 0, len(points))
+		for _, p := range points {
+			out = append(out, metricValue(p.bucket, key))
+		}
+		return out
+	}
+	fScore := metricField("Health Score", values("healthScore"))
+	fScore.Config = healthScoreConfig()
+	frame := data.NewFrame(frameName(refID, frameKindNetHealth, true), fTime, fScore)
+
+	seen := map[string]bool{"healthScore": true}
+	for _, key := range metrics {
+		key = strings.TrimSpace(key)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		f := metricField(key, values(key))
+		f.Config = healthMetricConfig(key)
+		frame.Fields = append(frame.Fields, f)
+	}
+	return frame
+}
+
+// healthMetric is the display name and unit of a health metric key.
+type healthMetric struct {
+	name string
+	unit string
+}
+
+// healthMetrics labels the metric keys of the health APIs, which make poor
+// legends as they are (e.g. networkHealthWired).
+var healthMetrics = map[string]healthMetric{
+	"networkHealthAverage":           {"Network Health", "percent"},
+	"networkHealthWired":             {"Wired Network Health", "percent"},
+	"networkHealthWireless":          {"Wireless Network Health", "percent"},
+	"clientHealthWired":              {"Wired Client Health", "percent"},
+	"clientHealthWireless":           {"Wireless Client Health", "percent"},
+	"healthyNetworkDevicePercentage": {"Healthy Devices", "percent"},
+	"healthyClientsPercentage":       {"Healthy Clients", "percent"},
+	"totalCount":                     {"Devices", "short"},
+	"goodCount":                      {"Good Devices", "short"},
+	"fairCount":                      {"Fair Devices", "short"},
+	"badCount":                       {"Poor Devices", "short"},
+	"noHealthCount":                  {"Devices Without Health", "short"},
+	"unmonCount":                     {"Unmonitored Devices", "short"},
+}
+
+// healthMetricConfig returns the config of the field of a health metric: its
+// display name and unit from healthMetrics, with percentages between 0 and
+// 100. Unknown keys get no config and keep their raw name.
+func healthMetricConfig(key string) *data.FieldConfig {
+	m, ok := healthMetrics[key]
+	if !ok {
+		return nil
+	}
+	cfg := &data.FieldConfig{Unit: m.unit}
+	if m.unit == "percent" {
+		cfg = healthScoreConfig()
+	}
+	cfg.DisplayName = m.name
+	return cfg
+}
+
+// metricValue returns the numeric value of key k in m, or nil when it is
+// missing or not a number. Numeric strings count as numbers, and values
+// wrapped as {"value": ...} are unwrapped.
+func metricValue(m map[string]any, k string) *float64 {
+	var f float64
+	switch x := unwrapValue(m[k]).(type) {
+	case float64:
+		f = x
+	case int64:
+		f = float64(x)
+	case json.Number:
+		n, err := x.Float64()
+		if err != nil {
+			return nil
+		}
+		f = n
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return nil
+		}
+		f = n
+	default:
+		return nil
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return &f
+}
+
+// metricField builds a nullable numeric field from values: *int64 when every
+// present value is integral, *float64 as soon as one is fractional, so e.g.
+// a health score of 87.5 keeps its decimals. Missing values are nulls.
+func metricField(name string, values []*float64) *data.Field {
+	integral := true
+	for _, v := range values {
+		if v != nil && *v != math.Trunc(*v) {
+			integral = false
+			break
+		}
+	}
+	if !integral {
+		return data.NewField(name, nil, values)
+	}
+	ints := make([]*int64, len(values))
+	for i, v := range values {
+		if v != nil {
+			n := int64(*v)
+			ints[i] = &n
+		}
+	}
+	return data.NewField(name, nil, ints)
+}
+
+// healthScoreConfig returns the config of "Health Score" fields: a
+// percentage between 0 and 100.
+func healthScoreConfig() *data.FieldConfig {
+	lo, hi := data.ConfFloat64(0), data.ConfFloat64(100)
+	return &data.FieldConfig{Unit: "percent", Min: &lo, Max: &hi}
+}
+
+// ptrUint16 returns a pointer to v, for optional FieldConfig values.
+func ptrUint16(v uint16) *uint16 { return &v }
+
+// bucketTimeMs returns the time of a health bucket in epoch milliseconds.
+// Buckets carry it as epoch milliseconds in "timestamp"/"timeinMillis", or
+// in "time" as either epoch milliseconds or an RFC 3339 string.
+func bucketTimeMs(b map[string]any) (int64, bool) {
+	if ms := firstNonZero(issueNum(b, "timestamp"), issueNum(b, "timeinMillis"), issueNum(b, "time")); ms != 0 {
+		return ms, true
+	}
+	for _, k := range []string{"time", "timestamp"} {
+		s := strings.TrimSpace(issueStr(b, k))
+		if s == "" {
+			continue
+		}
+		if ms, err := strconv.ParseInt(s, 10, 64); err == nil && ms > 0 {
+			return ms, true
+		}
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t.UnixMilli(), true
+		}
+	}
+	return 0, false
+}
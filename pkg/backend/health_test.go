@@ -0,0 +1,335 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestClientHealthParams(t *testing.T) {
+	if got := clientHealthParams(1_700_000_000_000).Get("timestamp"); got != "1700000000000" {
+		t.Fatalf("timestamp = %q, want 1700000000000", got)
+	}
+	if got := clientHealthParams(0); len(got) != 0 {
+		t.Fatalf("zero timestamp params = %v, want none", got)
+	}
+}
+
+func TestClientHealthToFrame(t *testing.T) {
+	body := `{"response":[{"siteId":"global","scoreDetail":[
+		{"scoreCategory":{"scoreCategory":"CLIENT_TYPE","value":"WIRED"},"scoreValue":90,"clientCount":12,
+		 "scoreList":[
+			{"scoreCategory":{"scoreCategory":"SCORE_TYPE","value":"POOR"},"clientCount":1},
+			{"scoreCategory":{"scoreCategory":"SCORE_TYPE","value":"FAIR"},"clientCount":2},
+			{"scoreCategory":{"scoreCategory":"SCORE_TYPE","value":"GOOD"},"clientCount":9}]},
+		{"scoreCategory":{"scoreCategory":"CLIENT_TYPE","value":"WIRELESS"},"scoreValue":75,"clientCount":4,
+		 "scoreList":[{"scoreCategory":{"scoreCategory":"SCORE_TYPE","value":"GOOD"},"clientCount":4}]}]}]}`
+	var env ClientHealthEnvelope
+	if err := json.Unmarshal([]byte(body), &env); err != nil {
+		t.Fatalf("bad fixture: %v", err)
+	}
+
+	frame := clientHealthToFrame("A", env.Response, 1_700_000_000_000)
+	if n, _ := frame.RowLen(); n != 2 {
+		t.Fatalf("rows = %d, want 2", n)
+	}
+	want := map[string][]int64{
+		"Health Score": {90, 75},
+		"Client Count": {12, 4},
+		"Good Clients": {9, 4},
+		"Fair Clients": {2, 0}, // bucket missing for WIRELESS
+		"Poor Clients": {1, 0},
+	}
+	for name, vals := range want {
+		f, idx := frame.FieldByName(name)
+		if idx < 0 {
+			t.Fatalf("missing field %q", name)
+		}
+		for i, w := range vals {
+			got, ok := f.ConcreteAt(i)
+			if !ok || got != w {
+				t.Errorf("%s[%d] = %v, want %d", name, i, got, w)
+			}
+		}
+	}
+	if f, _ := frame.FieldByName("Client Type"); f.At(1).(string) != "WIRELESS" {
+		t.Errorf("client type = %v, want WIRELESS", f.At(1))
+	}
+	if f, _ := frame.FieldByName("Good Clients"); f.Config == nil || f.Config.Unit != "short" {
+		t.Errorf("Good Clients config = %+v, want unit short", f.Config)
+	}
+}
+
+func TestNetworkHealthToFrame_FractionalScores(t *testing.T) {
+	buckets := []map[string]any{
+		{"timestamp": float64(1_700_000_000_000), "healthScore": float64(90)},
+		{"timestamp": float64(1_700_000_300_000), "healthScore": float64(87.5)},
+		{"timestamp": float64(1_700_000_600_000)},                       // missing
+		{"timestamp": float64(1_700_000_900_000), "healthScore": "n/a"}, // not numeric
+	}
+	f := networkHealthToFrame("A", buckets).Fields[1]
+	if f.Type() != data.FieldTypeNullableFloat64 {
+		t.Fatalf("Health Score type = %s, want nullable float64", f.Type())
+	}
+	if got := f.At(1).(*float64); got == nil || *got != 87.5 {
+		t.Fatalf("fractional score = %v, want 87.5", got)
+	}
+	for _, i := range []int{2, 3} {
+		if got := f.At(i).(*float64); got != nil {
+			t.Errorf("score[%d] = %v, want null", i, *got)
+		}
+	}
+}
+
+func TestMetricField_IntegralStaysInt(t *testing.T) {
+	v, zero := 80.0, 0.0
+	f := metricField("m", []*float64{&v, nil, &zero})
+	if f.Type() != data.FieldTypeNullableInt64 {
+		t.Fatalf("type = %s, want nullable int64", f.Type())
+	}
+	if got := f.At(2).(*int64); got == nil || *got != 0 {
+		t.Fatalf("zero = %v, want 0", got)
+	}
+	if got := f.At(1).(*int64); got != nil {
+		t.Fatalf("missing = %v, want null", *got)
+	}
+}
+
+func TestClientHealthToFrame_MissingValuesAreNull(t *testing.T) {
+	body := `{"response":[{"siteId":"global","scoreDetail":[
+		{"scoreCategory":{"value":"WIRED"},"scoreValue":0,"clientCount":0},
+		{"scoreCategory":{"value":"WIRELESS"}}]}]}`
+	var env ClientHealthEnvelope
+	if err := json.Unmarshal([]byte(body), &env); err != nil {
+		t.Fatalf("bad fixture: %v", err)
+	}
+	frame := clientHealthToFrame("A", env.Response, 0)
+	for _, name := range []string{"Health Score", "Client Count"} {
+		f, _ := frame.FieldByName(name)
+		if got, ok := f.ConcreteAt(0); !ok || got != int64(0) {
+			t.Errorf("%s[0] = %v, want a real 0", name, got)
+		}
+		if got, ok := f.ConcreteAt(1); ok {
+			t.Errorf("%s[1] = %v, want null", name, got)
+		}
+	}
+}
+
+func TestNetworkHealthParams(t *testing.T) {
+	v := networkHealthParams(1000, 2000)
+	if v.Get("startTime") != "1000" || v.Get("endTime") != "2000" {
+		t.Fatalf("params = %v, want startTime=1000&endTime=2000", v)
+	}
+}
+
+func TestNetworkHealthToFrame(t *testing.T) {
+	buckets := []map[string]any{
+		{"time": "2023-11-14T22:18:20Z", "healthScore": float64(90)},
+		{"timestamp": float64(1_700_000_000_000), "healthScore": float64(100)},
+		{"healthScore": float64(50)}, // no time: skipped
+	}
+	frame := networkHealthToFrame("A", buckets)
+	if n, _ := frame.RowLen(); n != 2 {
+		t.Fatalf("rows = %d, want 2", n)
+	}
+	// Ordered by time: the epoch bucket (22:13:20) precedes the string one.
+	if ts := frame.Fields[0].At(0).(time.Time); ts.UnixMilli() != 1_700_000_000_000 {
+		t.Fatalf("first time = %v, want 1700000000000", ts)
+	}
+	if got := frame.Fields[1].At(0).(*int64); got == nil || *got != 100 {
+		t.Fatalf("first score = %v, want 100", got)
+	}
+	if got := frame.Fields[1].At(1).(*int64); got == nil || *got != 90 {
+		t.Fatalf("second score = %v, want 90", got)
+	}
+	if cfg := frame.Fields[1].Config; cfg == nil || cfg.Unit != "percent" || *cfg.Min != 0 || *cfg.Max != 100 {
+		t.Fatalf("Health Score config = %+v, want percent 0-100", cfg)
+	}
+}
+
+// clientHealthPage renders client health entries for sites first..last.
+func clientHealthPage(first, last int) string {
+	var entries []string
+	for i := first; i <= last; i++ {
+		entries = append(entries, fmt.Sprintf(`{"siteId":"s%d","scoreDetail":[{"scoreCategory":{"value":"ALL"},"scoreValue":80,"clientCount":1}]}`, i))
+	}
+	return `{"response":[` + strings.Join(entries, ",") + `]}`
+}
+
+func TestQueryData_ClientHealthPaging(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Query().Get("limit") != "25" {
+			t.Errorf("limit = %q, want 25", r.URL.Query().Get("limit"))
+		}
+		switch offset, _ := strconv.Atoi(r.URL.Query().Get("offset")); offset {
+		case 1:
+			_, _ = w.Write([]byte(clientHealthPage(1, 25)))
+		case 26:
+			_, _ = w.Write([]byte(clientHealthPage(26, 30)))
+		default:
+			t.Errorf("unexpected offset %d", offset)
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: testPluginContext(srv.URL),
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"clientHealth"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	dr := resp.Responses["A"]
+	if dr.Error != nil {
+		t.Fatalf("response error: %v", dr.Error)
+	}
+	f, _ := dr.Frames[0].FieldByName("Site ID")
+	if f.Len() != 30 || f.At(29).(string) != "s30" {
+		t.Fatalf("sites = %d (last %v), want all 30", f.Len(), f.At(f.Len()-1))
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("upstream calls = %d, want 2", got)
+	}
+}
+
+func TestGetClientHealth_StopsWhenPagingIgnored(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(clientHealthPage(1, 25)))
+	}))
+	defer srv.Close()
+
+	inst := &dsInstance{UID: "uid", Settings: &InstanceSettings{BaseURL: srv.URL, APIToken: "tok"}}
+	sites, err := NewDatasource().getClientHealth(context.Background(), srv.Client(), inst, nil)
+	if err != nil || len(sites) != 25 {
+		t.Fatalf("getClientHealth = (%d sites, %v), want 25", len(sites), err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("upstream calls = %d, want 2", got)
+	}
+}
+
+func TestQueryData_ClientHealthRefreshesExpiredToken(t *testing.T) {
+	var tokens, healthCalls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dna/system/api/v1/auth/token" {
+			n := atomic.AddInt32(&tokens, 1)
+			_, _ = fmt.Fprintf(w, `{"Token":"t%d","expiresIn":3600}`, n)
+			return
+		}
+		atomic.AddInt32(&healthCalls, 1)
+		// The first token has expired upstream even though it is still cached.
+		if r.Header.Get("X-Auth-Token") != "t2" {
+			http.Error(w, "token expired", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(clientHealthPage(1, 1)))
+	}))
+	defer srv.Close()
+
+	pc := testPluginContext(srv.URL)
+	pc.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{"username": "u", "password": "p"}
+	resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pc,
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"clientHealth"}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	dr := resp.Responses["A"]
+	if dr.Error != nil {
+		t.Fatalf("response error: %v", dr.Error)
+	}
+	if n, _ := dr.Frames[0].RowLen(); n != 1 {
+		t.Fatalf("rows = %d, want 1", n)
+	}
+	if got := atomic.LoadInt32(&tokens); got != 2 {
+		t.Fatalf("token requests = %d, want 2", got)
+	}
+	if got := atomic.LoadInt32(&healthCalls); got != 2 {
+		t.Fatalf("health requests = %d, want 2", got)
+	}
+}
+
+func TestQueryData_ClientHealthClientType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"response":[{"siteId":"s1","scoreDetail":[
+			{"scoreCategory":{"value":"ALL"},"scoreValue":80,"clientCount":16},
+			{"scoreCategory":{"value":"WIRED"},"scoreValue":90,"clientCount":12},
+			{"scoreCategory":{"value":"WIRELESS"},"scoreValue":75,"clientCount":4}]}]}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		clientType string
+		want       []string
+		warning    bool
+	}{
+		{"", []string{"ALL", "WIRED", "WIRELESS"}, false},
+		{"WIRED", []string{"WIRED"}, false},
+		{"wireless", []string{"WIRELESS"}, false},
+		{"ALL", []string{"ALL"}, false},
+		{"FIBER", []string{"ALL", "WIRED", "WIRELESS"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.clientType, func(t *testing.T) {
+			resp, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: testPluginContext(srv.URL),
+				Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"clientHealth","clientType":"`+tt.clientType+`"}`)},
+			})
+			if err != nil {
+				t.Fatalf("QueryData error: %v", err)
+			}
+			frame := resp.Responses["A"].Frames[0]
+			f, _ := frame.FieldByName("Client Type")
+			var got []string
+			for i := 0; i < f.Len(); i++ {
+				got = append(got, f.At(i).(string))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("client types = %v, want %v", got, tt.want)
+			}
+			warned := frame.Meta != nil && len(frame.Meta.Notices) == 1 && frame.Meta.Notices[0].Severity == data.NoticeSeverityWarning
+			if warned != tt.warning {
+				t.Fatalf("meta = %+v, want warning %t", frame.Meta, tt.warning)
+			}
+		})
+	}
+}
+
+func TestNetworkHealthToFrame_MetricDisplayNames(t *testing.T) {
+	buckets := []map[string]any{
+		{"timestamp": float64(1_700_000_000_000), "healthScore": float64(90), "networkHealthWired": float64(95), "goodCount": float64(40), "customKpi": float64(3)},
+	}
+	frame := networkHealthToFrame("A", buckets, "networkHealthWired", "goodCount", "customKpi", "healthScore")
+	if len(frame.Fields) != 5 {
+		t.Fatalf("fields = %d, want Time, Health Score and 3 metrics", len(frame.Fields))
+	}
+	wired, _ := frame.FieldByName("networkHealthWired")
+	if wired == nil || wired.Config == nil || wired.Config.DisplayName != "Wired Network Health" || wired.Config.Unit != "percent" {
+		t.Fatalf("networkHealthWired = %+v, want display name Wired Network Health in percent", wired)
+	}
+	if got := wired.At(0).(*int64); got == nil || *got != 95 {
+		t.Fatalf("networkHealthWired = %v, want 95", got)
+	}
+	if good, _ := frame.FieldByName("goodCount"); good.Config == nil || good.Config.DisplayName != "Good Devices" || good.Config.Unit != "short" {
+		t.Fatalf("goodCount config = %+v, want Good Devices, short", good.Config)
+	}
+	// Unknown keys keep their raw name.
+	if kpi, _ := frame.FieldByName("customKpi"); kpi == nil || kpi.Config != nil {
+		t.Fatalf("customKpi = %+v, want a field without config", kpi)
+	}
+}
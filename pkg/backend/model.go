@@ -1,9 +1,16 @@
 package backend
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // InstanceSettings holds the configuration for a single instance of the datasource.
@@ -22,6 +29,109 @@ type InstanceSettings struct {
 	Password string
 	// APIToken allows for manual override of the token, bypassing username/password auth.
 	APIToken string
+	// TokenExpiryField names a top-level field of the token response body that
+	// holds the token expiry. When set, it replaces the expiry heuristics.
+	TokenExpiryField string
+	// TokenExpiryUnit tells how to read TokenExpiryField: "seconds" (relative,
+	// the default), "epoch" (Unix seconds) or "epochMillis" (Unix milliseconds).
+	TokenExpiryUnit string
+	// AuthHeaderName is the request header that carries the token, for
+	// proxies that expect another one (e.g. "Authorization"). Defaults to
+	// X-Auth-Token.
+	AuthHeaderName string
+	// AuthHeaderFormat renders the header value from the token via its %s,
+	// e.g. "Bearer %s". Defaults to the raw token.
+	AuthHeaderFormat string
+	// TokenFilePath names a file holding the token, e.g. one a sidecar
+	// rotates into a mounted volume. It is used instead of username and
+	// password, which remain the fallback while the file is missing or empty.
+	TokenFilePath string
+	// DefaultTokenTTL is how long a token is cached when its response gives
+	// no expiry hint. Defaults to 55 minutes.
+	DefaultTokenTTL time.Duration
+	// MinTokenTTL is how long a token is cached when its hinted expiry is
+	// already past or less than a minute away. Defaults to 5 minutes.
+	MinTokenTTL time.Duration
+	// HTTPTimeoutSeconds bounds every outbound request, including reading the
+	// body. Defaults to 30 and is capped at 300.
+	HTTPTimeoutSeconds int
+	// IdleConnTimeout closes pooled connections idle for this long.
+	// Defaults to 90 seconds.
+	IdleConnTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake of a new connection, so
+	// a cluster hanging mid-handshake fails fast. Defaults to 10 seconds.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for response headers once a
+	// request is sent. Defaults to 20 seconds.
+	ResponseHeaderTimeout time.Duration
+	// DefaultPageSize is how many issues are requested per page. Defaults to
+	// 100 and is capped at 1000.
+	DefaultPageSize int
+	// DefaultLimit is the number of issues a query fetches when it sets no
+	// limit of its own. Defaults to 100 and is capped at 10000.
+	DefaultLimit int
+	// MaxScanPages caps how many issue pages one query fetches, whatever its
+	// limit, so a wide query can't hammer the cluster. Defaults to 50.
+	MaxScanPages int
+	// QueryConcurrency bounds how many queries of one request run at once.
+	// Defaults to 4.
+	QueryConcurrency int
+	// RetryPolicy controls how every outbound request retries transient
+	// failures. See defaultRetryPolicy for the defaults.
+	RetryPolicy RetryPolicy
+	// DisplayTimezone is an IANA zone name (e.g. "Europe/Berlin"). When set,
+	// issue frames gain a "Local Time" string column rendered in this zone,
+	// intended for CSV exports. Time fields themselves are always UTC.
+	DisplayTimezone string
+	// DisplayLocation is DisplayTimezone loaded; nil when unset.
+	DisplayLocation *time.Location
+	// ClientCert and ClientKey are a PEM-encoded client certificate and key
+	// for mutual TLS, e.g. with a proxy in front of Catalyst Center. Both or
+	// neither must be set.
+	ClientCert string
+	ClientKey  string
+	// ClientCertificate is the parsed ClientCert/ClientKey pair; nil when unset.
+	ClientCertificate *tls.Certificate
+	// TokenPath overrides the authentication route for gateways that expose
+	// it elsewhere (e.g. "/auth/v2/token"). Any reverse-proxy prefix in
+	// BaseURL is still preserved. Empty means the standard route.
+	TokenPath string
+	// DefaultQuery is a JSON object of QueryModel fields merged under every
+	// query, e.g. {"issueStatus":"ACTIVE","priority":["P1","P2"]}, so minimal
+	// queries get a safe scope. Fields set by a query always win.
+	DefaultQuery json.RawMessage
+	// ForwardHeaders lists the upstream response headers that resource calls
+	// pass back to the caller, for debugging. Defaults to
+	// defaultForwardHeaders; cookies and auth headers are never forwarded.
+	ForwardHeaders []string
+	// IssueLinkTemplate is a Go text/template for the "Issue URL" column,
+	// executed with .BaseURL (the UI root, see LinkBaseURL) and .IssueID.
+	// Empty means the standard assurance issue details page.
+	IssueLinkTemplate string
+	// RequestsPerSecond caps the rate of outbound requests of the instance,
+	// with bursts of up to Burst requests (default: one second's worth).
+	// Zero means unlimited.
+	RequestsPerSecond float64
+	Burst             int
+	// ProxyURL routes all outbound requests through this HTTP(S) proxy,
+	// overriding HTTP_PROXY/HTTPS_PROXY/NO_PROXY, which apply otherwise.
+	ProxyURL string
+	// Proxy is ProxyURL parsed; nil when unset.
+	Proxy *url.URL
+	// ProxyUsername and ProxyPassword authenticate to the proxy (ProxyURL,
+	// or the one from the environment) with basic auth. They come from the
+	// secure settings and are never logged.
+	ProxyUsername string
+	ProxyPassword string
+	// BreakerThreshold is how many consecutive failed requests (network
+	// errors, timeouts and 5xx responses) open the instance's circuit breaker,
+	// which then fails requests fast for BreakerCooldown before letting one probe
+	// through. Default 5 failures and 30 seconds.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// UserAgent is sent as the User-Agent header of all outbound requests.
+	// Default "grafana-catalyst-datasource/<version>".
+	UserAgent string
 }
 
 // ParseInstanceSettings unmarshals and validates the datasource instance settings
@@ -30,42 +140,207 @@ func ParseInstanceSettings(jsonData json.RawMessage, secureData map[string]strin
 	var jd struct {
 		BaseURL            string `json:"baseUrl"`
 		InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+		TokenExpiryField   string `json:"tokenExpiryField"`
+		TokenExpiryUnit    string `json:"tokenExpiryUnit"`
+		DefaultTokenTTL    int    `json:"defaultTokenTtlSeconds"`
+		AuthHeaderName     string `json:"authHeaderName"`
+		TokenFilePath      string `json:"tokenFilePath"`
+		AuthHeaderFormat   string `json:"authHeaderFormat"`
+		MinTokenTTL        int    `json:"minTokenTtlSeconds"`
+		HTTPTimeoutSeconds int    `json:"httpTimeoutSeconds"`
+		IdleConnTimeout    int    `json:"idleConnTimeoutSeconds"`
+		TLSHandshake       int    `json:"tlsHandshakeTimeoutSeconds"`
+		ResponseHeader     int    `json:"responseHeaderTimeoutSeconds"`
+		QueryConcurrency   int    `json:"queryConcurrency"`
+		DefaultPageSize    int    `json:"defaultPageSize"`
+		DefaultLimit       int    `json:"defaultLimit"`
+		MaxScanPages       int    `json:"maxScanPages"`
+		BreakerThreshold   int    `json:"circuitBreakerThreshold"`
+		BreakerCooldown    int    `json:"circuitBreakerCooldownSeconds"`
+		MaxRetries         *int   `json:"maxRetries"` // shorthand for retryPolicy.maxAttempts-1
+		RetryPolicy        struct {
+			MaxAttempts int   `json:"maxAttempts"`
+			BaseDelayMs int   `json:"baseDelayMs"`
+			MaxDelayMs  int   `json:"maxDelayMs"`
+			Jitter      *bool `json:"jitter"`
+		} `json:"retryPolicy"`
+		DisplayTimezone   string          `json:"displayTimezone"`
+		TokenPath         string          `json:"tokenPath"`
+		DefaultQuery      json.RawMessage `json:"defaultQuery"`
+		ForwardHeaders    []string        `json:"forwardHeaders"`
+		IssueLinkTemplate string          `json:"issueLinkTemplate"`
+		ProxyURL          string          `json:"proxyUrl"`
+		RequestsPerSecond float64         `json:"requestsPerSecond"`
+		Burst             int             `json:"burst"`
+		UserAgent         string          `json:"userAgent"`
 	}
 	_ = json.Unmarshal(jsonData, &jd)
 
+	unit := strings.TrimSpace(jd.TokenExpiryUnit)
+	switch unit {
+	case "":
+		unit = expiryUnitSeconds
+	case expiryUnitSeconds, expiryUnitEpoch, expiryUnitEpochMillis:
+	default:
+		return nil, fmt.Errorf("invalid tokenExpiryUnit %q: want seconds, epoch or epochMillis", unit)
+	}
+
+	baseURL := strings.TrimRight(strings.TrimSpace(jd.BaseURL), "/")
+	if err := validateBaseURL(baseURL); err != nil {
+		return nil, err
+	}
+
 	s := &InstanceSettings{
-		BaseURL:            strings.TrimRight(jd.BaseURL, "/"),
+		BaseURL:            baseURL,
 		InsecureSkipVerify: jd.InsecureSkipVerify,
 		Username:           secureData["username"],
 		Password:           secureData["password"],
 		APIToken:           secureData["apiToken"],
+		TokenExpiryField:   strings.TrimSpace(jd.TokenExpiryField),
+		TokenExpiryUnit:    unit,
+		DefaultTokenTTL:    time.Duration(clampLimit(jd.DefaultTokenTTL, 3300, 60, 86400)) * time.Second,
+		MinTokenTTL:        time.Duration(clampLimit(jd.MinTokenTTL, 300, 10, 3600)) * time.Second,
+		HTTPTimeoutSeconds: clampLimit(jd.HTTPTimeoutSeconds, 30, 1, 300),
+		QueryConcurrency:   clampLimit(jd.QueryConcurrency, 4, 1, 32),
+		DefaultPageSize:    clampLimit(jd.DefaultPageSize, 100, 1, 1000),
+		DefaultLimit:       clampLimit(jd.DefaultLimit, 100, 1, 10000),
+		RetryPolicy:        defaultRetryPolicy(),
+		TokenPath:          strings.TrimSpace(jd.TokenPath),
+		IssueLinkTemplate:  strings.TrimSpace(jd.IssueLinkTemplate),
+	}
+	s.TokenFilePath = strings.TrimSpace(jd.TokenFilePath)
+	s.MaxScanPages = clampLimit(jd.MaxScanPages, defaultMaxScanPages, 1, 1000)
+	s.BreakerThreshold = clampLimit(jd.BreakerThreshold, 5, 1, 100)
+	s.BreakerCooldown = time.Duration(clampLimit(jd.BreakerCooldown, 30, 1, 3600)) * time.Second
+	s.UserAgent = strings.TrimSpace(jd.UserAgent)
+	if s.UserAgent == "" {
+		s.UserAgent = defaultUserAgent()
+	}
+	s.IdleConnTimeout = time.Duration(clampLimit(jd.IdleConnTimeout, 90, 1, 3600)) * time.Second
+	s.TLSHandshakeTimeout = time.Duration(clampLimit(jd.TLSHandshake, 10, 1, 300)) * time.Second
+	s.ResponseHeaderTimeout = time.Duration(clampLimit(jd.ResponseHeader, 20, 1, 300)) * time.Second
+	if tz := strings.TrimSpace(jd.DisplayTimezone); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid displayTimezone %q: %w", tz, err)
+		}
+		s.DisplayTimezone = tz
+		s.DisplayLocation = loc
+	}
+	s.AuthHeaderName = defaultAuthHeaderName
+	if h := strings.TrimSpace(jd.AuthHeaderName); h != "" {
+		if strings.ContainsAny(h, " :\r\n") {
+			return nil, fmt.Errorf("invalid authHeaderName %q", h)
+		}
+		s.AuthHeaderName = h
+	}
+	if f := strings.TrimSpace(jd.AuthHeaderFormat); f != "" {
+		if strings.Count(f, "%s") != 1 || strings.Count(f, "%") != 1 {
+			return nil, fmt.Errorf("invalid authHeaderFormat %q: want one %%s for the token, e.g. \"Bearer %%s\"", f)
+		}
+		s.AuthHeaderFormat = f
+	}
+	if jd.RequestsPerSecond > 0 {
+		s.RequestsPerSecond = jd.RequestsPerSecond
+		s.Burst = clampLimit(jd.Burst, 0, 1, 1000)
+	}
+	if p := strings.TrimSpace(jd.ProxyURL); p != "" {
+		u, err := url.Parse(p)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid proxyUrl %q: want http(s)://host:port", p)
+		}
+		s.ProxyURL = p
+		s.Proxy = u
+	}
+	s.ProxyUsername = secureData["proxyUsername"]
+	s.ProxyPassword = secureData["proxyPassword"]
+	if s.ProxyUsername == "" && s.ProxyPassword != "" {
+		return nil, errors.New("proxy password configured without a proxy username")
+	}
+	s.ClientCert = strings.TrimSpace(secureData["clientCert"])
+	s.ClientKey = strings.TrimSpace(secureData["clientKey"])
+	switch {
+	case s.ClientCert != "" && s.ClientKey != "":
+		cert, err := tls.X509KeyPair([]byte(s.ClientCert), []byte(s.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate/key: %w", err)
+		}
+		s.ClientCertificate = &cert
+	case s.ClientCert != "" || s.ClientKey != "":
+		return nil, errors.New("client certificate and client key must be configured together")
+	}
+	if dq := bytes.TrimSpace(jd.DefaultQuery); len(dq) > 0 && !bytes.Equal(dq, []byte("null")) {
+		var probe map[string]any
+		if err := json.Unmarshal(dq, &probe); err != nil {
+			return nil, fmt.Errorf("invalid defaultQuery: must be a JSON object: %w", err)
+		}
+		s.DefaultQuery = dq
+	}
+	s.ForwardHeaders = defaultForwardHeaders
+	if jd.ForwardHeaders != nil {
+		s.ForwardHeaders = nil
+		for _, h := range jd.ForwardHeaders {
+			if h = strings.TrimSpace(h); h != "" {
+				s.ForwardHeaders = append(s.ForwardHeaders, h)
+			}
+		}
+	}
+	rp := &s.RetryPolicy
+	if jd.MaxRetries != nil {
+		rp.MaxAttempts = clampLimit(*jd.MaxRetries+1, 1, 1, 11)
+	}
+	if n := jd.RetryPolicy.MaxAttempts; n > 0 {
+		rp.MaxAttempts = clampLimit(n, 1, 1, 11)
+	}
+	if ms := jd.RetryPolicy.BaseDelayMs; ms > 0 {
+		rp.BaseDelay = time.Duration(clampLimit(ms, 200, 10, 60_000)) * time.Millisecond
+	}
+	if ms := jd.RetryPolicy.MaxDelayMs; ms > 0 {
+		rp.MaxDelay = time.Duration(clampLimit(ms, 5_000, 10, 300_000)) * time.Millisecond
+	}
+	if rp.MaxDelay < rp.BaseDelay {
+		rp.MaxDelay = rp.BaseDelay
+	}
+	if jd.RetryPolicy.Jitter != nil {
+		rp.Jitter = *jd.RetryPolicy.Jitter
 	}
 	return s, nil
 }
 
+// clientKey fingerprints the settings that shape the HTTP client, so a cached
+// client can be rebuilt when any of them changes.
+func (s *InstanceSettings) clientKey() string {
+	// The client certificate and proxy credentials are hashed so secrets
+	// never end up in the key.
+	certSum := sha256.Sum256([]byte(s.ClientCert + "\x00" + s.ClientKey))
+	proxyAuthSum := sha256.Sum256([]byte(s.ProxyUsername + "\x00" + s.ProxyPassword))
+	return fmt.Sprintf("tls-skip=%t;timeout=%d;idle=%s;handshake=%s;header=%s;cert=%x;proxy=%s;proxy-auth=%x;rps=%g;burst=%d;ua=%q",
+		s.InsecureSkipVerify, s.HTTPTimeoutSeconds, s.IdleConnTimeout, s.TLSHandshakeTimeout, s.ResponseHeaderTimeout,
+		certSum[:8], s.ProxyURL, proxyAuthSum[:8], s.RequestsPerSecond, s.Burst, s.UserAgent)
+}
+
 // dnacPrefix extracts any reverse-proxy prefix that appears BEFORE the /dna path segment.
 // This is crucial for ensuring API calls are correctly routed when Catalyst Center
-// is behind a reverse proxy.
+// is behind a reverse proxy. A path without a /dna segment is taken to be a bare
+// proxy mount point and is kept whole.
 // Example:
 //
 //	"/proxy/dnac/dna/intent/api/v1" -> "/proxy/dnac"
 //	"/dna/intent/api/v1"            -> ""
-//	"/something" (no /dna)          -> ""
+//	"/catalyst" (no /dna)           -> "/catalyst"
+//	"/" or ""                       -> ""
 func dnacPrefix(p string) string {
 	if p == "" {
 		return ""
 	}
 	segs := strings.Split(p, "/")
-	idx := -1
+	idx := len(segs)
 	for i, s := range segs {
 		if s == "dna" {
 			idx = i
 			break
 		}
 	}
-	if idx == -1 {
-		return ""
-	}
 	prefix := strings.Join(segs[:idx], "/")
 	if prefix != "" && !strings.HasPrefix(prefix, "/") {
 		prefix = "/" + prefix
@@ -73,21 +348,105 @@ func dnacPrefix(p string) string {
 	return strings.TrimRight(prefix, "/")
 }
 
+// validateBaseURL checks that a configured base URL is an absolute http(s)
+// URL with a host, so a typo like "catalyst.example.com" is reported up front
+// rather than as a confusing request error. An empty base URL is left for
+// the requests to report, as the data source is not configured yet.
+func validateBaseURL(base string) error {
+	if base == "" {
+		return nil
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("invalid baseUrl %q: %w", base, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid baseUrl %q: must start with http:// or https://", base)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid baseUrl %q: missing host", base)
+	}
+	return nil
+}
+
+// defaultMaxScanPages is the MaxScanPages of instances that don't set it.
+const defaultMaxScanPages = 50
+
+// defaultAuthHeaderName is the header Catalyst Center reads the token from.
+const defaultAuthHeaderName = "X-Auth-Token"
+
+// setAuthHeader sets the token header of an outbound request, named and
+// formatted per AuthHeaderName and AuthHeaderFormat.
+func (s *InstanceSettings) setAuthHeader(h http.Header, token string) {
+	name := s.AuthHeaderName
+	if name == "" {
+		name = defaultAuthHeaderName
+	}
+	value := token
+	if s.AuthHeaderFormat != "" {
+		value = fmt.Sprintf(s.AuthHeaderFormat, token)
+	}
+	h.Set(name, value)
+}
+
+// defaultTokenPath is the standard Catalyst Center authentication route.
+const defaultTokenPath = "/dna/system/api/v1/auth/token"
+
 // TokenURL constructs the full URL for the authentication token endpoint,
 // preserving any reverse proxy prefix from the base URL.
-// It always points to <prefix>/dna/system/api/v1/auth/token.
-func TokenURL(base string) (string, error) {
+// It points to <prefix><tokenPath>, or <prefix>/dna/system/api/v1/auth/token
+// when tokenPath is empty.
+func TokenURL(base, tokenPath string) (string, error) {
 	u, err := url.Parse(base)
 	if err != nil {
 		return "", err
 	}
+	p := strings.TrimSpace(tokenPath)
+	if p == "" {
+		p = defaultTokenPath
+	} else if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
 	prefix := dnacPrefix(u.Path)
-	u.Path = prefix + "/dna/system/api/v1/auth/token"
+	u.Path = prefix + p
 	u.RawQuery = ""
 	u.Fragment = ""
 	return u.String(), nil
 }
 
+// IssuesQueryURL constructs the full URL for the POST-based issues query
+// endpoint, preserving any reverse proxy prefix.
+// It always points to <prefix>/dna/data/api/v1/assuranceIssues/query.
+func IssuesQueryURL(base string) (string, error) {
+	u, err := IssuesURL(base)
+	if err != nil {
+		return "", err
+	}
+	return u + "/query", nil
+}
+
+// IssuesCountURL constructs the full URL for the issue count endpoint,
+// preserving any reverse proxy prefix.
+// It always points to <prefix>/dna/data/api/v1/assuranceIssues/count.
+func IssuesCountURL(base string) (string, error) {
+	u, err := IssuesURL(base)
+	if err != nil {
+		return "", err
+	}
+	return u + "/count", nil
+}
+
+// IssueDetailURL constructs the URL of the issue with the given ID,
+// preserving any reverse proxy prefix.
+// It points to <prefix>/dna/data/api/v1/assuranceIssues/<id>.
+func IssueDetailURL(base, id string) (string, error) {
+	u, err := IssuesURL(base)
+	if err != nil {
+		return "", err
+	}
+	return u + "/" + url.PathEscape(id), nil
+}
+
 // IssuesURL constructs the full URL for the issues/alerts endpoint,
 // preserving any reverse proxy prefix.
 // It always points to <prefix>/dna/data/api/v1/assuranceIssues.
@@ -118,6 +477,92 @@ func SiteURL(base string) (string, error) {
 	return u.String(), nil
 }
 
+// NetworkDeviceURL constructs the full URL for the network device endpoint,
+// preserving any reverse proxy prefix.
+// It always points to <prefix>/dna/intent/api/v1/network-device.
+func NetworkDeviceURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	prefix := dnacPrefix(u.Path)
+	u.Path = prefix + "/dna/intent/api/v1/network-device"
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// NetworkDeviceByIPURL constructs the URL looking up the network device with
+// the management IP ip, preserving any reverse proxy prefix.
+// It points to <prefix>/dna/intent/api/v1/network-device/ip-address/<ip>.
+func NetworkDeviceByIPURL(base, ip string) (string, error) {
+	deviceURL, err := NetworkDeviceURL(base)
+	if err != nil {
+		return "", err
+	}
+	return deviceURL + "/ip-address/" + url.PathEscape(ip), nil
+}
+
+// IssueDefinitionsURL constructs the full URL for the system issue
+// definitions (the issue rule catalog), preserving any reverse proxy prefix.
+// It always points to <prefix>/dna/intent/api/v1/systemIssueDefinitions.
+func IssueDefinitionsURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	prefix := dnacPrefix(u.Path)
+	u.Path = prefix + "/dna/intent/api/v1/systemIssueDefinitions"
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// LinkBaseURL returns the root that links into the Catalyst Center UI are
+// built on: the scheme, host and any reverse-proxy prefix of base, without
+// a trailing slash.
+func LinkBaseURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	u.Path = dnacPrefix(u.Path)
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// ClientHealthURL constructs the full URL for the client health endpoint,
+// preserving any reverse proxy prefix.
+// It always points to <prefix>/dna/intent/api/v1/client-health.
+func ClientHealthURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	prefix := dnacPrefix(u.Path)
+	u.Path = prefix + "/dna/intent/api/v1/client-health"
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// NetworkHealthURL constructs the full URL for the network health endpoint,
+// preserving any reverse proxy prefix.
+// It always points to <prefix>/dna/intent/api/v1/network-health.
+func NetworkHealthURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	prefix := dnacPrefix(u.Path)
+	u.Path = prefix + "/dna/intent/api/v1/network-health"
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
 // StringOrBool is a custom type that can unmarshal both boolean (true/false)
 // and string ("true", "false", "yes", "no") values from JSON into a normalized
 // string representation. This provides flexibility for API fields that might
@@ -155,6 +600,17 @@ func (v *StringOrBool) UnmarshalJSON(b []byte) error {
 
 func (v StringOrBool) String() string { return string(v) }
 
+// Query types understood by QueryData.
+const (
+	queryTypeAlerts         = "alerts"         // assurance issues
+	queryTypeIssueCount     = "issueCount"     // issue counts per priority
+	queryTypeIssueCountFast = "issueCountFast" // total issue count from the count endpoint
+	queryTypeIssueTrend     = "issueTrend"     // issue counts per status over time buckets
+	queryTypeClientHealth   = "clientHealth"   // client health scores by client type
+	queryTypeNetworkHealth  = "networkHealth"  // overall network health over time
+	queryTypeRaw            = "raw"            // assurance issues with their JSON fields as columns
+)
+
 // QueryModel represents the query structure sent from the frontend.
 // It includes all the filters and options available in the query editor.
 type QueryModel struct {
@@ -167,9 +623,94 @@ type QueryModel struct {
 	AIDriven    StringOrBool `json:"aiDriven,omitempty"`
 	Limit       *int64       `json:"limit,omitempty"`
 	RefID       string       `json:"refId,omitempty"`
+	// Category filters by issue category; several may be comma-joined.
+	Category string `json:"category,omitempty"`
+	// IssueID filters to a single issue.
+	IssueID string `json:"issueId,omitempty"`
+	// Rule filters by the rule behind the issue, i.e. the issue name the
+	// API reports (e.g. "ap_down"), sent as the "name" parameter.
+	Rule string `json:"rule,omitempty"`
+	// DeviceRole filters by the role of the issue's device (ACCESS,
+	// DISTRIBUTION, CORE, BORDER or AP); several may be comma-joined.
+	DeviceRole string `json:"deviceRole,omitempty"`
+	// DeviceReachability filters by device reachability (Reachable,
+	// Unreachable or Ping Reachable); several may be comma-joined.
+	DeviceReachability string `json:"deviceReachability,omitempty"`
+	// SortBy asks the API to sort issues by an attribute (startTime, endTime,
+	// mostRecentOccurredTime, priority, status, category or name), and
+	// SortOrder picks "asc" or "desc". Sorting upstream keeps the row limit
+	// meaningful, e.g. for "most recent first" tables.
+	SortBy    string `json:"sortBy,omitempty"`
+	SortOrder string `json:"sortOrder,omitempty"`
+	// Sites adds site IDs to the SiteID filter. Both may hold several
+	// comma-joined IDs, as multi-value template variables expand to.
+	Sites []string `json:"sites,omitempty"`
 	// Enrich, when true, tells the backend to perform additional API calls
-	// to enrich the data, for example, by resolving site IDs to site names.
+	// to enrich the data, for example, by resolving site IDs to site names
+	// and device IDs to hostnames.
 	Enrich bool `json:"enrich,omitempty"`
+	// Driver marks this query as the driver of its request: it runs first and
+	// its device IDs become the scope for siblings with ScopeToDriver set.
+	Driver bool `json:"driver,omitempty"`
+	// ScopeToDriver keeps only issues for devices seen by the driver query.
+	ScopeToDriver bool `json:"scopeToDriver,omitempty"`
+	// MinAgeSeconds hides issues younger than this many seconds, measured
+	// against the end of the time range. Zero disables the filter.
+	MinAgeSeconds int `json:"minAgeSeconds,omitempty"`
+	// InclusiveEnd sends the end of the time range 1ms later, so issues
+	// stamped exactly at the end are included; by default the API may treat
+	// the end as exclusive and drop them.
+	InclusiveEnd bool `json:"inclusiveEnd,omitempty"`
+	// ResolveDeviceIP adds a "Device IP" column with each device's management
+	// IP. It is independent of Enrich and only fetches the IP attribute.
+	ResolveDeviceIP bool `json:"resolveDeviceIP,omitempty"`
+	// TitleTemplate is a Go text/template rendered per issue into a
+	// "Display Title" column, e.g. "[{{.Priority}}] {{.Title}} @ {{.Site}}".
+	// Fields: ID, Title, Priority, Status, Category, Device, DeviceIP, MAC,
+	// Site, SitePath, Rule, Details.
+	TitleTemplate string `json:"titleTemplate,omitempty"`
+	// Distinct, when set, names a field (site, device, category, rule, ...)
+	// and turns the result into a single sorted column of its distinct
+	// non-empty values, for template variables.
+	Distinct string `json:"distinct,omitempty"`
+	// TimeField picks the issue timestamp that drives the Time column, e.g.
+	// "lastOccurredTime". Issues without it fall back to the default chain.
+	// Unknown keys are ignored with a frame notice.
+	TimeField string `json:"timeField,omitempty"`
+	// Fields selects the issue columns to return, by column name (e.g.
+	// "Title", "Priority", "Site Name"). Time is always included; empty means
+	// all columns. Unknown names are ignored with a frame notice.
+	Fields []string `json:"fields,omitempty"`
+	// IncludeRaw adds a hidden "Raw" column with each issue's original JSON,
+	// for transformations and data links, whatever Fields selects.
+	IncludeRaw bool `json:"includeRaw,omitempty"`
+	// SitePathSeparator joins the levels of the "Site Path" column added by
+	// Enrich. Defaults to " > ".
+	SitePathSeparator string `json:"sitePathSeparator,omitempty"`
+	// UseQueryBody fetches issues with POST .../assuranceIssues/query and a
+	// JSON filter body instead of URL parameters, for newer Catalyst Center
+	// releases. The filters are the same; only paging and sorting stay in
+	// the URL.
+	UseQueryBody bool `json:"useQueryBody,omitempty"`
+	// FrameName names the query's frame instead of its refID, so queries
+	// joined in one panel can be told apart. Empty keeps the refID.
+	FrameName string `json:"frameName,omitempty"`
+	// BucketSeconds is the bucket width of issueTrend queries. Defaults to
+	// 3600; widened when the time range would need over 1000 buckets.
+	BucketSeconds int `json:"bucketSeconds,omitempty"`
+	// ClientType scopes clientHealth queries to one client type: WIRED,
+	// WIRELESS or ALL (the API's row combining both). Empty keeps a row per
+	// client type.
+	ClientType string `json:"clientType,omitempty"`
+	// HealthMetrics adds a field per listed bucket key (e.g. goodCount) to
+	// networkHealth queries, labelled with a friendly display name and unit
+	// when the key is known. See healthMetrics.
+	HealthMetrics []string `json:"healthMetrics,omitempty"`
+	// Stream turns an alerts query into a live table: its frame carries a
+	// Grafana Live channel on which issues that appear later are pushed,
+	// polled every StreamIntervalSeconds (default 30, at least 10).
+	Stream                bool `json:"stream,omitempty"`
+	StreamIntervalSeconds int  `json:"streamIntervalSeconds,omitempty"`
 
 	// Optional aliases for backward-compatibility in the parameter builder.
 	// The frontend normalizes to the fields above.
@@ -177,16 +718,83 @@ type QueryModel struct {
 	Status   string `json:"status,omitempty"`
 }
 
+// mergeDefaultQuery overlays the query JSON on the instance default query and
+// returns the merged JSON. Query fields that are absent, null, empty strings or
+// empty arrays don't count as set, so the default applies to them.
+func mergeDefaultQuery(defaults, query json.RawMessage) (json.RawMessage, error) {
+	if len(defaults) == 0 {
+		return query, nil
+	}
+	merged := map[string]any{}
+	if err := json.Unmarshal(defaults, &merged); err != nil {
+		return nil, fmt.Errorf("invalid defaultQuery: %w", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(query, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		switch x := v.(type) {
+		case nil:
+			continue
+		case string:
+			if strings.TrimSpace(x) == "" {
+				continue
+			}
+		case []any:
+			if len(x) == 0 {
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	return json.Marshal(merged)
+}
+
 // tokenEntry represents a cached authentication token and its expiry time.
 type tokenEntry struct {
 	Token     string
-	ExpiresAt int64 // Unix epoch seconds
+	ExpiresAt int64  // Unix epoch seconds
+	Source    string // how ExpiresAt was derived, see expirySource*
+	CachedAt  int64  // Unix epoch seconds; 0 when unknown
 }
 
 // IssuesEnvelope is the expected structure of the main issues API response.
 // The actual issues are contained within the 'response' field.
 type IssuesEnvelope struct {
 	Response []map[string]any `json:"response"`
+	// TotalCount is the number of issues matching the filters, across all
+	// pages, when the API reports it.
+	TotalCount *int64 `json:"totalCount"`
+}
+
+// IssuesQueryBody is the JSON body of the POST issues query endpoint.
+type IssuesQueryBody struct {
+	StartTime int64         `json:"startTime,omitempty"`
+	EndTime   int64         `json:"endTime,omitempty"`
+	Filters   []IssueFilter `json:"filters,omitempty"`
+}
+
+// IssueFilter is one predicate of an IssuesQueryBody, e.g. priority "in"
+// ["P1","P2"] or status "eq" "active".
+type IssueFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    any    `json:"value"`
+}
+
+// CountEnvelope defines the structure of the issue count API response.
+type CountEnvelope struct {
+	Response int64 `json:"response"`
+}
+
+// ErrorEnvelope defines the structure of Catalyst Center error responses.
+type ErrorEnvelope struct {
+	Response struct {
+		ErrorCode string `json:"errorCode"`
+		Message   string `json:"message"`
+		Detail    string `json:"detail"`
+	} `json:"response"`
 }
 
 // SiteEnvelope defines the structure for the site API response.
@@ -198,4 +806,72 @@ type SiteEnvelope struct {
 type Site struct {
 	ID   string `json:"id"`
 	Name string `json:"siteName"`
+	// NameHierarchy is the slash-delimited path of the site, e.g.
+	// "Global/US/NYC/Floor 2".
+	NameHierarchy string `json:"siteNameHierarchy"`
+}
+
+// DeviceEnvelope defines the structure for the network device API response.
+type DeviceEnvelope struct {
+	Response []Device `json:"response"`
+}
+
+// Device holds the relevant fields from the network device API.
+type Device struct {
+	ID           string `json:"id"`
+	Hostname     string `json:"hostname"`
+	ManagementIP string `json:"managementIpAddress"`
+}
+
+// DeviceByIPEnvelope defines the structure for the network device by IP
+// address API response, which holds a single device.
+type DeviceByIPEnvelope struct {
+	Response Device `json:"response"`
+}
+
+// IssueDefinitionEnvelope defines the structure for the system issue
+// definitions API response.
+type IssueDefinitionEnvelope struct {
+	Response []IssueDefinition `json:"response"`
+}
+
+// IssueDefinition holds the relevant fields of an issue rule definition.
+// Name is the rule name issues carry, e.g. "ap_down".
+type IssueDefinition struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// NetworkHealthEnvelope defines the structure for the network health API
+// response: one entry per time bucket.
+type NetworkHealthEnvelope struct {
+	Response []map[string]any `json:"response"`
+}
+
+// ClientHealthEnvelope defines the structure for the client health API response.
+type ClientHealthEnvelope struct {
+	Response []ClientHealthSite `json:"response"`
+}
+
+// ClientHealthSite holds the client health scores of one site.
+type ClientHealthSite struct {
+	SiteID      string        `json:"siteId"`
+	ScoreDetail []ClientScore `json:"scoreDetail"`
+}
+
+// ClientScore is one score bucket of the client health API. Top-level
+// buckets are per client type (ALL, WIRED, WIRELESS); their ScoreList holds
+// the per-score-type buckets (POOR, FAIR, GOOD, ...).
+type ClientScore struct {
+	ScoreCategory ScoreCategory `json:"scoreCategory"`
+	ScoreValue    *float64      `json:"scoreValue"`  // nil when not reported
+	ClientCount   *int64        `json:"clientCount"` // nil when not reported
+	ScoreList     []ClientScore `json:"scoreList"`
+}
+
+// ScoreCategory labels a ClientScore bucket, e.g. CLIENT_TYPE/WIRED.
+type ScoreCategory struct {
+	ScoreCategory string `json:"scoreCategory"`
+	Value         string `json:"value"`
 }
@@ -1,6 +1,19 @@
 package backend
 
-import "testing"
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestIssuesURL_DataAssurance(t *testing.T) {
 	u, err := IssuesURL("https://example.local/dna/intent/api/v1")
@@ -24,3 +37,299 @@ func TestIssuesURL_PrefixPreserved(t *testing.T) {
 	}
 }
 
+func TestIssuesURL_BaseURLShapes(t *testing.T) {
+	tests := []struct{ base, want string }{
+		{"https://host", "https://host/dna/data/api/v1/assuranceIssues"},
+		{"https://host/", "https://host/dna/data/api/v1/assuranceIssues"},
+		{"https://host/catalyst", "https://host/catalyst/dna/data/api/v1/assuranceIssues"},
+		{"https://host/catalyst/", "https://host/catalyst/dna/data/api/v1/assuranceIssues"},
+		{"https://host/a/b/dna/intent/api/v1", "https://host/a/b/dna/data/api/v1/assuranceIssues"},
+	}
+	for _, tt := range tests {
+		got, err := IssuesURL(tt.base)
+		if err != nil {
+			t.Fatalf("IssuesURL(%q) error: %v", tt.base, err)
+		}
+		if got != tt.want {
+			t.Errorf("IssuesURL(%q) = %q, want %q", tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestParseInstanceSettings_HTTPTimeout(t *testing.T) {
+	tests := []struct {
+		jsonData string
+		want     time.Duration
+	}{
+		{`{}`, 30 * time.Second},
+		{`{"httpTimeoutSeconds":-5}`, 30 * time.Second},
+		{`{"httpTimeoutSeconds":120}`, 120 * time.Second},
+		{`{"httpTimeoutSeconds":100000}`, 300 * time.Second},
+	}
+	d := NewDatasource()
+	for _, tt := range tests {
+		s, err := ParseInstanceSettings([]byte(tt.jsonData), nil)
+		if err != nil {
+			t.Fatalf("ParseInstanceSettings(%s) error: %v", tt.jsonData, err)
+		}
+		if got := d.httpClientFor(s).Timeout; got != tt.want {
+			t.Errorf("client timeout for %s = %v, want %v", tt.jsonData, got, tt.want)
+		}
+	}
+}
+
+// testKeyPair returns a freshly generated self-signed certificate and key in PEM.
+func testKeyPair(t *testing.T) (certPEM, keyPEM string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "grafana"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM
+}
+
+func TestParseInstanceSettings_ClientCertificate(t *testing.T) {
+	certPEM, keyPEM := testKeyPair(t)
+
+	s, err := ParseInstanceSettings([]byte(`{}`), map[string]string{"clientCert": certPEM, "clientKey": keyPEM})
+	if err != nil {
+		t.Fatalf("ParseInstanceSettings error: %v", err)
+	}
+	tr := NewDatasource().httpClientFor(s).Transport.(*http.Transport)
+	if n := len(tr.TLSClientConfig.Certificates); n != 1 {
+		t.Fatalf("client certificates = %d, want 1", n)
+	}
+
+	if _, err := ParseInstanceSettings([]byte(`{}`), map[string]string{"clientCert": certPEM}); err == nil {
+		t.Fatal("expected error when only the certificate is set")
+	}
+	if _, err := ParseInstanceSettings([]byte(`{}`), map[string]string{"clientKey": keyPEM}); err == nil {
+		t.Fatal("expected error when only the key is set")
+	}
+}
+
+func TestTokenURL(t *testing.T) {
+	tests := []struct {
+		base, tokenPath, want string
+	}{
+		{"https://dnac.local", "", "https://dnac.local/dna/system/api/v1/auth/token"},
+		{"https://gw/proxy/dnac/dna", "", "https://gw/proxy/dnac/dna/system/api/v1/auth/token"},
+		{"https://dnac.local", "/auth/v2/token", "https://dnac.local/auth/v2/token"},
+		{"https://dnac.local", "auth/v2/token", "https://dnac.local/auth/v2/token"},
+		{"https://gw/proxy/dnac/dna/intent/api/v1", "/auth/v2/token", "https://gw/proxy/dnac/auth/v2/token"},
+	}
+	for _, tt := range tests {
+		got, err := TokenURL(tt.base, tt.tokenPath)
+		if err != nil {
+			t.Fatalf("TokenURL(%q,%q) error: %v", tt.base, tt.tokenPath, err)
+		}
+		if got != tt.want {
+			t.Errorf("TokenURL(%q,%q) = %q, want %q", tt.base, tt.tokenPath, got, tt.want)
+		}
+	}
+}
+
+func TestClientHealthURL(t *testing.T) {
+	tests := []struct{ base, want string }{
+		{"https://dnac.local", "https://dnac.local/dna/intent/api/v1/client-health"},
+		{"https://gw/proxy/dnac/dna/intent/api/v1?x=1", "https://gw/proxy/dnac/dna/intent/api/v1/client-health"},
+	}
+	for _, tt := range tests {
+		got, err := ClientHealthURL(tt.base)
+		if err != nil {
+			t.Fatalf("ClientHealthURL(%q) error: %v", tt.base, err)
+		}
+		if got != tt.want {
+			t.Errorf("ClientHealthURL(%q) = %q, want %q", tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestNetworkHealthURL(t *testing.T) {
+	got, err := NetworkHealthURL("https://gw/catalyst/dna/intent/api/v1")
+	if err != nil {
+		t.Fatalf("NetworkHealthURL error: %v", err)
+	}
+	if want := "https://gw/catalyst/dna/intent/api/v1/network-health"; got != want {
+		t.Fatalf("NetworkHealthURL = %q, want %q", got, want)
+	}
+}
+
+func TestMergeDefaultQuery(t *testing.T) {
+	defaults := json.RawMessage(`{"issueStatus":"ACTIVE","priority":["P1","P2"],"limit":50}`)
+	merged, err := mergeDefaultQuery(defaults, json.RawMessage(`{"queryType":"alerts","priority":["P3"],"siteId":"","issueStatus":null,"macAddress":"aa"}`))
+	if err != nil {
+		t.Fatalf("mergeDefaultQuery error: %v", err)
+	}
+	var qm QueryModel
+	if err := json.Unmarshal(merged, &qm); err != nil {
+		t.Fatalf("bad merged JSON: %v", err)
+	}
+	// Query values win; absent, null and empty ones fall back to the default.
+	if len(qm.Priority) != 1 || qm.Priority[0] != "P3" {
+		t.Errorf("priority = %v, want [P3]", qm.Priority)
+	}
+	if qm.IssueStatus != "ACTIVE" {
+		t.Errorf("issueStatus = %q, want ACTIVE", qm.IssueStatus)
+	}
+	if qm.Limit == nil || *qm.Limit != 50 {
+		t.Errorf("limit = %v, want 50", qm.Limit)
+	}
+	if qm.QueryType != "alerts" || qm.MacAddress != "aa" || qm.SiteID != "" {
+		t.Errorf("unexpected merge result: %+v", qm)
+	}
+
+	// Without defaults the query is passed through untouched.
+	raw := json.RawMessage(`{"queryType":"alerts"}`)
+	if got, _ := mergeDefaultQuery(nil, raw); string(got) != string(raw) {
+		t.Errorf("no defaults = %s, want %s", got, raw)
+	}
+}
+
+func TestParseInstanceSettings_DefaultQuery(t *testing.T) {
+	s, err := ParseInstanceSettings([]byte(`{"defaultQuery":{"issueStatus":"ACTIVE"}}`), nil)
+	if err != nil || string(s.DefaultQuery) != `{"issueStatus":"ACTIVE"}` {
+		t.Fatalf("defaultQuery = (%s, %v)", s.DefaultQuery, err)
+	}
+	if _, err := ParseInstanceSettings([]byte(`{"defaultQuery":["P1"]}`), nil); err == nil {
+		t.Fatal("expected error for a non-object defaultQuery")
+	}
+}
+
+func TestLinkBaseURL(t *testing.T) {
+	tests := []struct{ base, want string }{
+		{"https://dnac.local", "https://dnac.local"},
+		{"https://gw/proxy/dnac/dna/intent/api/v1?x=1", "https://gw/proxy/dnac"},
+		{"https://gw/catalyst/", "https://gw/catalyst"},
+	}
+	for _, tt := range tests {
+		if got, err := LinkBaseURL(tt.base); err != nil || got != tt.want {
+			t.Errorf("LinkBaseURL(%q) = (%q, %v), want %q", tt.base, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseInstanceSettings_BaseURLValidation(t *testing.T) {
+	tests := []struct {
+		base    string
+		wantErr string
+	}{
+		{"catalyst.example.com", "http:// or https://"},
+		{"ftp://catalyst.example.com", "http:// or https://"},
+		{"https://", "missing host"},
+		{"https:///dna", "missing host"},
+		{"https://catalyst.example.com/", ""},
+		{"http://10.0.0.5:8080/catalyst", ""},
+	}
+	for _, tt := range tests {
+		s, err := ParseInstanceSettings([]byte(`{"baseUrl":"`+tt.base+`"}`), nil)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", tt.base, err)
+			} else if s.BaseURL != strings.TrimRight(tt.base, "/") {
+				t.Errorf("%q: BaseURL = %q", tt.base, s.BaseURL)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: error = %v, want %q", tt.base, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHTTPClientFor_Proxy(t *testing.T) {
+	d := NewDatasource()
+	req, _ := http.NewRequest(http.MethodGet, "https://catalyst.example.com/dna/intent/api/v1/site", nil)
+
+	s, err := ParseInstanceSettings([]byte(`{"proxyUrl":"http://proxy.corp:3128"}`), nil)
+	if err != nil {
+		t.Fatalf("ParseInstanceSettings error: %v", err)
+	}
+	tr := d.httpClientFor(s).Transport.(*http.Transport)
+	got, err := tr.Proxy(req)
+	if err != nil || got == nil || got.String() != "http://proxy.corp:3128" {
+		t.Fatalf("Proxy = (%v, %v), want the configured proxy", got, err)
+	}
+
+	// Without a proxyUrl the environment decides.
+	s, _ = ParseInstanceSettings([]byte(`{}`), nil)
+	if tr := d.httpClientFor(s).Transport.(*http.Transport); tr.Proxy == nil {
+		t.Fatal("Proxy is nil, want http.ProxyFromEnvironment")
+	}
+
+	if _, err := ParseInstanceSettings([]byte(`{"proxyUrl":"proxy.corp:3128"}`), nil); err == nil {
+		t.Fatal("expected error for proxyUrl without scheme")
+	}
+}
+
+func TestParseInstanceSettings_PageDefaults(t *testing.T) {
+	s, err := ParseInstanceSettings([]byte(`{}`), nil)
+	if err != nil || s.DefaultPageSize != 100 || s.DefaultLimit != 100 {
+		t.Fatalf("defaults = (%d, %d, %v), want (100, 100)", s.DefaultPageSize, s.DefaultLimit, err)
+	}
+	s, _ = ParseInstanceSettings([]byte(`{"defaultPageSize":5000,"defaultLimit":250}`), nil)
+	if s.DefaultPageSize != 1000 || s.DefaultLimit != 250 {
+		t.Fatalf("configured = (%d, %d), want (1000, 250)", s.DefaultPageSize, s.DefaultLimit)
+	}
+}
+
+func TestParseInstanceSettings_AuthHeaderFormat(t *testing.T) {
+	for _, f := range []string{"Bearer", "%s %s", "Bearer %d %s"} {
+		_, err := ParseInstanceSettings([]byte(`{"baseUrl":"https://dnac","authHeaderFormat":"`+f+`"}`), map[string]string{"apiToken": "tok"})
+		if err == nil {
+			t.Fatalf("format %q: expected error", f)
+		}
+	}
+}
+
+func TestIssuesCountURL_PrefixPreserved(t *testing.T) {
+	u, err := IssuesCountURL("https://gw/proxy/dnac/dna/intent/api/v1")
+	if err != nil {
+		t.Fatalf("IssuesCountURL error: %v", err)
+	}
+	want := "https://gw/proxy/dnac/dna/data/api/v1/assuranceIssues/count"
+	if u != want {
+		t.Fatalf("IssuesCountURL = %q, want %q", u, want)
+	}
+}
+
+func TestHTTPClientFor_ProxyAuth(t *testing.T) {
+	d := NewDatasource()
+	req, _ := http.NewRequest(http.MethodGet, "https://catalyst.example.com/dna/intent/api/v1/site", nil)
+
+	s, err := ParseInstanceSettings([]byte(`{"proxyUrl":"http://proxy.corp:3128"}`),
+		map[string]string{"proxyUsername": "svc", "proxyPassword": "p@ss"})
+	if err != nil {
+		t.Fatalf("ParseInstanceSettings error: %v", err)
+	}
+	got, err := d.httpClientFor(s).Transport.(*http.Transport).Proxy(req)
+	if err != nil || got == nil {
+		t.Fatalf("Proxy = (%v, %v), want the configured proxy", got, err)
+	}
+	if pw, _ := got.User.Password(); got.User.Username() != "svc" || pw != "p@ss" || got.Host != "proxy.corp:3128" {
+		t.Fatalf("Proxy = %s, want svc:p@ss@proxy.corp:3128", got.Redacted())
+	}
+	if s.Proxy.User != nil || strings.Contains(s.clientKey(), "p@ss") {
+		t.Fatal("proxy credentials leaked into the settings' proxy URL or client key")
+	}
+
+	if _, err := ParseInstanceSettings([]byte(`{}`), map[string]string{"proxyPassword": "p@ss"}); err == nil {
+		t.Fatal("expected error for a proxy password without a username")
+	}
+}
@@ -6,6 +6,8 @@ package backend
 
 import (
 	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -16,8 +18,87 @@ var (
 	allowedPriority = map[string]struct{}{"P1": {}, "P2": {}, "P3": {}, "P4": {}}
 	// allowedIssueStatus defines the valid status values for the API.
 	allowedIssueStatus = map[string]struct{}{"ACTIVE": {}, "RESOLVED": {}, "IGNORED": {}}
+	// issueStatusParam is how each status is spelled in the status filter.
+	// The API takes active and resolved lowercased, but some releases reject
+	// a lowercase "ignored", while all of them accept "IGNORED".
+	issueStatusParam = map[string]string{"ACTIVE": "active", "RESOLVED": "resolved", "IGNORED": "IGNORED"}
+	// allowedSortBy defines the issue attributes the API can sort by.
+	allowedSortBy = map[string]struct{}{
+		"startTime": {}, "endTime": {}, "mostRecentOccurredTime": {},
+		"priority": {}, "status": {}, "category": {}, "name": {},
+	}
+	// allowedDeviceRole defines the device roles the deviceRole filter accepts.
+	allowedDeviceRole = map[string]struct{}{
+		"ACCESS": {}, "DISTRIBUTION": {}, "CORE": {}, "BORDER": {}, "AP": {},
+	}
+	// allowedClientType defines the client types clientHealth queries can be
+	// scoped to; ALL is the API's bucket combining wired and wireless.
+	allowedClientType = map[string]struct{}{"ALL": {}, "WIRED": {}, "WIRELESS": {}}
+	// deviceReachabilityParam maps the lowercased reachability filter values
+	// to their spelling in the API.
+	deviceReachabilityParam = map[string]string{
+		"reachable": "Reachable", "unreachable": "Unreachable", "ping reachable": "Ping Reachable",
+	}
 )
 
+// filterOptions lists the filter values buildAssuranceParamsFromQuery
+// and the clientHealth query accept, so the query editor can offer them instead of free text that
+// would be dropped. Values are sorted.
+type filterOptions struct {
+	Priority           []string `json:"priority"`
+	IssueStatus        []string `json:"issueStatus"`
+	DeviceRole         []string `json:"deviceRole"`
+	DeviceReachability []string `json:"deviceReachability"`
+	SortBy             []string `json:"sortBy"`
+	SortOrder          []string `json:"sortOrder"`
+	ClientType         []string `json:"clientType"`
+}
+
+// knownFilterOptions returns the allowed value sets above as filterOptions.
+func knownFilterOptions() filterOptions {
+	reachability := make([]string, 0, len(deviceReachabilityParam))
+	for _, v := range deviceReachabilityParam {
+		reachability = append(reachability, v)
+	}
+	sort.Strings(reachability)
+	return filterOptions{
+		Priority:           sortedKeys(allowedPriority),
+		IssueStatus:        sortedKeys(allowedIssueStatus),
+		DeviceRole:         sortedKeys(allowedDeviceRole),
+		DeviceReachability: reachability,
+		SortBy:             sortedKeys(allowedSortBy),
+		SortOrder:          []string{"asc", "desc"},
+		ClientType:         sortedKeys(allowedClientType),
+	}
+}
+
+// sortedKeys returns the members of set in sorted order.
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// unresolvedVariable matches a dashboard variable reference left in a filter
+// value when interpolation misfired: $site, ${site}, ${site:csv} or [[site]].
+var unresolvedVariable = regexp.MustCompile(`\$(\{[^}]*\}|[A-Za-z_]\w*)|\[\[\w+\]\]`)
+
+// hasUnresolvedVariable reports whether v still holds a variable reference.
+func hasUnresolvedVariable(v string) bool {
+	return unresolvedVariable.MatchString(v)
+}
+
+// isAllValue reports whether a priority or status filter value is "*" or
+// "ALL" (any case), the custom "All" value of a template variable, which
+// selects every allowed value.
+func isAllValue(v string) bool {
+	v = strings.TrimSpace(v)
+	return v == "*" || strings.EqualFold(v, "ALL")
+}
+
 // normalizePriority returns a valid priority string (P1-P4) if the input
 // matches a known value. It checks both 'priority' and the legacy 'severity' fields.
 func normalizePriority(priority, severity string) (string, bool) {
@@ -46,6 +127,44 @@ func normalizeIssueStatus(issueStatus, status string) (string, bool) {
 	return "", false
 }
 
+// normalizeDeviceRole returns the uppercased role if it is an allowed device role.
+func normalizeDeviceRole(role string) (string, bool) {
+	r := strings.ToUpper(strings.TrimSpace(role))
+	_, ok := allowedDeviceRole[r]
+	return r, ok
+}
+
+// normalizeClientType returns the uppercased client type if it is allowed.
+func normalizeClientType(clientType string) (string, bool) {
+	c := strings.ToUpper(strings.TrimSpace(clientType))
+	_, ok := allowedClientType[c]
+	return c, ok
+}
+
+// normalizeDeviceReachability returns the API spelling of a case-insensitive
+// reachability value (Reachable, Unreachable or Ping Reachable).
+func normalizeDeviceReachability(reachability string) (string, bool) {
+	r, ok := deviceReachabilityParam[strings.ToLower(strings.TrimSpace(reachability))]
+	return r, ok
+}
+
+// normalizeSortBy returns sortBy if it is an attribute the API can sort by.
+func normalizeSortBy(sortBy string) (string, bool) {
+	s := strings.TrimSpace(sortBy)
+	_, ok := allowedSortBy[s]
+	return s, ok
+}
+
+// normalizeSortOrder returns "asc" or "desc" for a case-insensitive match.
+func normalizeSortOrder(order string) (string, bool) {
+	switch o := strings.ToLower(strings.TrimSpace(order)); o {
+	case "asc", "desc":
+		return o, true
+	default:
+		return "", false
+	}
+}
+
 // normalizeBoolish converts various string representations of a boolean
 // (e.g., "true", "yes", "1") into a canonical "true" or "false" string.
 func normalizeBoolish(s string) (string, bool) {
@@ -75,6 +194,31 @@ func clampLimit(n, def, min, max int) int {
 	return n
 }
 
+// splitMultiValue flattens values that may each hold several comma-joined
+// entries, as multi-value template variables expand to ("a,b" or "{a,b}").
+// Entries are trimmed; empty and repeated ones are dropped.
+func splitMultiValue(values ...string) []string {
+	seen := make(map[string]struct{})
+	var out []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "{") && strings.HasSuffix(v, "}") {
+			v = v[1 : len(v)-1]
+		}
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if _, dup := seen[part]; !dup {
+				seen[part] = struct{}{}
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
 // buildAssuranceParamsFromQuery converts a QueryModel from the frontend into a
 // url.Values map suitable for encoding as URL query parameters.
 // It performs the following key operations:
@@ -82,8 +226,19 @@ func clampLimit(n, def, min, max int) int {
 // - Adds time range filters ('startTime', 'endTime') if provided.
 // - Adds normalized and validated filters for site, device, status, etc.
 // - Skips any empty or invalid filter values to create a clean API request.
-func buildAssuranceParamsFromQuery(q QueryModel, startTime, endTime int64, pageSize, offset int) url.Values {
+//
+// Invalid priority, status, device role, reachability and sort values, and
+// filter values with an unresolved variable such as "$site", are returned as
+// rejected, e.g. `priority "P9"`, so callers can tell the user they were
+// ignored.
+func buildAssuranceParamsFromQuery(q QueryModel, startTime, endTime int64, pageSize, offset int) (url.Values, []string) {
 	v := url.Values{}
+	var rejected []string
+	reject := func(name, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			rejected = append(rejected, name+" "+strconv.Quote(value))
+		}
+	}
 
 	// Paging (one-based offset agreed)
 	v.Set("limit", strconv.Itoa(clampLimit(pageSize, 100, 1, 1000)))
@@ -97,35 +252,124 @@ func buildAssuranceParamsFromQuery(q QueryModel, startTime, endTime int64, pageS
 		v.Set("startTime", strconv.FormatInt(startTime, 10))
 	}
 	if endTime > 0 {
-		v.Set("endTime", strconv.FormatInt(endTime, 10))
+		v.Set("endTime", strconv.FormatInt(inclusiveEndTime(q, endTime), 10))
+	}
+
+	// Values still holding a variable reference such as "$site" were not
+	// interpolated; they are rejected rather than sent, which the API would
+	// answer with a 400.
+	resolved := func(name string, values []string) []string {
+		var out []string
+		for _, val := range values {
+			if hasUnresolvedVariable(val) {
+				reject(name, val)
+				continue
+			}
+			out = append(out, val)
+		}
+		return out
+	}
+	single := func(param, name, value string) {
+		if s := resolved(name, []string{strings.TrimSpace(value)}); len(s) > 0 && s[0] != "" {
+			v.Set(param, s[0])
+		}
 	}
 
 	// Filters (skip empties)
-	if s := strings.TrimSpace(q.SiteID); s != "" {
-		v.Set("siteId", s)
+	// Sites may be multi-valued, e.g. from a multi-select "$site" variable;
+	// the API takes them comma-separated.
+	if sites := resolved("siteId", splitMultiValue(append([]string{q.SiteID}, q.Sites...)...)); len(sites) > 0 {
+		v.Set("siteId", strings.Join(sites, ","))
 	}
-	if s := strings.TrimSpace(q.DeviceID); s != "" {
-		v.Set("deviceId", s)
+	single("deviceId", "deviceId", q.DeviceID)
+	single("macAddress", "macAddress", q.MacAddress)
+	// The assurance issues endpoint filters by "category" (e.g. Onboarding,
+	// Connectivity), "issueId" and "name", the rule-derived issue name such
+	// as "ap_down"; it has no ruleId parameter.
+	if cats := resolved("category", splitMultiValue(q.Category)); len(cats) > 0 {
+		v.Set("category", strings.Join(cats, ","))
 	}
-	if s := strings.TrimSpace(q.MacAddress); s != "" {
-		v.Set("macAddress", s)
+	single("issueId", "issueId", q.IssueID)
+	single("name", "rule", q.Rule)
+
+	// Device role and reachability may be multi-valued; unknown values are
+	// rejected, the rest sent comma-separated.
+	var roles, reachability []string
+	for _, r := range splitMultiValue(q.DeviceRole) {
+		if norm, ok := normalizeDeviceRole(r); ok {
+			roles = append(roles, norm)
+		} else {
+			reject("deviceRole", r)
+		}
+	}
+	if len(roles) > 0 {
+		v.Set("deviceRole", strings.Join(roles, ","))
+	}
+	for _, r := range splitMultiValue(q.DeviceReachability) {
+		if norm, ok := normalizeDeviceReachability(r); ok {
+			reachability = append(reachability, norm)
+		} else {
+			reject("deviceReachability", r)
+		}
+	}
+	if len(reachability) > 0 {
+		v.Set("deviceReachability", strings.Join(reachability, ","))
 	}
 
-	// Handle Priority: The API expects a comma-separated string.
+	// Handle Priority: The API expects a comma-separated string. An "all"
+	// value selects every priority.
 	if len(q.Priority) > 0 {
 		var validPriorities []string
 		for _, p := range q.Priority {
+			if isAllValue(p) {
+				validPriorities = sortedKeys(allowedPriority)
+				break
+			}
 			if norm, ok := normalizePriority(p, ""); ok {
 				validPriorities = append(validPriorities, norm)
+			} else {
+				reject("priority", p)
 			}
 		}
 		if len(validPriorities) > 0 {
 			v.Set("priority", strings.Join(validPriorities, ","))
 		}
+	} else if p, ok := normalizePriority("", q.Severity); ok {
+		// Legacy single-value alias.
+		v.Set("priority", p)
+	} else {
+		reject("severity", q.Severity)
+	}
+
+	if isAllValue(q.IssueStatus) || (strings.TrimSpace(q.IssueStatus) == "" && isAllValue(q.Status)) {
+		var all []string
+		for _, st := range sortedKeys(allowedIssueStatus) {
+			all = append(all, issueStatusParam[st])
+		}
+		v.Set("status", strings.Join(all, ","))
+	} else if st, ok := normalizeIssueStatus(q.IssueStatus, q.Status); ok {
+		v.Set("status", issueStatusParam[st])
+	}
+	if _, ok := normalizeIssueStatus(q.IssueStatus, ""); !ok && !isAllValue(q.IssueStatus) {
+		reject("issueStatus", q.IssueStatus)
+	}
+	if _, ok := normalizeIssueStatus("", q.Status); !ok && !isAllValue(q.Status) {
+		reject("status", q.Status)
 	}
 
-	if st, ok := normalizeIssueStatus(q.IssueStatus, q.Status); ok {
-		v.Set("status", strings.ToLower(st))
+	// Sorting: only an allowed sortBy is sent, with order asc or desc when
+	// given. Anything else, including an order without a sortBy, is rejected,
+	// leaving the API's default order.
+	by, byOK := normalizeSortBy(q.SortBy)
+	if byOK {
+		v.Set("sortBy", by)
+	} else {
+		reject("sortBy", q.SortBy)
+	}
+	if order, ok := normalizeSortOrder(q.SortOrder); ok && byOK {
+		v.Set("order", order)
+	} else {
+		reject("sortOrder", q.SortOrder)
 	}
 
 	// AIDriven is a custom StringOrBool type (backward-compatible)
@@ -133,5 +377,142 @@ func buildAssuranceParamsFromQuery(q QueryModel, startTime, endTime int64, pageS
 		v.Set("aiDriven", b)
 	}
 
+	return v, rejected
+}
+
+// queryBodyPagingParams are the parameters that stay in the URL when issues
+// are fetched with a query body; see buildAssuranceQueryBody.
+var queryBodyPagingParams = []string{"limit", "offset", "sortBy", "order"}
+
+// buildAssuranceCountParams returns the filters of
+// buildAssuranceParamsFromQuery without paging and sorting, for the issue
+// count endpoint.
+func buildAssuranceCountParams(q QueryModel, startTime, endTime int64) url.Values {
+	v, _ := buildAssuranceParamsFromQuery(q, startTime, endTime, 0, 0)
+	for _, k := range queryBodyPagingParams {
+		v.Del(k)
+	}
 	return v
 }
+
+// inclusiveEndTime returns the endTime sent for q: one millisecond past the
+// end of the time range with InclusiveEnd, so an issue stamped exactly at the
+// end is within it however the API compares; endTime unchanged otherwise.
+func inclusiveEndTime(q QueryModel, endTime int64) int64 {
+	if q.InclusiveEnd && endTime > 0 {
+		return endTime + 1
+	}
+	return endTime
+}
+
+// buildAssuranceQueryBody converts a QueryModel into the JSON filter body of
+// the POST issues query endpoint. The filters are the normalized values of
+// buildAssuranceParamsFromQuery: multi-valued ones become an "in" filter and
+// the rest an "eq" filter, in key order.
+func buildAssuranceQueryBody(q QueryModel, startTime, endTime int64) IssuesQueryBody {
+	params, _ := buildAssuranceParamsFromQuery(q, 0, 0, 0, 0)
+	for _, k := range queryBodyPagingParams {
+		params.Del(k)
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	body := IssuesQueryBody{StartTime: startTime, EndTime: inclusiveEndTime(q, endTime)}
+	for _, k := range keys {
+		vals := strings.Split(params.Get(k), ",")
+		if len(vals) > 1 {
+			body.Filters = append(body.Filters, IssueFilter{Key: k, Operator: "in", Value: vals})
+		} else {
+			body.Filters = append(body.Filters, IssueFilter{Key: k, Operator: "eq", Value: vals[0]})
+		}
+	}
+	return body
+}
+
+// normalizeResourceFilters applies the filter normalization of panel queries
+// (see buildAssuranceParamsFromQuery) to the query parameters of an issues
+// resource call, so variable queries and panels agree: priority, status
+// (or its alias issueStatus), deviceRole and deviceReachability values,
+// repeated or comma-separated, are normalized, invalid ones dropped, and the
+// rest sent comma-separated, e.g. "priority=p2&priority=P1" becomes
+// "priority=P2,P1". Other parameters are passed through untouched.
+func normalizeResourceFilters(in url.Values) url.Values {
+	out := url.Values{}
+	for k, vals := range in {
+		out[k] = vals
+	}
+	normalize := func(key string, values []string, norm func(string) (string, bool)) {
+		out.Del(key)
+		var valid []string
+		seen := make(map[string]struct{})
+		for _, v := range splitMultiValue(values...) {
+			if n, ok := norm(v); ok {
+				if _, dup := seen[n]; !dup {
+					seen[n] = struct{}{}
+					valid = append(valid, n)
+				}
+			}
+		}
+		if len(valid) > 0 {
+			out.Set(key, strings.Join(valid, ","))
+		}
+	}
+
+	if in.Has("priority") {
+		normalize("priority", in["priority"], func(p string) (string, bool) { return normalizePriority(p, "") })
+	}
+	if in.Has("status") || in.Has("issueStatus") {
+		statuses := append(append([]string{}, in["status"]...), in["issueStatus"]...)
+		out.Del("issueStatus")
+		normalize("status", statuses, func(st string) (string, bool) {
+			norm, ok := normalizeIssueStatus(st, "")
+			return issueStatusParam[norm], ok
+		})
+	}
+	if in.Has("deviceRole") {
+		normalize("deviceRole", in["deviceRole"], normalizeDeviceRole)
+	}
+	if in.Has("deviceReachability") {
+		normalize("deviceReachability", in["deviceReachability"], normalizeDeviceReachability)
+	}
+	return out
+}
+
+// normalizeResourcePaging translates the frontend's page/pageSize parameters
+// (page is one-based) to the API's limit and one-based offset, the way
+// buildAssuranceParamsFromQuery pages panel queries: page=2&pageSize=10
+// becomes offset=11&limit=10. Without them, an explicit limit and offset are
+// passed through, clamped to the same bounds. Other parameters are kept.
+func normalizeResourcePaging(in url.Values, defaultPageSize int) url.Values {
+	out := url.Values{}
+	for k, vals := range in {
+		out[k] = vals
+	}
+	atoi := func(k string) int {
+		n, _ := strconv.Atoi(strings.TrimSpace(in.Get(k)))
+		return n
+	}
+
+	if in.Has("page") || in.Has("pageSize") {
+		pageSize := clampLimit(atoi("pageSize"), defaultPageSize, 1, 1000)
+		page := atoi("page")
+		if page < 1 {
+			page = 1
+		}
+		out.Del("page")
+		out.Del("pageSize")
+		out.Set("limit", strconv.Itoa(pageSize))
+		out.Set("offset", strconv.Itoa((page-1)*pageSize+1))
+		return out
+	}
+	if in.Has("limit") {
+		out.Set("limit", strconv.Itoa(clampLimit(atoi("limit"), defaultPageSize, 1, 1000)))
+	}
+	if in.Has("offset") && atoi("offset") < 1 {
+		out.Set("offset", "1")
+	}
+	return out
+}
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"net/url"
+	"reflect"
 	"testing"
 )
 
@@ -76,7 +77,7 @@ func TestBuildAssuranceParamsFromQuery(t *testing.T) {
 		SiteID:      "site-123",
 		DeviceID:    "dev-456",
 		MacAddress:  "00:11:22:33:44:55",
-		Priority:    "p2",
+		Priority:    []string{"p2"},
 		IssueStatus: "resolved",
 		AIDriven:    StringOrBool("YES"),
 		RefID:       "A",
@@ -84,7 +85,7 @@ func TestBuildAssuranceParamsFromQuery(t *testing.T) {
 		Status:      "",
 	}
 
-	params := buildAssuranceParamsFromQuery(q, 1700000000000, 1700003600000, 100, 1)
+	params, _ := buildAssuranceParamsFromQuery(q, 1700000000000, 1700003600000, 100, 1)
 
 	want := url.Values{
 		"siteId":     []string{"site-123"},
@@ -109,7 +110,7 @@ func TestBuildAssuranceParams_SkipEmpties(t *testing.T) {
 		Severity: "P3", // legacy alias only
 	}
 
-	params := buildAssuranceParamsFromQuery(q, 0, 0, -5, 0) // bad page/offset should be clamped/fixed
+	params, _ := buildAssuranceParamsFromQuery(q, 0, 0, -5, 0) // bad page/offset should be clamped/fixed
 	if _, ok := params["priority"]; !ok {
 		t.Fatal("expected priority from severity")
 	}
@@ -129,4 +130,235 @@ func TestBuildAssuranceParams_SkipEmpties(t *testing.T) {
 	if _, ok := params["endTime"]; ok {
 		t.Fatal("endTime should be omitted")
 	}
-}
\ No newline at end of file
+}
+func TestBuildAssuranceParams_MultiSite(t *testing.T) {
+	tests := []struct {
+		name  string
+		q     QueryModel
+		want  string
+		unset bool
+	}{
+		{"single", QueryModel{SiteID: " site-1 "}, "site-1", false},
+		{"comma joined", QueryModel{SiteID: "site-1, site-2,,"}, "site-1,site-2", false},
+		{"braced variable", QueryModel{SiteID: "{site-1,site-2}"}, "site-1,site-2", false},
+		{"sites list", QueryModel{SiteID: "site-1", Sites: []string{"site-2", "", "site-1"}}, "site-1,site-2", false},
+		{"empty", QueryModel{SiteID: " , ", Sites: []string{""}}, "", true},
+	}
+	for _, tt := range tests {
+		params, _ := buildAssuranceParamsFromQuery(tt.q, 0, 0, 10, 1)
+		if _, ok := params["siteId"]; ok == tt.unset {
+			t.Errorf("%s: siteId present = %v, want %v", tt.name, ok, !tt.unset)
+			continue
+		}
+		if got := params.Get("siteId"); got != tt.want {
+			t.Errorf("%s: siteId = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildAssuranceParams_CategoryIssueRule(t *testing.T) {
+	q := QueryModel{Category: " Onboarding, Connectivity ", IssueID: " iss-1 ", Rule: " ap_down "}
+	params, _ := buildAssuranceParamsFromQuery(q, 0, 0, 10, 1)
+	if got := params.Get("category"); got != "Onboarding,Connectivity" {
+		t.Errorf("category = %q, want Onboarding,Connectivity", got)
+	}
+	if got := params.Get("issueId"); got != "iss-1" {
+		t.Errorf("issueId = %q, want iss-1", got)
+	}
+	if got := params.Get("name"); got != "ap_down" {
+		t.Errorf("name = %q, want ap_down", got)
+	}
+
+	params, _ = buildAssuranceParamsFromQuery(QueryModel{Category: " ", IssueID: "", Rule: " "}, 0, 0, 10, 1)
+	for _, k := range []string{"category", "issueId", "name"} {
+		if _, ok := params[k]; ok {
+			t.Errorf("%s should be omitted when empty", k)
+		}
+	}
+}
+
+func TestBuildAssuranceParams_Sort(t *testing.T) {
+	tests := []struct {
+		sortBy, order     string
+		wantBy, wantOrder string
+		wantRejected      []string
+	}{
+		{"startTime", "DESC", "startTime", "desc", nil},
+		{"priority", "", "priority", "", nil},
+		{"startTime", "newest", "startTime", "", []string{`sortOrder "newest"`}}, // invalid order rejected
+		{"bogus", "asc", "", "", []string{`sortBy "bogus"`, `sortOrder "asc"`}},  // invalid field rejected
+		{"", "asc", "", "", []string{`sortOrder "asc"`}},                         // order without a field rejected
+		{"", "descending", "", "", []string{`sortOrder "descending"`}},           // invalid order without a field rejected
+		{"", "", "", "", nil},
+	}
+	for _, tt := range tests {
+		params, rejected := buildAssuranceParamsFromQuery(QueryModel{SortBy: tt.sortBy, SortOrder: tt.order}, 0, 0, 10, 1)
+		if got := params.Get("sortBy"); got != tt.wantBy {
+			t.Errorf("(%q,%q) sortBy = %q, want %q", tt.sortBy, tt.order, got, tt.wantBy)
+		}
+		if got := params.Get("order"); got != tt.wantOrder {
+			t.Errorf("(%q,%q) order = %q, want %q", tt.sortBy, tt.order, got, tt.wantOrder)
+		}
+		if !reflect.DeepEqual(rejected, tt.wantRejected) {
+			t.Errorf("(%q,%q) rejected = %v, want %v", tt.sortBy, tt.order, rejected, tt.wantRejected)
+		}
+	}
+}
+
+func TestBuildAssuranceParams_StatusCasing(t *testing.T) {
+	tests := map[string]string{"active": "active", "RESOLVED": "resolved", "ignored": "IGNORED"}
+	for in, want := range tests {
+		params, _ := buildAssuranceParamsFromQuery(QueryModel{IssueStatus: in}, 0, 0, 10, 1)
+		if got := params.Get("status"); got != want {
+			t.Errorf("issueStatus %q: status = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBuildAssuranceParams_RejectedValues(t *testing.T) {
+	q := QueryModel{Priority: []string{"P1", "P9", " "}, IssueStatus: "CLOSED", Status: "active"}
+	params, rejected := buildAssuranceParamsFromQuery(q, 0, 0, 10, 1)
+	if params.Get("priority") != "P1" || params.Get("status") != "active" {
+		t.Fatalf("params = %v, want the valid values kept", params)
+	}
+	want := []string{`priority "P9"`, `issueStatus "CLOSED"`}
+	if !reflect.DeepEqual(rejected, want) {
+		t.Fatalf("rejected = %v, want %v", rejected, want)
+	}
+
+	if _, rejected := buildAssuranceParamsFromQuery(QueryModel{Severity: "urgent"}, 0, 0, 10, 1); len(rejected) != 1 {
+		t.Fatalf("severity rejected = %v, want one entry", rejected)
+	}
+	if _, rejected := buildAssuranceParamsFromQuery(QueryModel{}, 0, 0, 10, 1); rejected != nil {
+		t.Fatalf("empty query rejected = %v, want none", rejected)
+	}
+}
+
+func TestBuildAssuranceParams_DeviceRole(t *testing.T) {
+	params, rejected := buildAssuranceParamsFromQuery(QueryModel{DeviceRole: " access, core "}, 0, 0, 10, 1)
+	if got := params.Get("deviceRole"); got != "ACCESS,CORE" || rejected != nil {
+		t.Fatalf("deviceRole = %q (rejected %v), want ACCESS,CORE", got, rejected)
+	}
+
+	params, rejected = buildAssuranceParamsFromQuery(QueryModel{DeviceRole: "ACCESS,ROUTER"}, 0, 0, 10, 1)
+	if got := params.Get("deviceRole"); got != "ACCESS" {
+		t.Errorf("deviceRole = %q, want ACCESS", got)
+	}
+	if want := []string{`deviceRole "ROUTER"`}; !reflect.DeepEqual(rejected, want) {
+		t.Errorf("rejected = %v, want %v", rejected, want)
+	}
+
+	params, _ = buildAssuranceParamsFromQuery(QueryModel{DeviceRole: "WAN"}, 0, 0, 10, 1)
+	if _, ok := params["deviceRole"]; ok {
+		t.Errorf("deviceRole should be omitted when every role is unknown")
+	}
+}
+
+func TestBuildAssuranceParams_DeviceReachability(t *testing.T) {
+	params, rejected := buildAssuranceParamsFromQuery(QueryModel{DeviceReachability: "unreachable,PING REACHABLE"}, 0, 0, 10, 1)
+	if got := params.Get("deviceReachability"); got != "Unreachable,Ping Reachable" || rejected != nil {
+		t.Fatalf("deviceReachability = %q (rejected %v), want Unreachable,Ping Reachable", got, rejected)
+	}
+
+	params, rejected = buildAssuranceParamsFromQuery(QueryModel{DeviceReachability: "sometimes"}, 0, 0, 10, 1)
+	if _, ok := params["deviceReachability"]; ok {
+		t.Errorf("deviceReachability should be omitted when invalid")
+	}
+	if want := []string{`deviceReachability "sometimes"`}; !reflect.DeepEqual(rejected, want) {
+		t.Errorf("rejected = %v, want %v", rejected, want)
+	}
+
+	params, _ = buildAssuranceParamsFromQuery(QueryModel{DeviceReachability: " "}, 0, 0, 10, 1)
+	if _, ok := params["deviceReachability"]; ok {
+		t.Errorf("deviceReachability should be omitted when empty")
+	}
+}
+
+func TestBuildAssuranceParams_UnresolvedVariables(t *testing.T) {
+	q := QueryModel{SiteID: "s-1,$site", DeviceID: "${device}", MacAddress: "aa:bb:cc:dd:ee:ff", Rule: "[[rule]]", Category: "Onboarding"}
+	params, rejected := buildAssuranceParamsFromQuery(q, 0, 0, 10, 1)
+	if got := params.Get("siteId"); got != "s-1" {
+		t.Errorf("siteId = %q, want the literal s-1 only", got)
+	}
+	if got := params.Get("macAddress"); got != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("macAddress = %q, want the literal value", got)
+	}
+	if got := params.Get("category"); got != "Onboarding" {
+		t.Errorf("category = %q, want Onboarding", got)
+	}
+	for _, k := range []string{"deviceId", "name"} {
+		if _, ok := params[k]; ok {
+			t.Errorf("%s should be omitted when unresolved", k)
+		}
+	}
+	want := []string{`siteId "$site"`, `deviceId "${device}"`, `rule "[[rule]]"`}
+	if !reflect.DeepEqual(rejected, want) {
+		t.Fatalf("rejected = %v, want %v", rejected, want)
+	}
+
+	// A dollar sign that isn't a variable reference is sent as-is.
+	params, rejected = buildAssuranceParamsFromQuery(QueryModel{IssueID: "cost$ 5"}, 0, 0, 10, 1)
+	if params.Get("issueId") != "cost$ 5" || rejected != nil {
+		t.Fatalf("issueId = %q (rejected %v), want the literal value", params.Get("issueId"), rejected)
+	}
+}
+
+func TestNormalizeResourceFilters(t *testing.T) {
+	in, _ := url.ParseQuery("priority=p2&priority=P1,p2,P9&issueStatus=Ignored&deviceRole=core&deviceReachability=ping reachable&siteId=s1&foo=Bar")
+	got := normalizeResourceFilters(in)
+	want := url.Values{
+		"priority":           {"P2,P1"},
+		"status":             {"IGNORED"},
+		"deviceRole":         {"CORE"},
+		"deviceReachability": {"Ping Reachable"},
+		"siteId":             {"s1"},
+		"foo":                {"Bar"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("normalizeResourceFilters = %v, want %v", got, want)
+	}
+
+	// Only invalid values: the filter is dropped rather than sent.
+	in, _ = url.ParseQuery("priority=P9&status=open")
+	if got := normalizeResourceFilters(in); len(got) != 0 {
+		t.Fatalf("normalizeResourceFilters = %v, want no filters", got)
+	}
+}
+
+func TestBuildAssuranceParams_InclusiveEnd(t *testing.T) {
+	const from, to = int64(1_700_000_000_000), int64(1_700_003_600_000)
+
+	v, _ := buildAssuranceParamsFromQuery(QueryModel{}, from, to, 100, 1)
+	if got := v.Get("endTime"); got != "1700003600000" {
+		t.Fatalf("endTime = %s, want the range end unchanged", got)
+	}
+	v, _ = buildAssuranceParamsFromQuery(QueryModel{InclusiveEnd: true}, from, to, 100, 1)
+	if got, start := v.Get("endTime"), v.Get("startTime"); got != "1700003600001" || start != "1700000000000" {
+		t.Fatalf("startTime, endTime = %s, %s; want the end 1ms later", start, got)
+	}
+	if body := buildAssuranceQueryBody(QueryModel{InclusiveEnd: true}, from, to); body.EndTime != to+1 {
+		t.Fatalf("query body endTime = %d, want %d", body.EndTime, to+1)
+	}
+}
+
+func TestBuildAssuranceParams_AllExpansion(t *testing.T) {
+	for _, all := range []string{"ALL", "all", "*"} {
+		q := QueryModel{Priority: []string{"P2", all}, IssueStatus: all}
+		v, rejected := buildAssuranceParamsFromQuery(q, 0, 0, 10, 1)
+		if got := v.Get("priority"); got != "P1,P2,P3,P4" {
+			t.Errorf("priority %q: priority = %q, want all four", all, got)
+		}
+		if got := v.Get("status"); got != "active,IGNORED,resolved" {
+			t.Errorf("issueStatus %q: status = %q, want every status", all, got)
+		}
+		if rejected != nil {
+			t.Errorf("%q: rejected = %v, want none", all, rejected)
+		}
+	}
+
+	// The legacy status alias expands too.
+	v, _ := buildAssuranceParamsFromQuery(QueryModel{Status: "*"}, 0, 0, 10, 1)
+	if got := v.Get("status"); got != "active,IGNORED,resolved" {
+		t.Errorf("status = %q, want every status", got)
+	}
+}
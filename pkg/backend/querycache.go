@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryCache holds state shared by all queries of a single QueryData call.
+// It is discarded when the call returns, so nothing leaks between dashboard
+// refreshes; the token cache lives longer, on the tokenManager.
+//
+// Scoping behavior:
+//   - Sibling queries whose filters, time range and limit are identical reuse
+//     the issues fetched by the first of them instead of paging again.
+//   - Site names, device names and device IPs resolved for one query are
+//     reused by the others; only IDs not seen yet are looked up. The same
+//     goes for device IDs resolved from an IP address in a deviceId filter.
+//   - Queries run concurrently, bounded by InstanceSettings.QueryConcurrency.
+//     A query with "driver": true runs on its own before all others. Its device IDs are
+//     recorded, and siblings with "scopeToDriver": true keep only issues for
+//     those devices.
+type queryCache struct {
+	mu            sync.Mutex
+	issues        map[string]*issuesFetch // key: issuesCacheKey
+	driverDevices map[string]struct{}     // nil until a driver query ran
+
+	siteNames       *idLookup // site ID -> site name
+	siteHierarchies *idLookup // site ID -> siteNameHierarchy
+	deviceIPs       *idLookup // device ID -> management IP
+	deviceNames     *idLookup // device ID -> hostname
+	deviceIDsByIP   *idLookup // management IP -> device ID
+}
+
+// newQueryCache creates an empty per-request cache.
+func newQueryCache() *queryCache {
+	return &queryCache{
+		issues:          make(map[string]*issuesFetch),
+		siteNames:       newIDLookup(),
+		siteHierarchies: newIDLookup(),
+		deviceIPs:       newIDLookup(),
+		deviceNames:     newIDLookup(),
+		deviceIDsByIP:   newIDLookup(),
+	}
+}
+
+// idLookup caches ID -> value resolutions (site names, device IPs) so each
+// ID is looked up upstream at most once per request.
+type idLookup struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+func newIDLookup() *idLookup {
+	return &idLookup{m: make(map[string]string)}
+}
+
+// missing returns the IDs that have not been resolved yet.
+func (l *idLookup) missing(ids []string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []string
+	for _, id := range ids {
+		if _, ok := l.m[id]; !ok {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func (l *idLookup) store(values map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, v := range values {
+		l.m[id] = v
+	}
+}
+
+// lookup returns the resolved values for the given IDs.
+func (l *idLookup) lookup(ids []string) map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]string, len(ids))
+	for _, id := range ids {
+		if v, ok := l.m[id]; ok {
+			out[id] = v
+		}
+	}
+	return out
+}
+
+// issuesCacheKey identifies an issues fetch by everything that influences
+// the upstream requests: the filters, the time range and the hard limit.
+func issuesCacheKey(qm QueryModel, from, to, hardLimit int64) string {
+	params, _ := buildAssuranceParamsFromQuery(qm, from, to, 0, 0)
+	if qm.UseQueryBody {
+		params.Set("queryBody", "true")
+	}
+	return params.Encode() + "&hardLimit=" + strconv.FormatInt(hardLimit, 10)
+}
+
+// issuesFetch is a single issues fetch shared by all queries with the same key.
+type issuesFetch struct {
+	done   chan struct{}
+	issues []map[string]any
+	err    error
+}
+
+// issuesOnce runs fetch for the first query with the given key. Queries with
+// the same key, including ones running concurrently, wait for and share its
+// result instead of paging again.
+func (c *queryCache) issuesOnce(key string, fetch func() ([]map[string]any, error)) ([]map[string]any, error) {
+	c.mu.Lock()
+	if f, ok := c.issues[key]; ok {
+		c.mu.Unlock()
+		<-f.done
+		return f.issues, f.err
+	}
+	f := &issuesFetch{done: make(chan struct{})}
+	c.issues[key] = f
+	c.mu.Unlock()
+
+	f.issues, f.err = fetch()
+	close(f.done)
+	return f.issues, f.err
+}
+
+// setDriverDevices records the device IDs present in the driver query's issues.
+func (c *queryCache) setDriverDevices(issues []map[string]any) {
+	set := make(map[string]struct{})
+	for _, it := range issues {
+		if id := issueStr(it, "deviceId"); id != "" {
+			set[id] = struct{}{}
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.driverDevices = set
+}
+
+// driverDeviceSet returns the driver's device IDs and whether a driver ran.
+func (c *queryCache) driverDeviceSet() (map[string]struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.driverDevices, c.driverDevices != nil
+}
+
+// uniqueIssueValues returns the distinct non-empty string values of key
+// across issues, in first-seen order.
+func uniqueIssueValues(issues []map[string]any, key string) []string {
+	seen := make(map[string]struct{})
+	var out []string
+	for _, it := range issues {
+		if v := issueStr(it, key); v != "" {
+			if _, dup := seen[v]; !dup {
+				seen[v] = struct{}{}
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// scopeToDevices keeps only the issues whose deviceId is in devices.
+func scopeToDevices(issues []map[string]any, devices map[string]struct{}) []map[string]any {
+	out := make([]map[string]any, 0, len(issues))
+	for _, it := range issues {
+		if _, keep := devices[issueStr(it, "deviceId")]; keep {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+// splitDriver separates the first driver query from the others. The relative
+// order of the remaining queries is preserved.
+func splitDriver(queries []backend.DataQuery) (*backend.DataQuery, []backend.DataQuery) {
+	for i, q := range queries {
+		var probe struct {
+			Driver bool `json:"driver"`
+		}
+		if err := json.Unmarshal(q.JSON, &probe); err == nil && probe.Driver {
+			rest := make([]backend.DataQuery, 0, len(queries)-1)
+			rest = append(rest, queries[:i]...)
+			rest = append(rest, queries[i+1:]...)
+			return &queries[i], rest
+		}
+	}
+	return nil, queries
+}
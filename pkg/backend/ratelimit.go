@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// errRateLimitWait is wrapped by the errors of requests that gave up waiting
+// for the rate limiter, so the circuit breaker can tell them from failures
+// of the cluster.
+var errRateLimitWait = errors.New("rate limit wait")
+
+// rateLimitedTransport makes every request wait for a token from a shared
+// token bucket before it is sent. Each instance has its own client (see
+// Datasource.clientFor), so the bucket caps the request rate per instance,
+// across issues pages, lookups, health probes and token requests alike.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+// RoundTrip waits for the limiter, giving up when the request's context ends.
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, fmt.Errorf("%w: %w", errRateLimitWait, err)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// newRateLimiter returns the token bucket for the settings, or nil when
+// RequestsPerSecond is unset, meaning no limit. Burst defaults to one
+// second's worth of requests.
+func newRateLimiter(s *InstanceSettings) *rate.Limiter {
+	if s.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := s.Burst
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(s.RequestsPerSecond)))
+	}
+	return rate.NewLimiter(rate.Limit(s.RequestsPerSecond), burst)
+}
@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedTransport_CapsThroughput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	s, err := ParseInstanceSettings([]byte(`{"requestsPerSecond":20,"burst":1}`), nil)
+	if err != nil {
+		t.Fatalf("ParseInstanceSettings error: %v", err)
+	}
+	client := NewDatasource().httpClientFor(s)
+
+	// One request passes at once, each further one waits 50ms.
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Fatalf("5 requests at 20/s took %v, want >= 200ms", elapsed)
+	}
+}
+
+func TestNewRateLimiter_Defaults(t *testing.T) {
+	s, _ := ParseInstanceSettings([]byte(`{}`), nil)
+	if l := newRateLimiter(s); l != nil {
+		t.Fatal("limiter without requestsPerSecond, want unlimited")
+	}
+	if _, ok := NewDatasource().httpClientFor(s).Transport.(*http.Transport); !ok {
+		t.Fatal("unlimited client should use the plain transport")
+	}
+
+	s, _ = ParseInstanceSettings([]byte(`{"requestsPerSecond":2.5}`), nil)
+	if l := newRateLimiter(s); l == nil || l.Burst() != 3 || l.Limit() != 2.5 {
+		t.Fatalf("limiter = %+v, want 2.5/s with a burst of 3", l)
+	}
+}
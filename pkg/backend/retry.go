@@ -0,0 +1,151 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// RetryPolicy controls how transient failures are retried. It is configured
+// once per instance and shared by every request path (issues, lookups, token).
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// 1 disables retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry; it doubles per retry.
+	BaseDelay time.Duration
+	// MaxDelay caps any single wait.
+	MaxDelay time.Duration
+	// Jitter randomizes each wait to between half and all of its nominal
+	// value, so many clients don't retry in lockstep.
+	Jitter bool
+}
+
+// defaultRetryPolicy returns the policy used when none is configured:
+// 3 attempts, waiting 200ms then 400ms, capped at 5s, without jitter.
+func defaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   200 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+	}
+}
+
+// backoff returns the wait before retry number n (1 for the first retry).
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter && d > 1 {
+		half := d / 2
+		d = half + time.Duration(rand.Int64N(int64(d-half)+1))
+	}
+	return d
+}
+
+// doWithRetry sends the request built by newReq, retrying on connection errors
+// and 5xx responses with exponential backoff as described by policy.
+// 4xx responses, including 401/403 which callers handle with a token
+// refresh, are returned immediately. Waiting between attempts stops as soon as
+// ctx is done. After the last attempt, the final response or error is returned.
+func doWithRetry(ctx context.Context, client *http.Client, newReq func() (*http.Request, error), policy RetryPolicy) (*http.Response, error) {
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if attempt >= maxAttempts || !isRetryable(resp, err) {
+			return resp, err
+		}
+
+		if err != nil {
+			log.DefaultLogger.FromContext(ctx).Warn("request failed; retrying", "url", req.URL.Redacted(), "attempt", attempt, "err", err)
+		} else {
+			log.DefaultLogger.FromContext(ctx).Warn("upstream error; retrying", "url", req.URL.Redacted(), "attempt", attempt, "status", resp.StatusCode)
+			// Drain so the connection can be reused.
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(policy.backoff(attempt)):
+		}
+	}
+}
+
+// maxRetryAfter caps how long a 429 response's Retry-After is honored.
+const maxRetryAfter = 30 * time.Second
+
+// doWithRateLimitRetry is doWithRetry, except that a 429 (Too Many Requests)
+// response is retried once more after the wait its Retry-After header asks
+// for, capped at maxRetryAfter, or after the policy's first backoff when it
+// has none. Waiting stops as soon as ctx is done.
+func doWithRateLimitRetry(ctx context.Context, client *http.Client, newReq func() (*http.Request, error), policy RetryPolicy) (*http.Response, error) {
+	resp, err := doWithRetry(ctx, client, newReq, policy)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
+	}
+
+	wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		wait = policy.backoff(1)
+	}
+	wait = min(wait, maxRetryAfter)
+	log.DefaultLogger.FromContext(ctx).Warn("rate limited; retrying", "url", resp.Request.URL.Redacted(), "waitMs", wait.Milliseconds())
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(wait):
+	}
+	return doWithRetry(ctx, client, newReq, policy)
+}
+
+// parseRetryAfter parses a Retry-After header value, either delay seconds or
+// an HTTP date, into a wait from now. Dates in the past mean no wait.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		if sec < 0 {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// isRetryable reports whether a request outcome is transient: a transport
+// error (other than cancellation or an open circuit breaker) or a 5xx response.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, errCircuitOpen)
+	}
+	return resp.StatusCode >= 500
+}
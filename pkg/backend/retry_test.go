@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetry returns a policy with the given attempts and millisecond delays.
+func fastRetry(attempts int) RetryPolicy {
+	return RetryPolicy{MaxAttempts: attempts, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+}
+
+func TestDoWithRetry_RecoversFrom5xx(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	newReq := func() (*http.Request, error) { return http.NewRequest(http.MethodGet, srv.URL, nil) }
+	resp, err := doWithRetry(context.Background(), srv.Client(), newReq, fastRetry(3))
+	if err != nil {
+		t.Fatalf("doWithRetry error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("calls = %d, want 3", got)
+	}
+}
+
+func TestDoWithRetry_NoRetryOn4xx(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	newReq := func() (*http.Request, error) { return http.NewRequest(http.MethodGet, srv.URL, nil) }
+	resp, err := doWithRetry(context.Background(), srv.Client(), newReq, fastRetry(3))
+	if err != nil {
+		t.Fatalf("doWithRetry error: %v", err)
+	}
+	resp.Body.Close()
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("calls = %d, want 1", got)
+	}
+}
+
+func TestDoWithRetry_StopsOnCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	newReq := func() (*http.Request, error) { return http.NewRequest(http.MethodGet, srv.URL, nil) }
+	if _, err := doWithRetry(ctx, srv.Client(), newReq, fastRetry(5)); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestRetryPolicy_BackoffSequence(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 200 * time.Millisecond, MaxDelay: time.Second}
+	want := []time.Duration{200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	p.Jitter = true
+	for n := 1; n <= 5; n++ {
+		nominal := want[n-1]
+		if got := p.backoff(n); got < nominal/2 || got > nominal {
+			t.Errorf("jittered backoff(%d) = %v, want within [%v, %v]", n, got, nominal/2, nominal)
+		}
+	}
+}
+
+func TestParseInstanceSettings_RetryPolicy(t *testing.T) {
+	s, err := ParseInstanceSettings([]byte(`{}`), nil)
+	if err != nil || s.RetryPolicy != defaultRetryPolicy() {
+		t.Fatalf("default policy = %+v (err=%v), want %+v", s.RetryPolicy, err, defaultRetryPolicy())
+	}
+
+	s, err = ParseInstanceSettings([]byte(`{"retryPolicy":{"maxAttempts":5,"baseDelayMs":100,"maxDelayMs":50,"jitter":true}}`), nil)
+	if err != nil {
+		t.Fatalf("ParseInstanceSettings error: %v", err)
+	}
+	want := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 100 * time.Millisecond, Jitter: true}
+	if s.RetryPolicy != want {
+		t.Fatalf("policy = %+v, want %+v", s.RetryPolicy, want)
+	}
+
+	// The legacy maxRetries shorthand counts retries, not attempts.
+	s, _ = ParseInstanceSettings([]byte(`{"maxRetries":0}`), nil)
+	if s.RetryPolicy.MaxAttempts != 1 {
+		t.Fatalf("maxRetries=0 gives %d attempts, want 1", s.RetryPolicy.MaxAttempts)
+	}
+}
+
+func TestDoWithRateLimitRetry_HonorsRetryAfter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	newReq := func() (*http.Request, error) { return http.NewRequest(http.MethodGet, srv.URL, nil) }
+	started := time.Now()
+	resp, err := doWithRateLimitRetry(context.Background(), srv.Client(), newReq, fastRetry(3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("calls = %d, want 2", got)
+	}
+	if waited := time.Since(started); waited < time.Second {
+		t.Fatalf("waited %v, want at least the 1s Retry-After", waited)
+	}
+}
+
+func TestDoWithRateLimitRetry_RetriesOnce(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	// Without Retry-After the policy's first backoff is used.
+	newReq := func() (*http.Request, error) { return http.NewRequest(http.MethodGet, srv.URL, nil) }
+	resp, err := doWithRateLimitRetry(context.Background(), srv.Client(), newReq, fastRetry(3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("calls = %d, want 2", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"5", 5 * time.Second, true},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := parseRetryAfter(tt.in, now); got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %t; want %v, %t", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
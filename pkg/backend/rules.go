@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	// rulesCacheTTL is how long the rule list of an instance is reused.
+	rulesCacheTTL = 5 * time.Minute
+	// ruleSampleWindow and ruleSampleLimit bound the issues sampled for rule
+	// names when the cluster has no issue definitions endpoint.
+	ruleSampleWindow = 7 * 24 * time.Hour
+	ruleSampleLimit  = 500
+)
+
+// ruleOption is one entry of the /rules resource: the value the rule filter
+// takes and a label for it.
+type ruleOption struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// cachedRules is the rule list of an instance, with when and for which base
+// URL it was fetched.
+type cachedRules struct {
+	baseURL   string
+	fetchedAt time.Time
+	rules     []ruleOption
+}
+
+// resourceRules handles GET /rules. It lists the issue rules for the query
+// editor's rule dropdown, sorted by ID, from the system issue definitions or,
+// on releases without them (404), the distinct rule names of the issues of
+// the last 7 days. The list is cached per instance for rulesCacheTTL.
+func (d *Datasource) resourceRules(ctx context.Context, inst *dsInstance, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, httpClient *http.Client) error {
+	if req.Method != "" && req.Method != http.MethodGet {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusMethodNotAllowed, Body: []byte("method not allowed")})
+	}
+	if inst.Settings.BaseURL == "" {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadRequest, Body: []byte("bad baseUrl")})
+	}
+
+	rules, err := d.rulesFor(ctx, inst, httpClient)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusBadGateway, Body: []byte(err.Error())})
+	}
+	body, err := json.Marshal(rules)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusInternalServerError, Body: []byte(err.Error())})
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  http.StatusOK,
+		Body:    body,
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+	})
+}
+
+// rulesFor returns the cached rule list of an instance, fetching it when
+// missing, stale or fetched for another base URL.
+func (d *Datasource) rulesFor(ctx context.Context, inst *dsInstance, httpClient *http.Client) ([]ruleOption, error) {
+	d.rulesMu.Lock()
+	c, ok := d.rules[inst.UID]
+	d.rulesMu.Unlock()
+	if ok && c.baseURL == inst.Settings.BaseURL && time.Since(c.fetchedAt) < rulesCacheTTL {
+		return c.rules, nil
+	}
+
+	rules, err := d.fetchIssueDefinitions(ctx, inst, httpClient)
+	if errors.Is(err, errNoIssueDefinitions) {
+		rules, err = d.sampleIssueRules(ctx, inst, httpClient)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	d.rulesMu.Lock()
+	d.rules[inst.UID] = cachedRules{baseURL: inst.Settings.BaseURL, fetchedAt: time.Now(), rules: rules}
+	d.rulesMu.Unlock()
+	return rules, nil
+}
+
+// errNoIssueDefinitions reports that the cluster has no issue definitions
+// endpoint.
+var errNoIssueDefinitions = errors.New("issue definitions endpoint not available")
+
+// fetchIssueDefinitions lists the rules of the system issue definitions:
+// their rule name as ID and their display name, when set, as name.
+func (d *Datasource) fetchIssueDefinitions(ctx context.Context, inst *dsInstance, httpClient *http.Client) ([]ruleOption, error) {
+	defsURL, err := IssueDefinitionsURL(inst.Settings.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	_, body, err := d.authedGet(ctx, httpClient, inst, "issue definitions", defsURL)
+	if apiStatus(err, "issue definitions") == http.StatusNotFound {
+		return nil, errNoIssueDefinitions
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env IssueDefinitionEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode issue definitions response: %w", err)
+	}
+
+	seen := make(map[string]struct{}, len(env.Response))
+	rules := make([]ruleOption, 0, len(env.Response))
+	for _, def := range env.Response {
+		id := firstNonEmpty(def.Name, def.ID)
+		if _, dup := seen[id]; dup || id == "" {
+			continue
+		}
+		seen[id] = struct{}{}
+		rules = append(rules, ruleOption{ID: id, Name: firstNonEmpty(def.DisplayName, id)})
+	}
+	return rules, nil
+}
+
+// sampleIssueRules derives the rule list from up to ruleSampleLimit issues
+// of the last ruleSampleWindow: their distinct rule names, labelled with
+// their title when it differs.
+func (d *Datasource) sampleIssueRules(ctx context.Context, inst *dsInstance, httpClient *http.Client) ([]ruleOption, error) {
+	now := time.Now()
+	issues, err := d.fetchIssues(ctx, inst, httpClient, QueryModel{}, now.Add(-ruleSampleWindow).UnixMilli(), now.UnixMilli(), ruleSampleLimit)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{})
+	rules := []ruleOption{}
+	for _, it := range issues {
+		id := issueStr(it, "name")
+		if _, dup := seen[id]; dup || id == "" {
+			continue
+		}
+		seen[id] = struct{}{}
+		rules = append(rules, ruleOption{ID: id, Name: firstNonEmpty(issueStr(it, "title"), issueStr(it, "issueTitle"), id)})
+	}
+	return rules, nil
+}
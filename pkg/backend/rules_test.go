@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestResourceRules_IssueDefinitions(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dna/intent/api/v1/systemIssueDefinitions" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"response":[` +
+			`{"id":"u2","name":"ap_down","displayName":"AP Down"},` +
+			`{"id":"u1","name":"switch_cpu_high"},` +
+			`{"id":"u3","name":"ap_down","displayName":"AP Down (dup)"}]}`))
+	}))
+	defer srv.Close()
+
+	d := NewDatasource()
+	req := &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "rules", Method: http.MethodGet}
+	for i := 0; i < 2; i++ {
+		resp := callResource(t, d, req)
+		if resp.Status != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", resp.Status, resp.Body)
+		}
+		want := `[{"id":"ap_down","name":"AP Down"},{"id":"switch_cpu_high","name":"switch_cpu_high"}]`
+		if string(resp.Body) != want {
+			t.Fatalf("body = %s, want %s", resp.Body, want)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("upstream calls = %d, want 1 (second call cached)", got)
+	}
+}
+
+func TestResourceRules_SampledFromIssues(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dna/intent/api/v1/systemIssueDefinitions" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[` +
+			`{"issueId":"i1","name":"ap_down","title":"AP went down"},` +
+			`{"issueId":"i2","name":"ap_down","title":"AP went down"},` +
+			`{"issueId":"i3","name":"radio_util_high"}]}`))
+	}))
+	defer srv.Close()
+
+	resp := callResource(t, NewDatasource(), &backend.CallResourceRequest{PluginContext: testPluginContext(srv.URL), Path: "rules", Method: http.MethodGet})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", resp.Status, resp.Body)
+	}
+	want := `[{"id":"ap_down","name":"AP went down"},{"id":"radio_util_high","name":"radio_util_high"}]`
+	if string(resp.Body) != want {
+		t.Fatalf("body = %s, want %s", resp.Body, want)
+	}
+}
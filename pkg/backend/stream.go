@@ -0,0 +1,235 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	log "github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+)
+
+const (
+	// issueStreamPrefix starts the channel path of every issue stream.
+	issueStreamPrefix = "issues/"
+
+	defaultStreamInterval = 30 * time.Second
+	minStreamInterval     = 10 * time.Second
+
+	// streamRegistrationTTL is how long a stream no RunStream is serving
+	// stays registered after the last query that registered it.
+	streamRegistrationTTL = 10 * time.Minute
+)
+
+// issueStream is a live alerts query registered by QueryData: its filters,
+// how far back each poll looks, and how often it polls.
+type issueStream struct {
+	Query    QueryModel
+	Window   time.Duration
+	Interval time.Duration
+	// FrameName names the pushed frames like the query frame they extend:
+	// the query's FrameName, or else its refID. It isn't part of the path,
+	// so panels sharing a stream get the name of the last one registering.
+	FrameName string `json:"-"`
+}
+
+// streamEntry is a registered issue stream: when a query last registered
+// it, and how many RunStream calls are serving it.
+type streamEntry struct {
+	stream     issueStream
+	registered time.Time
+	running    int
+}
+
+// newIssueStream returns the stream of an alerts query with the time range
+// tr. Each poll looks back over the span of tr. The refID and driver options
+// are dropped, so panels with the same filters share one stream.
+func newIssueStream(qm QueryModel, tr backend.TimeRange) issueStream {
+	s := issueStream{Window: tr.Duration(), Interval: defaultStreamInterval}
+	if s.Window <= 0 {
+		s.Window = time.Hour
+	}
+	if n := qm.StreamIntervalSeconds; n > 0 {
+		s.Interval = max(time.Duration(n)*time.Second, minStreamInterval)
+	}
+
+	qm.RefID = ""
+	qm.Stream, qm.StreamIntervalSeconds = false, 0
+	qm.Driver, qm.ScopeToDriver = false, false
+	s.Query = qm
+	return s
+}
+
+// path derives the channel path from the stream's filters, e.g.
+// "issues/3f9c0a1b2c3d4e5f". Grafana caps channel IDs at 160 characters, so
+// the filters are hashed rather than spelled out.
+func (s issueStream) path() string {
+	b, _ := json.Marshal(s)
+	sum := sha256.Sum256(b)
+	return issueStreamPrefix + hex.EncodeToString(sum[:8])
+}
+
+// registerIssueStream records the stream of an alerts query and returns the
+// channel its frame points Grafana Live at; frameName is the name of that
+// frame. Registering again refreshes the
+// stream. Streams that no RunStream serves are dropped once they haven't
+// been registered for streamRegistrationTTL, so edited filters and time
+// ranges don't pile up.
+func (d *Datasource) registerIssueStream(inst *dsInstance, qm QueryModel, tr backend.TimeRange, frameName string) string {
+	s := newIssueStream(qm, tr)
+	s.FrameName = frameName
+	p := s.path()
+	now := time.Now()
+
+	d.streamsMu.Lock()
+	for path, e := range d.streams {
+		if e.running == 0 && now.Sub(e.registered) > streamRegistrationTTL {
+			delete(d.streams, path)
+		}
+	}
+	if e, ok := d.streams[p]; ok {
+		e.stream, e.registered = s, now
+	} else {
+		d.streams[p] = &streamEntry{stream: s, registered: now}
+	}
+	d.streamsMu.Unlock()
+	return live.Channel{Scope: live.ScopeDatasource, Namespace: inst.UID, Path: p}.String()
+}
+
+// issueStreamFor returns the stream registered for a channel path.
+func (d *Datasource) issueStreamFor(path string) (issueStream, bool) {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+	if e, ok := d.streams[path]; ok {
+		return e.stream, true
+	}
+	return issueStream{}, false
+}
+
+// acquireIssueStream returns the stream for a channel path and counts a
+// RunStream serving it; releaseIssueStream undoes the count.
+func (d *Datasource) acquireIssueStream(path string) (issueStream, bool) {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+	e, ok := d.streams[path]
+	if !ok {
+		return issueStream{}, false
+	}
+	e.running++
+	return e.stream, true
+}
+
+// releaseIssueStream ends a RunStream of the stream on path that started at
+// started. When no RunStream is left the stream is dropped, unless a query
+// registered it again meanwhile; its panels register it again when they next
+// query.
+func (d *Datasource) releaseIssueStream(path string, started time.Time) {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+	e, ok := d.streams[path]
+	if !ok {
+		return
+	}
+	e.running--
+	if e.running <= 0 && !e.registered.After(started) {
+		delete(d.streams, path)
+	}
+}
+
+// SubscribeStream allows subscriptions to the channels of issue streams
+// registered by QueryData. After a backend restart streams are unknown until
+// their panels query again.
+func (d *Datasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if _, ok := d.issueStreamFor(req.Path); !ok {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream rejects all publications; issue streams are read-only.
+func (d *Datasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream polls the issues endpoint for the stream on req.Path until ctx
+// is done, and sends the issues that weren't there on the previous poll as
+// a frame. The first poll only records the issues present, as the query
+// frame already shows them. A failed poll is logged and retried on the next
+// tick.
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	started := time.Now()
+	s, ok := d.acquireIssueStream(req.Path)
+	if !ok {
+		return fmt.Errorf("unknown stream %q", req.Path)
+	}
+	defer d.releaseIssueStream(req.Path, started)
+	inst, err := getInstanceFromPluginContext(req.PluginContext)
+	if err != nil {
+		return err
+	}
+	logger := log.DefaultLogger.FromContext(ctx)
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	var seen map[string]struct{}
+	for {
+		frame, ids, err := d.pollIssueStream(ctx, inst, s, seen)
+		if err != nil {
+			logger.Warn("issue stream poll failed", "path", req.Path, "err", err)
+		} else {
+			seen = ids
+			if frame != nil {
+				if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+					return err
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollIssueStream collects the issues of s like an alerts query over the
+// stream window up to now. It returns the IDs of all issues collected and a
+// frame of those not in seen; the frame is nil when there are none or seen
+// is nil.
+func (d *Datasource) pollIssueStream(ctx context.Context, inst *dsInstance, s issueStream, seen map[string]struct{}) (*data.Frame, map[string]struct{}, error) {
+	httpClient := d.clientFor(inst)
+	qc := newQueryCache()
+	now := time.Now()
+	q := backend.DataQuery{TimeRange: backend.TimeRange{From: now.Add(-s.Window), To: now}}
+
+	set, err := d.collectIssues(ctx, inst, httpClient, q, s.Query, qc)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make(map[string]struct{}, len(set.issues))
+	var fresh []map[string]any
+	for _, issue := range set.issues {
+		id := issueID(issue)
+		if id == "" {
+			continue
+		}
+		ids[id] = struct{}{}
+		if _, old := seen[id]; seen != nil && !old {
+			fresh = append(fresh, issue)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil, ids, nil
+	}
+
+	opts, _ := d.issueFrameOptions(ctx, inst, httpClient, s.Query, fresh, qc)
+	opts.AgeReferenceMs = now.UnixMilli()
+	frame := issuesToFrame(s.FrameName, fresh, opts, q.TimeRange.From.UnixMilli())
+	return frame, ids, nil
+}
@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// packetRecorder is a backend.StreamPacketSender that hands packets to a
+// channel.
+type packetRecorder chan *backend.StreamPacket
+
+func (r packetRecorder) Send(p *backend.StreamPacket) error {
+	r <- p
+	return nil
+}
+
+func TestIssueStream_PublishesNewIssues(t *testing.T) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&polls, 1) == 1 {
+			_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"},{"issueId":"i2"}]}`))
+	}))
+	defer srv.Close()
+
+	// The query frame points at the stream's channel.
+	d := NewDatasource()
+	pc := testPluginContext(srv.URL)
+	resp, err := d.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pc,
+		Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts","priority":["P1"],"stream":true}`)},
+	})
+	if err != nil {
+		t.Fatalf("QueryData error: %v", err)
+	}
+	channel := resp.Responses["A"].Frames[0].Meta.Channel
+	prefix := "ds/test-uid/" + issueStreamPrefix
+	if !strings.HasPrefix(channel, prefix) {
+		t.Fatalf("channel = %q, want prefix %q", channel, prefix)
+	}
+	path := strings.TrimPrefix(channel, "ds/test-uid/")
+
+	sub, err := d.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{PluginContext: pc, Path: path})
+	if err != nil || sub.Status != backend.SubscribeStreamStatusOK {
+		t.Fatalf("subscribe = %+v, %v; want OK", sub, err)
+	}
+	if sub, _ := d.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{PluginContext: pc, Path: "issues/unknown"}); sub.Status != backend.SubscribeStreamStatusNotFound {
+		t.Fatalf("unknown path status = %v, want NotFound", sub.Status)
+	}
+	if pub, _ := d.PublishStream(context.Background(), &backend.PublishStreamRequest{PluginContext: pc, Path: path}); pub.Status != backend.PublishStreamStatusPermissionDenied {
+		t.Fatalf("publish status = %v, want PermissionDenied", pub.Status)
+	}
+
+	// Poll quickly: the first poll (after the query's fetch) records i1, the
+	// next one publishes i2 only.
+	d.streams[path].stream.Interval = 10 * time.Millisecond
+	atomic.StoreInt32(&polls, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	packets := make(packetRecorder, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- d.RunStream(ctx, &backend.RunStreamRequest{PluginContext: pc, Path: path}, backend.NewStreamSender(packets))
+	}()
+
+	var p *backend.StreamPacket
+	select {
+	case p = <-packets:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame published")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("RunStream error: %v", err)
+	}
+	// With its last RunStream gone the stream is dropped.
+	if _, ok := d.issueStreamFor(path); ok {
+		t.Fatal("stream still registered after RunStream returned")
+	}
+
+	frame := &data.Frame{}
+	if err := frame.UnmarshalJSON(p.Data); err != nil {
+		t.Fatalf("decode frame: %v", err)
+	}
+	if n, _ := frame.RowLen(); n != 1 {
+		t.Fatalf("rows = %d, want 1", n)
+	}
+	if id := frame.Fields[1].At(0).(string); id != "i2" {
+		t.Fatalf("streamed issue = %q, want i2", id)
+	}
+	if frame.Name != "A" {
+		t.Fatalf("streamed frame name = %q, want the query frame's A", frame.Name)
+	}
+}
+
+func TestIssueStream_PathIgnoresRefID(t *testing.T) {
+	tr := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	a := newIssueStream(QueryModel{RefID: "A", Priority: []string{"P1"}, Stream: true}, tr)
+	b := newIssueStream(QueryModel{RefID: "B", Priority: []string{"P1"}, Stream: true}, tr)
+	c := newIssueStream(QueryModel{RefID: "A", Priority: []string{"P2"}, Stream: true}, tr)
+	if a.path() != b.path() {
+		t.Fatalf("same filters: paths %q and %q differ", a.path(), b.path())
+	}
+	if a.path() == c.path() {
+		t.Fatalf("different filters share path %q", a.path())
+	}
+	if a.Interval != defaultStreamInterval || a.Window != time.Hour {
+		t.Fatalf("interval, window = %v, %v; want defaults", a.Interval, a.Window)
+	}
+	if s := newIssueStream(QueryModel{StreamIntervalSeconds: 1}, tr); s.Interval != minStreamInterval {
+		t.Fatalf("interval = %v, want the %v minimum", s.Interval, minStreamInterval)
+	}
+}
+
+func TestIssueStream_RegistryEviction(t *testing.T) {
+	d := NewDatasource()
+	inst := &dsInstance{UID: "uid", Settings: &InstanceSettings{}}
+	tr := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	path := func(channel string) string { return strings.TrimPrefix(channel, "ds/uid/") }
+
+	stale := path(d.registerIssueStream(inst, QueryModel{Priority: []string{"P1"}}, tr, "A"))
+	running := path(d.registerIssueStream(inst, QueryModel{Priority: []string{"P2"}}, tr, "A"))
+	for _, p := range []string{stale, running} {
+		d.streams[p].registered = time.Now().Add(-streamRegistrationTTL - time.Minute)
+	}
+	if _, ok := d.acquireIssueStream(running); !ok {
+		t.Fatal("stream not registered")
+	}
+
+	// Registering sweeps expired streams, but not those being served.
+	d.registerIssueStream(inst, QueryModel{Priority: []string{"P3"}}, tr, "A")
+	if _, ok := d.issueStreamFor(stale); ok {
+		t.Fatal("expired stream still registered")
+	}
+	if _, ok := d.issueStreamFor(running); !ok {
+		t.Fatal("served stream evicted")
+	}
+
+	// A stream registered again while it ran survives its RunStream.
+	started := time.Now().Add(-time.Second)
+	d.registerIssueStream(inst, QueryModel{Priority: []string{"P2"}}, tr, "A")
+	d.releaseIssueStream(running, started)
+	if _, ok := d.issueStreamFor(running); !ok {
+		t.Fatal("re-registered stream dropped when its RunStream ended")
+	}
+	if len(d.streams) != 2 {
+		t.Fatalf("registered streams = %d, want 2", len(d.streams))
+	}
+}
@@ -2,9 +2,13 @@ package backend
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,143 +17,343 @@ import (
 	log "github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
+// Expiry sources recorded on cached tokens, reported by the token/info resource.
+const (
+	expirySourceHeader  = "header"  // from response headers
+	expirySourceJSON    = "json"    // from fields of the JSON body
+	expirySourceJWT     = "jwt"     // from the exp claim of a JWT token
+	expirySourceDefault = "default" // no hint found; default TTL applied
+)
+
+// Token lifetimes used when InstanceSettings leaves them unset.
+const (
+	defaultTokenTTL    = 55 * time.Minute // no expiry hint in the token response
+	defaultMinTokenTTL = 5 * time.Minute  // hinted expiry missing or too soon
+)
+
+// tokenFileReread is how long a token read from InstanceSettings.TokenFilePath
+// is reused before the file is read again, so rotations are picked up soon.
+const tokenFileReread = 30 * time.Second
+
+// tokenFetchTimeout bounds a shared token fetch, which outlives the context
+// of the caller that started it.
+const tokenFetchTimeout = 2 * time.Minute
+
+// refreshSkew is how long before its expiry a cached token is replaced, so a
+// query never starts with a token that expires mid-flight. Tokens living
+// less than twice as long are replaced after half their life instead, see
+// refreshAt.
+const refreshSkew = 60 * time.Second
+
 // tokenManager handles the acquisition and caching of authentication tokens.
 // It ensures that a valid token is available for API requests, refreshing it
 // automatically when it expires. It supports both username/password credentials
 // and manual token overrides. The cache is keyed by datasource instance UID
 // to support multiple instances of the datasource.
 type tokenManager struct {
-	mu    sync.Mutex
-	cache map[string]tokenEntry // key: instance UID
+	mu       sync.Mutex
+	cache    map[string]tokenEntry  // key: instance UID
+	inflight map[string]*tokenFetch // key: instance UID; fetches in progress
+	files    map[string]fileToken   // key: instance UID; last token file read
+
+	// now and tokenURL are seams for tests: the clock used for every expiry
+	// computation and the derivation of the token endpoint from the settings.
+	now      func() time.Time
+	tokenURL func(base, tokenPath string) (string, error)
+}
+
+// tokenFetch is a token request shared by all callers that miss the cache
+// for the same instance while it is in flight.
+type tokenFetch struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// fileToken is a token read from a token file, and when it was read.
+type fileToken struct {
+	path   string
+	token  string
+	readAt time.Time
 }
 
 // newTokenManager creates a new token manager with an empty cache.
 func newTokenManager() *tokenManager {
 	return &tokenManager{
-		cache: make(map[string]tokenEntry),
+		cache:    make(map[string]tokenEntry),
+		inflight: make(map[string]*tokenFetch),
+		files:    make(map[string]fileToken),
+		now:      time.Now,
+		tokenURL: TokenURL,
 	}
 }
 
 // getToken retrieves a valid token for the given datasource instance.
 // It follows this order of precedence:
-//  1. Returns the manual API token from settings if provided.
+//  1. Returns the manual API token from settings if provided, or else the
+//     token in TokenFilePath, re-read at most every tokenFileReread. An empty
+//     or unreadable token file falls back to username and password.
 //  2. Returns a valid, non-expired token from the cache.
 //  3. If no valid token is found, it requests a new one using the provided
-//     username and password, then caches it with its expiry time.
+//     username and password, then caches it with its expiry time. Concurrent
+//     misses for the same instance share a single request.
 func (tm *tokenManager) getToken(ctx context.Context, instanceUID string, s *InstanceSettings, client *http.Client) (string, error) {
 	// 1. Manual override: if the user has configured a specific token, always use it.
 	if t := strings.TrimSpace(s.APIToken); t != "" {
 		return t, nil
 	}
+	if p := strings.TrimSpace(s.TokenFilePath); p != "" {
+		t, err := tm.fileToken(instanceUID, p)
+		if err == nil {
+			return t, nil
+		}
+		if s.Username == "" || s.Password == "" {
+			return "", fmt.Errorf("token file: %w; no username/password to fall back to", err)
+		}
+		log.DefaultLogger.FromContext(ctx).Warn("token file unusable; falling back to username/password", "err", err)
+	}
 
-	now := time.Now().Unix()
+	now := tm.now().Unix()
 
-	// 2. Cache check: return a valid, non-expired token if one exists.
+	// 2. Cache check: return a valid token if one exists. Tokens within
+	// refreshSkew of expiry (or past half their life, see refreshAt) count as
+	// stale and are refreshed preemptively.
 	tm.mu.Lock()
-	if e, ok := tm.cache[instanceUID]; ok && now < e.ExpiresAt && strings.TrimSpace(e.Token) != "" {
+	if e, ok := tm.cache[instanceUID]; ok && now < e.refreshAt() && strings.TrimSpace(e.Token) != "" {
 		t := e.Token
 		tm.mu.Unlock()
 		return t, nil
 	}
+
+	// 3. Join a request already in flight for this instance, or start one.
+	// The fetch doesn't run on the caller's ctx: the caller that started it
+	// may give up, e.g. when its panel is refreshed, while others still wait.
+	f, ok := tm.inflight[instanceUID]
+	if !ok {
+		f = &tokenFetch{done: make(chan struct{})}
+		tm.inflight[instanceUID] = f
+		go func() {
+			fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenFetchTimeout)
+			defer cancel()
+			f.token, f.err = tm.fetchToken(fetchCtx, instanceUID, s, client)
+
+			// The result is cached (on success) before the fetch is
+			// unregistered, so callers arriving afterwards hit the cache
+			// instead of fetching again.
+			tm.mu.Lock()
+			delete(tm.inflight, instanceUID)
+			tm.mu.Unlock()
+			close(f.done)
+		}()
+	}
 	tm.mu.Unlock()
 
-	// 3. New token request: if no credentials, we can't proceed.
-	if s.Username == "" || s.Password == "" {
-		return "", errors.New("no username/password provided; cannot obtain token")
+	select {
+	case <-f.done:
+		return f.token, f.err
+	case <-ctx.Done():
+		return "", ctx.Err()
 	}
+}
 
-	tokenURL, err := TokenURL(s.BaseURL)
+// fileToken returns the trimmed contents of the token file at path, reusing
+// the last read for uid while it is younger than tokenFileReread. A missing
+// or empty file is an error.
+func (tm *tokenManager) fileToken(uid, path string) (string, error) {
+	now := tm.now()
+	tm.mu.Lock()
+	f, ok := tm.files[uid]
+	tm.mu.Unlock()
+	if ok && f.path == path && now.Sub(f.readAt) < tokenFileReread {
+		return f.token, nil
+	}
+
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
+	tok := strings.TrimSpace(string(b))
+	if tok == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	tm.mu.Lock()
+	tm.files[uid] = fileToken{path: path, token: tok, readAt: now}
+	tm.mu.Unlock()
+	return tok, nil
+}
+
+// fetchToken requests a new token from the auth endpoint and caches it.
+func (tm *tokenManager) fetchToken(ctx context.Context, instanceUID string, s *InstanceSettings, client *http.Client) (string, error) {
+	// If no credentials, we can't proceed.
+	if s.Username == "" || s.Password == "" {
+		return "", errors.New("no username/password provided; cannot obtain token")
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, nil)
+	tokenURL, err := tm.tokenURL(s.BaseURL, s.TokenPath)
 	if err != nil {
 		return "", err
 	}
-	req.SetBasicAuth(s.Username, s.Password)
 
-	resp, err := client.Do(req)
+	logger := log.DefaultLogger.FromContext(ctx)
+	header, raw, err := tm.requestToken(ctx, client, tokenURL, s)
+	if err == nil && !hasToken(header, raw) {
+		// Flaky auth endpoints sometimes answer 2xx without a token; a
+		// second request usually gets one.
+		logger.Warn("token response carried no token; retrying once", "endpoint", tokenURL, "delay", emptyTokenRetryDelay)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(emptyTokenRetryDelay):
+		}
+		header, raw, err = tm.requestToken(ctx, client, tokenURL, s)
+	}
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", errors.New("token endpoint returned non-2xx: " + resp.Status)
+	// Token extraction: The token can be in a header or the response body.
+	// Prefer the header if present.
+	var body tokenBody
+	_ = json.Unmarshal(raw, &body)
+	tok := strings.TrimSpace(header.Get("X-Auth-Token"))
+	if tok == "" {
+		tok = body.token()
+	}
+	if tok == "" {
+		logger.Warn("DNAC token not found in header or JSON body")
+		return "", errors.New("token not found in response")
 	}
 
-	// 4. Token extraction: The token can be in a header or the response body.
-	// Prefer the header if present.
-	if tok := strings.TrimSpace(resp.Header.Get("X-Auth-Token")); tok != "" {
-		if expAt, ok := parseExpiryFromHeaders(resp.Header); ok {
-			tm.setWithExpiry(instanceUID, tok, expAt)
-			return tok, nil
-		}
-		// Fallback to default TTL if headers don't specify expiry.
-		tm.set(instanceUID, tok)
+	if expAt, source, ok := tm.tokenExpiry(ctx, tok, header, raw, body, s); ok {
+		tm.setWithExpiry(instanceUID, tok, expAt, source, s.MinTokenTTL)
 		return tok, nil
 	}
 
-	// 5. Fallback to body: The token and expiry hints can also be in the JSON body.
-	var body struct {
-		Token         string `json:"Token"`
-		Token2        string `json:"token"`
-		ExpiresIn     int64  `json:"expiresIn"`  // seconds
-		ExpiresInAlt  int64  `json:"expires_in"` // seconds
-		ExpiryEpoch   int64  `json:"expiry"`     // epoch seconds
-		ExpiresAt     int64  `json:"expiresAt"`  // epoch seconds
-		ExpireTimeRFC string `json:"expireTime"` // RFC3339 or RFC1123, if any
-		Expiration    int64  `json:"expiration"` // seconds or epoch (varies by APIs)
+	// Last resort: if no expiry information is found, use a default TTL.
+	tm.set(instanceUID, tok, s.DefaultTokenTTL)
+	return tok, nil
+}
+
+// tokenExpiry resolves when tok expires, whether it came in the X-Auth-Token
+// header or the body, along with the source of the expiry. An explicitly
+// configured expiry field bypasses all guessing; otherwise the JWT exp claim
+// wins over the response headers, which win over the body's expiry fields.
+// ok is false when the response carries no expiry.
+func (tm *tokenManager) tokenExpiry(ctx context.Context, tok string, header http.Header, raw []byte, body tokenBody, s *InstanceSettings) (int64, string, bool) {
+	if f := strings.TrimSpace(s.TokenExpiryField); f != "" {
+		var fields map[string]any
+		_ = json.Unmarshal(raw, &fields)
+		if expAt, ok := expiryFromField(fields, f, s.TokenExpiryUnit, tm.now()); ok {
+			return expAt, expirySourceJSON, true
+		}
+		log.DefaultLogger.FromContext(ctx).Warn("configured token expiry field missing or not numeric; using heuristics", "field", f)
 	}
-	_ = json.NewDecoder(resp.Body).Decode(&body)
 
-	tok := strings.TrimSpace(body.Token)
-	if tok == "" {
-		tok = strings.TrimSpace(body.Token2)
+	if expAt, ok := jwtExpiry(tok); ok {
+		return expAt, expirySourceJWT, true
 	}
-	if tok == "" {
-		log.DefaultLogger.Warn("DNAC token not found in header or JSON body")
-		return "", errors.New("token not found in response")
+	if expAt, ok := parseExpiryFromHeaders(header, tm.now()); ok {
+		return expAt, expirySourceHeader, true
+	}
+	if expAt, ok := deriveExpiryFromJSON(body, tm.now()); ok {
+		return expAt, expirySourceJSON, true
+	}
+	return 0, "", false
+}
+
+// emptyTokenRetryDelay is how long fetchToken waits before asking again
+// when the auth endpoint answered 2xx without a token.
+var emptyTokenRetryDelay = 500 * time.Millisecond
+
+// requestToken POSTs the credentials of s to tokenURL with the instance's
+// retry policy and returns the headers and body of the 2xx response; any
+// other status is an APIError.
+func (tm *tokenManager) requestToken(ctx context.Context, client *http.Client, tokenURL string, s *InstanceSettings) (http.Header, []byte, error) {
+	newReq := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(s.Username, s.Password)
+		return req, nil
 	}
 
-	// Prefer header-derived expiry if present; otherwise try JSON signals.
-	if expAt, ok := parseExpiryFromHeaders(resp.Header); ok {
-		tm.setWithExpiry(instanceUID, tok, expAt)
-		return tok, nil
+	started := time.Now()
+	resp, err := doWithRetry(ctx, client, newReq, s.RetryPolicy)
+	if err != nil {
+		return nil, nil, err
 	}
+	defer resp.Body.Close()
+	log.DefaultLogger.FromContext(ctx).Debug("token request done", "endpoint", tokenURL, "status", resp.StatusCode, "elapsedMs", time.Since(started).Milliseconds())
 
-	// Try common JSON fields for expiry.
-	if expAt, ok := deriveExpiryFromJSON(body); ok {
-		tm.setWithExpiry(instanceUID, tok, expAt)
-		return tok, nil
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, nil, newAPIError("token", resp, body)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read token response: %w", err)
 	}
+	return resp.Header, raw, nil
+}
 
-	// Last resort: if no expiry information is found, use a default TTL.
-	tm.set(instanceUID, tok)
-	return tok, nil
+// hasToken reports whether a token response carries a token, in the
+// X-Auth-Token header or the JSON body.
+func hasToken(header http.Header, raw []byte) bool {
+	if strings.TrimSpace(header.Get("X-Auth-Token")) != "" {
+		return true
+	}
+	var body tokenBody
+	_ = json.Unmarshal(raw, &body)
+	return body.token() != ""
 }
 
-// set caches a token with a default TTL (Time To Live).
-// This is used as a fallback when the API response doesn't provide expiry info.
-func (tm *tokenManager) set(uid, token string) {
+// set caches a token with a default TTL (Time To Live), defaultTokenTTL when
+// ttl is zero. This is used as a fallback when the API response doesn't
+// provide expiry info, and with an empty token to force a refresh, which
+// also makes the next call re-read the token file.
+func (tm *tokenManager) set(uid, token string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
-	// Default TTL: 55 minutes, a safe duration for most token-based APIs.
+	if token == "" {
+		delete(tm.files, uid)
+	}
+	now := tm.now()
 	tm.cache[uid] = tokenEntry{
 		Token:     token,
-		ExpiresAt: time.Now().Add(55 * time.Minute).Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Source:    expirySourceDefault,
+		CachedAt:  now.Unix(),
 	}
 }
 
-// setWithExpiry stores the token with an absolute expiry time (epoch seconds).
+// refreshAt returns when the token is due for a refresh: refreshSkew before
+// it expires, or halfway through its life when that is shorter, so a token
+// cached for less than refreshSkew (e.g. a MinTokenTTL of 10s) is still
+// reused rather than fetched again by every call.
+func (e tokenEntry) refreshAt() int64 {
+	skew := int64(refreshSkew / time.Second)
+	if e.CachedAt > 0 {
+		skew = min(skew, (e.ExpiresAt-e.CachedAt)/2)
+	}
+	return e.ExpiresAt - skew
+}
+
+// setWithExpiry stores the token with an absolute expiry time (epoch seconds)
+// and records where that expiry came from.
 // If the provided expiry time is in the past or too close to the present,
-// it applies a conservative minimum TTL to prevent caching an already-expired token.
-func (tm *tokenManager) setWithExpiry(uid, token string, expAt int64) {
-	const minTTL = 5 * time.Minute
-	now := time.Now()
+// it applies the minimum TTL minTTL (defaultMinTokenTTL when zero) instead,
+// to prevent caching an already-expired token.
+func (tm *tokenManager) setWithExpiry(uid, token string, expAt int64, source string, minTTL time.Duration) {
+	if minTTL <= 0 {
+		minTTL = defaultMinTokenTTL
+	}
+	now := tm.now()
 	if expAt <= now.Add(1*time.Minute).Unix() {
-		// Guard: if server-provided expiry is missing, invalid, or too soon, default to a safe minimum TTL.
+		// Guard: if server-provided expiry is missing, invalid, or too soon, default to the minimum TTL.
 		expAt = now.Add(minTTL).Unix()
 	}
 	tm.mu.Lock()
@@ -157,6 +361,37 @@ func (tm *tokenManager) setWithExpiry(uid, token string, expAt int64) {
 	tm.cache[uid] = tokenEntry{
 		Token:     token,
 		ExpiresAt: expAt,
+		Source:    source,
+		CachedAt:  now.Unix(),
+	}
+}
+
+// tokenInfo describes the cached token of an instance without revealing it.
+type tokenInfo struct {
+	Cached           bool   `json:"cached"`
+	ExpiresAt        int64  `json:"expiresAt,omitempty"` // Unix epoch seconds
+	Source           string `json:"source,omitempty"`    // header, json, jwt or default
+	SecondsRemaining int64  `json:"secondsRemaining"`
+}
+
+// info reports the state of the cached token for uid. An entry that was
+// cleared to force a refresh counts as not cached.
+func (tm *tokenManager) info(uid string) tokenInfo {
+	tm.mu.Lock()
+	e, ok := tm.cache[uid]
+	tm.mu.Unlock()
+	if !ok || strings.TrimSpace(e.Token) == "" {
+		return tokenInfo{}
+	}
+	remaining := e.ExpiresAt - tm.now().Unix()
+	if remaining < 0 {
+		remaining = 0
+	}
+	return tokenInfo{
+		Cached:           true,
+		ExpiresAt:        e.ExpiresAt,
+		Source:           e.Source,
+		SecondsRemaining: remaining,
 	}
 }
 
@@ -165,9 +400,8 @@ func (tm *tokenManager) setWithExpiry(uid, token string, expAt int64) {
 // parseExpiryFromHeaders attempts to determine the token's expiry time by inspecting
 // various standard and non-standard HTTP headers. It checks for headers that
 // specify a relative duration (e.g., Cache-Control: max-age) or an absolute time.
-func parseExpiryFromHeaders(h http.Header) (int64, bool) {
-	now := time.Now()
-
+// Relative durations are taken from now.
+func parseExpiryFromHeaders(h http.Header, now time.Time) (int64, bool) {
 	// 1) Explicit “expires in seconds”
 	for _, k := range []string{
 		"X-Auth-Token-Expires-In", // hypothetical common name
@@ -229,21 +463,92 @@ func parseMaxAge(cacheControl string) (int64, bool) {
 	return 0, false
 }
 
-// deriveExpiryFromJSON attempts to determine the token's expiry time by inspecting
-// various common fields in a JSON response body. It handles both relative durations
-// (e.g., "expiresIn": 3600) and absolute timestamps.
-func deriveExpiryFromJSON(body struct {
+// tokenBody lists the JSON body fields known to carry the token or its expiry.
+type tokenBody struct {
 	Token         string `json:"Token"`
 	Token2        string `json:"token"`
-	ExpiresIn     int64  `json:"expiresIn"`
-	ExpiresInAlt  int64  `json:"expires_in"`
-	ExpiryEpoch   int64  `json:"expiry"`
-	ExpiresAt     int64  `json:"expiresAt"`
-	ExpireTimeRFC string `json:"expireTime"`
-	Expiration    int64  `json:"expiration"`
-}) (int64, bool) {
-	now := time.Now()
+	ExpiresIn     int64  `json:"expiresIn"`  // seconds
+	ExpiresInAlt  int64  `json:"expires_in"` // seconds
+	ExpiryEpoch   int64  `json:"expiry"`     // epoch seconds
+	ExpiresAt     int64  `json:"expiresAt"`  // epoch seconds
+	ExpireTimeRFC string `json:"expireTime"` // RFC3339 or RFC1123, if any
+	Expiration    int64  `json:"expiration"` // seconds or epoch (varies by APIs)
+}
+
+// token returns the token of the body, from "Token" or else "token".
+func (b tokenBody) token() string {
+	if tok := strings.TrimSpace(b.Token); tok != "" {
+		return tok
+	}
+	return strings.TrimSpace(b.Token2)
+}
+
+// Units accepted for InstanceSettings.TokenExpiryUnit.
+const (
+	expiryUnitSeconds     = "seconds"     // relative: seconds from now
+	expiryUnitEpoch       = "epoch"       // absolute: Unix epoch seconds
+	expiryUnitEpochMillis = "epochMillis" // absolute: Unix epoch milliseconds
+)
 
+// expiryFromField reads the named top-level field from a decoded token body
+// and interprets it in the given unit, without any magnitude guessing.
+// Numeric strings are accepted as well as JSON numbers.
+func expiryFromField(fields map[string]any, field, unit string, now time.Time) (int64, bool) {
+	var n int64
+	switch v := fields[field].(type) {
+	case float64:
+		n = int64(v)
+	case string:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		n = parsed
+	default:
+		return 0, false
+	}
+	if n <= 0 {
+		return 0, false
+	}
+	switch unit {
+	case expiryUnitEpoch:
+		return n, true
+	case expiryUnitEpochMillis:
+		return n / 1000, true
+	default: // expiryUnitSeconds
+		return now.Add(time.Duration(n) * time.Second).Unix(), true
+	}
+}
+
+// jwtExpiry returns the exp claim (epoch seconds) of a token that looks like
+// a JWT: three dot-separated base64url segments whose middle one decodes to
+// a JSON object. Tokens that aren't JWTs or lack a positive exp report false.
+func jwtExpiry(token string) (int64, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return 0, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return 0, false
+	}
+	var claims struct {
+		Exp json.Number `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return 0, false
+	}
+	exp, err := claims.Exp.Float64()
+	if err != nil || exp <= 0 {
+		return 0, false
+	}
+	return int64(exp), true
+}
+
+// deriveExpiryFromJSON attempts to determine the token's expiry time by inspecting
+// various common fields in a JSON response body. It handles both relative durations
+// (e.g., "expiresIn": 3600) and absolute timestamps, relative to now.
+func deriveExpiryFromJSON(body tokenBody, now time.Time) (int64, bool) {
 	// seconds until expiry
 	if body.ExpiresIn > 0 {
 		return now.Add(time.Duration(body.ExpiresIn) * time.Second).Unix(), true
@@ -0,0 +1,504 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpiryFromField(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name   string
+		fields map[string]any
+		unit   string
+		want   int64
+		ok     bool
+	}{
+		{"seconds", map[string]any{"ttl": float64(600)}, expiryUnitSeconds, 1_700_000_600, true},
+		{"epoch", map[string]any{"ttl": float64(1_700_000_900)}, expiryUnitEpoch, 1_700_000_900, true},
+		{"epochMillis", map[string]any{"ttl": float64(1_700_000_900_000)}, expiryUnitEpochMillis, 1_700_000_900, true},
+		{"numeric string", map[string]any{"ttl": "120"}, expiryUnitSeconds, 1_700_000_120, true},
+		{"missing", map[string]any{}, expiryUnitSeconds, 0, false},
+		{"not numeric", map[string]any{"ttl": "soon"}, expiryUnitSeconds, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := expiryFromField(tt.fields, "ttl", tt.unit, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: expiryFromField = (%d,%v), want (%d,%v)", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDeriveExpiryFromJSON_ExpirationHeuristic(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	// Small values are read as a relative duration.
+	got, ok := deriveExpiryFromJSON(tokenBody{Expiration: 3600}, now)
+	if !ok || got != now.Unix()+3600 {
+		t.Fatalf("relative expiration = (%d,%v), want (%d,true)", got, ok, now.Unix()+3600)
+	}
+
+	// Values beyond now are read as an epoch.
+	epoch := now.Unix() + 7200
+	got, ok = deriveExpiryFromJSON(tokenBody{Expiration: epoch}, now)
+	if !ok || got != epoch {
+		t.Fatalf("epoch expiration = (%d,%v), want (%d,true)", got, ok, epoch)
+	}
+}
+
+// frozenTokenManager returns a tokenManager whose clock reads *now.
+func frozenTokenManager(now *time.Time) *tokenManager {
+	tm := newTokenManager()
+	tm.now = func() time.Time { return *now }
+	return tm
+}
+
+func TestSetWithExpiry_MinimumTTLGuard(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tm := frozenTokenManager(&now)
+
+	for _, tt := range []struct {
+		name  string
+		expAt int64
+		want  int64
+	}{
+		{"past", now.Unix() - 10, now.Unix() + 300},
+		{"within a minute", now.Unix() + 60, now.Unix() + 300},
+		{"plausible", now.Unix() + 61, now.Unix() + 61},
+		{"far", now.Unix() + 3600, now.Unix() + 3600},
+	} {
+		tm.setWithExpiry("uid", "tok", tt.expAt, expirySourceJSON, 0)
+		if got := tm.cache["uid"].ExpiresAt; got != tt.want {
+			t.Errorf("%s: ExpiresAt = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetToken_ProactiveRefreshWithFrozenClock(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&posts, 1)
+		_, _ = fmt.Fprintf(w, `{"Token":"t%d","expiresIn":600}`, n)
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	tm := frozenTokenManager(&now)
+	tm.tokenURL = func(string, string) (string, error) { return srv.URL + "/custom/token", nil }
+	s := &InstanceSettings{BaseURL: "https://unused.invalid", Username: "u", Password: "p"}
+
+	get := func() string {
+		t.Helper()
+		tok, err := tm.getToken(context.Background(), "uid", s, srv.Client())
+		if err != nil {
+			t.Fatalf("getToken error: %v", err)
+		}
+		return tok
+	}
+	if tok := get(); tok != "t1" || tm.cache["uid"].ExpiresAt != now.Unix()+600 {
+		t.Fatalf("first token = %q (expires %d), want t1 at now+600", tok, tm.cache["uid"].ExpiresAt)
+	}
+
+	// Just outside the refresh skew the cached token is still served.
+	now = now.Add(600*time.Second - refreshSkew - time.Second)
+	if tok := get(); tok != "t1" {
+		t.Fatalf("token before skew = %q, want t1", tok)
+	}
+
+	// Inside the skew it is replaced before it expires.
+	now = now.Add(time.Second)
+	if tok := get(); tok != "t2" {
+		t.Fatalf("token within skew = %q, want t2", tok)
+	}
+	if got := atomic.LoadInt32(&posts); got != 2 {
+		t.Fatalf("token POSTs = %d, want 2", got)
+	}
+}
+
+func TestGetToken_ExplicitExpiryField(t *testing.T) {
+	// With the heuristic, expiration=1800 would be read as "30 minutes from now".
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"Token":"abc","expiration":1800,"validUntil":"4102444800"}`))
+	}))
+	defer srv.Close()
+
+	s := &InstanceSettings{
+		BaseURL:          srv.URL,
+		Username:         "u",
+		Password:         "p",
+		TokenExpiryField: "validUntil",
+		TokenExpiryUnit:  expiryUnitEpoch,
+	}
+	tm := newTokenManager()
+	tok, err := tm.getToken(context.Background(), "uid", s, srv.Client())
+	if err != nil || tok != "abc" {
+		t.Fatalf("getToken = (%q,%v), want (abc,nil)", tok, err)
+	}
+	if e := tm.cache["uid"]; e.ExpiresAt != 4102444800 || e.Source != expirySourceJSON {
+		t.Fatalf("cache entry = %+v, want expiry from validUntil", e)
+	}
+}
+
+func TestGetToken_ExplicitExpiryFieldWithHeaderToken(t *testing.T) {
+	// The token comes in the header, its expiry only in the body.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Auth-Token", "hdr")
+		_, _ = w.Write([]byte(`{"validUntil":"4102444800"}`))
+	}))
+	defer srv.Close()
+
+	s := &InstanceSettings{
+		BaseURL:          srv.URL,
+		Username:         "u",
+		Password:         "p",
+		TokenExpiryField: "validUntil",
+		TokenExpiryUnit:  expiryUnitEpoch,
+	}
+	tm := newTokenManager()
+	tok, err := tm.getToken(context.Background(), "uid", s, srv.Client())
+	if err != nil || tok != "hdr" {
+		t.Fatalf("getToken = (%q,%v), want (hdr,nil)", tok, err)
+	}
+	if e := tm.cache["uid"]; e.ExpiresAt != 4102444800 || e.Source != expirySourceJSON {
+		t.Fatalf("cache entry = %+v, want expiry from validUntil", e)
+	}
+}
+
+func TestParseInstanceSettings_TokenExpiryUnit(t *testing.T) {
+	s, err := ParseInstanceSettings([]byte(`{"tokenExpiryField":"exp"}`), nil)
+	if err != nil || s.TokenExpiryUnit != expiryUnitSeconds {
+		t.Fatalf("default unit = (%q,%v), want seconds", s.TokenExpiryUnit, err)
+	}
+	if _, err := ParseInstanceSettings([]byte(`{"tokenExpiryUnit":"hours"}`), nil); err == nil {
+		t.Fatal("expected error for unknown unit")
+	}
+}
+
+func TestGetToken_RefreshesWithinSkew(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+		_, _ = w.Write([]byte(`{"Token":"fresh","expiresIn":3600}`))
+	}))
+	defer srv.Close()
+
+	s := &InstanceSettings{BaseURL: srv.URL, Username: "u", Password: "p"}
+	tm := newTokenManager()
+	// Bypass setWithExpiry, whose minimum TTL would push the expiry out.
+	tm.cache["uid"] = tokenEntry{Token: "stale", ExpiresAt: time.Now().Add(30 * time.Second).Unix()}
+
+	tok, err := tm.getToken(context.Background(), "uid", s, srv.Client())
+	if err != nil || tok != "fresh" {
+		t.Fatalf("getToken = (%q,%v), want (fresh,nil)", tok, err)
+	}
+	if got := atomic.LoadInt32(&posts); got != 1 {
+		t.Fatalf("token POSTs = %d, want 1", got)
+	}
+
+	// A manual token is returned as-is, regardless of the cache.
+	s.APIToken = "manual"
+	if tok, _ := tm.getToken(context.Background(), "uid", s, srv.Client()); tok != "manual" {
+		t.Fatalf("manual override = %q, want manual", tok)
+	}
+}
+
+func TestGetToken_ConcurrentMissesShareOneFetch(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+		// Hold the response so every goroutine misses the cache meanwhile.
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"Token":"shared","expiresIn":3600}`))
+	}))
+	defer srv.Close()
+
+	s := &InstanceSettings{BaseURL: srv.URL, Username: "u", Password: "p"}
+	tm := newTokenManager()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok, err := tm.getToken(context.Background(), "uid", s, srv.Client())
+			if err == nil && tok != "shared" {
+				err = fmt.Errorf("token = %q, want shared", tok)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&posts); got != 1 {
+		t.Fatalf("token POSTs = %d, want 1", got)
+	}
+}
+
+func TestGetToken_LeaderCancelDoesNotFailFollowers(t *testing.T) {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		_, _ = w.Write([]byte(`{"Token":"shared","expiresIn":3600}`))
+	}))
+	defer srv.Close()
+
+	s := &InstanceSettings{BaseURL: srv.URL, Username: "u", Password: "p"}
+	tm := newTokenManager()
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := tm.getToken(leaderCtx, "uid", s, srv.Client())
+		leaderErr <- err
+	}()
+	<-arrived
+
+	type result struct {
+		tok string
+		err error
+	}
+	follower := make(chan result, 1)
+	go func() {
+		tok, err := tm.getToken(context.Background(), "uid", s, srv.Client())
+		follower <- result{tok, err}
+	}()
+
+	cancel()
+	if err := <-leaderErr; err != context.Canceled {
+		t.Fatalf("leader error = %v, want context.Canceled", err)
+	}
+	close(release)
+	if r := <-follower; r.err != nil || r.tok != "shared" {
+		t.Fatalf("follower got (%q, %v), want the shared token", r.tok, r.err)
+	}
+}
+
+func TestFetchToken_ConfiguredTTLs(t *testing.T) {
+	body := `{"Token":"abc"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	s, err := ParseInstanceSettings([]byte(`{"baseUrl":"`+srv.URL+`","defaultTokenTtlSeconds":900,"minTokenTtlSeconds":120}`), map[string]string{"username": "u", "password": "p"})
+	if err != nil {
+		t.Fatalf("ParseInstanceSettings error: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	tm := frozenTokenManager(&now)
+
+	// No expiry hint: the configured default TTL applies.
+	if _, err := tm.fetchToken(context.Background(), "uid", s, srv.Client()); err != nil {
+		t.Fatalf("fetchToken error: %v", err)
+	}
+	if got := tm.cache["uid"].ExpiresAt; got != now.Unix()+900 {
+		t.Fatalf("default TTL expiry = %d, want now+900", got)
+	}
+
+	// An expiry that is already past falls back to the configured floor.
+	body = `{"Token":"abc","expiresAt":1}`
+	s.TokenExpiryField, s.TokenExpiryUnit = "expiresAt", expiryUnitEpoch
+	if _, err := tm.fetchToken(context.Background(), "uid", s, srv.Client()); err != nil {
+		t.Fatalf("fetchToken error: %v", err)
+	}
+	if got := tm.cache["uid"].ExpiresAt; got != now.Unix()+120 {
+		t.Fatalf("floored expiry = %d, want now+120", got)
+	}
+}
+
+func TestParseInstanceSettings_TokenTTLDefaults(t *testing.T) {
+	s, err := ParseInstanceSettings([]byte(`{}`), nil)
+	if err != nil || s.DefaultTokenTTL != 55*time.Minute || s.MinTokenTTL != 5*time.Minute {
+		t.Fatalf("TTLs = (%v,%v,%v), want (55m,5m)", s.DefaultTokenTTL, s.MinTokenTTL, err)
+	}
+}
+
+func TestGetToken_TokenFile(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tm := frozenTokenManager(&now)
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("  file-tok\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := &InstanceSettings{TokenFilePath: path}
+
+	tok, err := tm.getToken(context.Background(), "uid", s, http.DefaultClient)
+	if err != nil || tok != "file-tok" {
+		t.Fatalf("getToken = %q, %v; want file-tok", tok, err)
+	}
+
+	// A rotated token is picked up once the re-read interval has passed, or
+	// right away after a forced refresh.
+	if err := os.WriteFile(path, []byte("rotated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if tok, _ := tm.getToken(context.Background(), "uid", s, http.DefaultClient); tok != "file-tok" {
+		t.Fatalf("within re-read interval: token = %q, want the cached file-tok", tok)
+	}
+	now = now.Add(tokenFileReread)
+	if tok, _ := tm.getToken(context.Background(), "uid", s, http.DefaultClient); tok != "rotated" {
+		t.Fatalf("after re-read interval: token = %q, want rotated", tok)
+	}
+	if err := os.WriteFile(path, []byte("rotated-again"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tm.set("uid", "", 0)
+	if tok, _ := tm.getToken(context.Background(), "uid", s, http.DefaultClient); tok != "rotated-again" {
+		t.Fatalf("after forced refresh: token = %q, want rotated-again", tok)
+	}
+
+	// An empty file without credentials to fall back to is a clear error.
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tm.set("uid", "", 0)
+	if _, err := tm.getToken(context.Background(), "uid", s, http.DefaultClient); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Fatalf("empty file error = %v", err)
+	}
+}
+
+func TestGetToken_TokenFileFallsBackToCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Auth-Token", "fetched")
+	}))
+	defer srv.Close()
+
+	tm := newTokenManager()
+	s := &InstanceSettings{BaseURL: srv.URL, Username: "u", Password: "p", TokenFilePath: filepath.Join(t.TempDir(), "missing")}
+	if tok, err := tm.getToken(context.Background(), "uid", s, srv.Client()); err != nil || tok != "fetched" {
+		t.Fatalf("getToken = %q, %v; want the fetched token", tok, err)
+	}
+}
+
+// testJWT returns an unsigned JWT whose payload is the given claims JSON.
+func testJWT(claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(claims)) + ".c2ln"
+}
+
+func TestGetToken_JWTExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	exp := now.Unix() + 7200
+	jwt := testJWT(fmt.Sprintf(`{"sub":"u","exp":%d}`, exp))
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		body       string
+		wantExpiry int64
+		wantSource string
+	}{
+		{"header token", http.Header{"X-Auth-Token": {jwt}, "X-Auth-Token-Expires-In": {"600"}}, "", exp, expirySourceJWT},
+		{"body token", nil, `{"Token":"` + jwt + `","expiresIn":600}`, exp, expirySourceJWT},
+		{"not a JWT", nil, `{"Token":"opaque","expiresIn":600}`, now.Unix() + 600, expirySourceJSON},
+		{"JWT without exp", nil, `{"Token":"` + testJWT(`{"sub":"u"}`) + `","expiresIn":600}`, now.Unix() + 600, expirySourceJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			tm := frozenTokenManager(&now)
+			tm.tokenURL = func(string, string) (string, error) { return srv.URL, nil }
+			s := &InstanceSettings{BaseURL: srv.URL, Username: "u", Password: "p"}
+			if _, err := tm.getToken(context.Background(), "uid", s, srv.Client()); err != nil {
+				t.Fatalf("getToken error: %v", err)
+			}
+			if e := tm.cache["uid"]; e.ExpiresAt != tt.wantExpiry || e.Source != tt.wantSource {
+				t.Fatalf("cached expiry = %d (%s), want %d (%s)", e.ExpiresAt, e.Source, tt.wantExpiry, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestFetchToken_RetriesEmptyTokenOnce(t *testing.T) {
+	defer func(d time.Duration) { emptyTokenRetryDelay = d }(emptyTokenRetryDelay)
+	emptyTokenRetryDelay = time.Millisecond
+
+	var posts int32
+	empty := int32(1) // responses without a token before a valid one
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&posts, 1) <= atomic.LoadInt32(&empty) {
+			return // 200 with an empty body
+		}
+		_, _ = w.Write([]byte(`{"Token":"abc","expiresIn":3600}`))
+	}))
+	defer srv.Close()
+
+	s := &InstanceSettings{BaseURL: srv.URL, Username: "u", Password: "p"}
+	tok, err := newTokenManager().getToken(context.Background(), "uid", s, srv.Client())
+	if err != nil || tok != "abc" {
+		t.Fatalf("getToken = %q, %v; want abc after one retry", tok, err)
+	}
+	if got := atomic.LoadInt32(&posts); got != 2 {
+		t.Fatalf("token POSTs = %d, want 2", got)
+	}
+
+	// Only one retry: a second empty response fails.
+	atomic.StoreInt32(&posts, 0)
+	atomic.StoreInt32(&empty, 2)
+	if _, err := newTokenManager().getToken(context.Background(), "uid", s, srv.Client()); err == nil {
+		t.Fatal("getToken succeeded, want an error after two empty responses")
+	}
+	if got := atomic.LoadInt32(&posts); got != 2 {
+		t.Fatalf("token POSTs = %d, want 2", got)
+	}
+}
+
+func TestGetToken_ShortMinTTLIsCached(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&posts, 1)
+		// Too short an expiry: the token is cached for MinTokenTTL.
+		_, _ = fmt.Fprintf(w, `{"Token":"t%d","expiresIn":5}`, n)
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	tm := frozenTokenManager(&now)
+	s := &InstanceSettings{BaseURL: srv.URL, Username: "u", Password: "p", MinTokenTTL: 10 * time.Second}
+	get := func() string {
+		t.Helper()
+		tok, err := tm.getToken(context.Background(), "uid", s, srv.Client())
+		if err != nil {
+			t.Fatalf("getToken error: %v", err)
+		}
+		return tok
+	}
+
+	if tok := get(); tok != "t1" {
+		t.Fatalf("first token = %q, want t1", tok)
+	}
+	if tok := get(); tok != "t1" || atomic.LoadInt32(&posts) != 1 {
+		t.Fatalf("second token = %q after %d POSTs, want t1 from the cache", tok, atomic.LoadInt32(&posts))
+	}
+
+	// Past half its life it is replaced.
+	now = now.Add(5 * time.Second)
+	if tok := get(); tok != "t2" {
+		t.Fatalf("token at half life = %q, want t2", tok)
+	}
+}
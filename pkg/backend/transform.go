@@ -0,0 +1,937 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// issueRow is the curated, flattened form of a single Catalyst issue.
+type issueRow struct {
+	TimeMs     int64
+	ID         string
+	Title      string
+	Severity   string
+	Status     string
+	Category   string
+	Device     string
+	DeviceName string
+	DeviceIP   string
+	MAC        string
+	Site       string // full site hierarchy when enriched, else name or ID
+	SiteShort  string // site name alone
+	SitePath   string
+	Rule       string
+	Details    string
+	Notes      string // triage annotations
+	Assignee   string
+	UpdatedMs  int64  // last update in epoch milliseconds; 0 when unknown
+	Raw        string // compact issue JSON; only with frameOptions.IncludeRaw
+}
+
+// Frame kinds, used to name frames. See frameName.
+const (
+	frameKindIssues       = "issues"
+	frameKindSites        = "sites"
+	frameKindIssueCount   = "issueCount"
+	frameKindIssueTrend   = "issueTrend"
+	frameKindClientHealth = "clientHealth"
+	frameKindNetHealth    = "networkHealth"
+)
+
+// frameName returns the stable name of a frame of the given kind for refID.
+// The primary frame of a query keeps the bare refID as its name, as it always
+// has, so existing transforms and overrides keep working. Any additional frame
+// a query emits is named "<refID>/<kind>", e.g. "A/sites".
+func frameName(refID, kind string, primary bool) string {
+	if primary {
+		return refID
+	}
+	return refID + "/" + kind
+}
+
+// frameOptions carries the values resolved by enrichment lookups and the
+// display options that shape the issues frame.
+type frameOptions struct {
+	// SiteNames maps site IDs to names; unresolved IDs are shown as-is.
+	SiteNames map[string]string
+	// SiteHierarchies maps site IDs to their siteNameHierarchy. When nil, the
+	// "Site Path" column is omitted; sites missing from it show their name.
+	SiteHierarchies map[string]string
+	// SitePathSeparator joins the "Site Path" levels; empty means " > ".
+	SitePathSeparator string
+	// DeviceNames maps device IDs to hostnames. When nil, the "Device Name"
+	// column is omitted; unresolved IDs are shown as-is.
+	DeviceNames map[string]string
+	// DeviceIPs maps device IDs to management IPs. When nil, the "Device IP"
+	// column is omitted.
+	DeviceIPs map[string]string
+	// DisplayLocation, when set, adds a "Local Time" string column with each
+	// issue's time formatted in that zone. The Time column is always UTC.
+	DisplayLocation *time.Location
+	// TitleTemplate, when set, adds a "Display Title" column rendered from
+	// this text/template source over each issueRow.
+	TitleTemplate string
+	// LinkBase is the UI root the issue links are built on, see LinkBaseURL.
+	LinkBase string
+	// IssueLinkTemplate renders the "Issue URL" column from LinkBase and the
+	// issue ID; empty means defaultIssueLinkTemplate.
+	IssueLinkTemplate string
+	// TimeField, when set, is the issue key preferred for the Time column,
+	// before the usual coalesce chain. Must be one of timeFields.
+	TimeField string
+	// Fields, when non-empty, limits the frame to the named columns (plus
+	// Time), in frame order. See selectColumns.
+	Fields []string
+	// AgeReferenceMs is the instant the "Age (minutes)" column is measured
+	// against, normally the end of the query time range; zero means now.
+	AgeReferenceMs int64
+	// IncludeRaw appends a hidden "Raw" column with the compact JSON of each
+	// issue, after the selected columns.
+	IncludeRaw bool
+}
+
+// buildIssueRows flattens the raw API issues into issueRows. Site IDs are
+// replaced by names from opts when present, and issues without a usable
+// timestamp fall back to fallbackMs.
+func buildIssueRows(issues []map[string]any, opts frameOptions, fallbackMs int64) []issueRow {
+	issueRows := make([]issueRow, 0, len(issues))
+	for _, it := range issues {
+		getStr := func(k string) string { return issueStr(it, k) }
+
+		siteID := getStr("siteId")
+		siteName := siteID // Fallback to ID if enrichment is disabled or fails.
+		if name, ok := opts.SiteNames[siteID]; ok {
+			siteName = name // Use resolved name if available.
+		}
+		// Enriched rows show the full hierarchy, so two "Floor 1" sites in
+		// different buildings can be told apart.
+		siteFull := sitePath(opts.SiteHierarchies[siteID], siteName, "/")
+
+		device := firstNonEmpty(getStr("deviceId"), getStr("deviceIp"), getStr("device"))
+		deviceName := device // Fallback to the ID when unresolved.
+		if name, ok := opts.DeviceNames[getStr("deviceId")]; ok {
+			deviceName = name
+		}
+
+		r := issueRow{
+			TimeMs:     issueTimeFieldMs(it, opts.TimeField, fallbackMs),
+			ID:         issueID(it),
+			Title:      firstNonEmpty(getStr("name"), getStr("title"), getStr("issueTitle")),
+			Severity:   firstNonEmpty(getStr("priority"), getStr("severity")),
+			Status:     firstNonEmpty(getStr("issueStatus"), getStr("status")),
+			Category:   firstNonEmpty(getStr("category"), getStr("type")),
+			Device:     device,
+			DeviceName: deviceName,
+			DeviceIP:   opts.DeviceIPs[getStr("deviceId")],
+			MAC:        firstNonEmpty(getStr("macAddress"), getStr("clientMac")),
+			Site:       siteFull,
+			SiteShort:  siteName,
+			SitePath:   sitePath(opts.SiteHierarchies[siteID], siteName, opts.SitePathSeparator),
+			Rule:       getStr("ruleId"),
+			Details:    firstNonEmpty(getStr("description"), getStr("details"), getStr("issueDescription")),
+			Notes:      firstNonEmpty(getStr("notes"), getStr("note"), getStr("annotation"), getStr("comments")),
+			Assignee:   firstNonEmpty(getStr("assignee"), getStr("assignedTo"), getStr("owner")),
+			UpdatedMs:  issueUpdatedMs(it),
+		}
+		if opts.IncludeRaw {
+			if b, err := json.Marshal(it); err == nil {
+				r.Raw = string(b)
+			}
+		}
+		issueRows = append(issueRows, r)
+	}
+	return issueRows
+}
+
+// issuesToFrame converts the raw API issues into a Grafana data.Frame named
+// after the refID. See buildIssueRows for how issues are flattened.
+func issuesToFrame(refID string, issues []map[string]any, opts frameOptions, fallbackMs int64) *data.Frame {
+	// Data Transformation: Convert the raw API response into a structured format
+	// that can be used to build the Grafana data.Frame.
+	issueRows := buildIssueRows(issues, opts, fallbackMs)
+
+	// Build the Grafana data.Frame, which is the final structure that gets
+	// sent back to the frontend for rendering.
+	var notices []data.Notice
+	displayTitles, titleNotice := renderDisplayTitles(opts.TitleTemplate, issueRows)
+	if titleNotice != nil {
+		notices = append(notices, *titleNotice)
+	}
+	issueURLs, linkNotice := renderIssueURLs(opts.IssueLinkTemplate, opts.LinkBase, issueRows)
+	if linkNotice != nil {
+		notices = append(notices, *linkNotice)
+	}
+
+	frame := data.NewFrame(frameName(refID, frameKindIssues, true))
+	fTime := data.NewField("Time", nil, make([]time.Time, 0, len(issueRows)))
+	fLocalTime := data.NewField("Local Time", nil, make([]string, 0, len(issueRows)))
+	fID := data.NewField("Issue ID", nil, make([]string, 0, len(issueRows)))
+	fTitle := data.NewField("Title", nil, make([]string, 0, len(issueRows)))
+	fDisplayTitle := data.NewField("Display Title", nil, displayTitles)
+	fSeverity := data.NewField("Priority", nil, make([]string, 0, len(issueRows)))
+	fPriorityValue := data.NewField("Priority Value", nil, make([]*int64, 0, len(issueRows)))
+	fStatus := data.NewField("Status", nil, make([]string, 0, len(issueRows)))
+	fCategory := data.NewField("Category", nil, make([]string, 0, len(issueRows)))
+	fDevice := data.NewField("Device ID", nil, make([]string, 0, len(issueRows)))
+	fDeviceName := data.NewField("Device Name", nil, make([]string, 0, len(issueRows)))
+	fDeviceIP := data.NewField("Device IP", nil, make([]string, 0, len(issueRows)))
+	fMAC := data.NewField("MAC", nil, make([]string, 0, len(issueRows)))
+	fSite := data.NewField("Site Name", nil, make([]string, 0, len(issueRows)))
+	fSiteShort := data.NewField("Site Short Name", nil, make([]string, 0, len(issueRows)))
+	fSitePath := data.NewField("Site Path", nil, make([]string, 0, len(issueRows)))
+	fRule := data.NewField("Rule", nil, make([]string, 0, len(issueRows)))
+	fDetails := data.NewField("Details", nil, make([]string, 0, len(issueRows)))
+	fNotes := data.NewField("Notes", nil, make([]string, 0, len(issueRows)))
+	fAssignee := data.NewField("Assignee", nil, make([]string, 0, len(issueRows)))
+	fUpdated := data.NewField("Updated Time", nil, make([]*time.Time, 0, len(issueRows)))
+	fAge := data.NewField("Age (minutes)", nil, make([]int64, 0, len(issueRows)))
+	fAge.Config = &data.FieldConfig{Unit: "m", Decimals: ptrUint16(0)}
+	fIssueURL := data.NewField("Issue URL", nil, issueURLs)
+	fRaw := data.NewField("Raw", nil, make([]string, 0, len(issueRows)))
+	fRaw.Config = rawFieldConfig()
+	if opts.LinkBase != "" {
+		fDevice.Config = deviceLinkConfig(opts.LinkBase)
+	}
+
+	ageRefMs := opts.AgeReferenceMs
+	if ageRefMs == 0 {
+		ageRefMs = time.Now().UnixMilli()
+	}
+	for _, r := range issueRows {
+		t := time.UnixMilli(r.TimeMs).UTC()
+		fTime.Append(t)
+		if opts.DisplayLocation != nil {
+			fLocalTime.Append(formatLocalTime(t, opts.DisplayLocation))
+		}
+		fID.Append(r.ID)
+		fTitle.Append(r.Title)
+		fSeverity.Append(r.Severity)
+		fPriorityValue.Append(priorityValue(r.Severity))
+		fStatus.Append(r.Status)
+		fCategory.Append(r.Category)
+		fDevice.Append(r.Device)
+		fDeviceName.Append(r.DeviceName)
+		fDeviceIP.Append(r.DeviceIP)
+		fMAC.Append(r.MAC)
+		fSite.Append(r.Site)
+		fSiteShort.Append(r.SiteShort)
+		fSitePath.Append(r.SitePath)
+		fRule.Append(r.Rule)
+		fDetails.Append(r.Details)
+		fNotes.Append(r.Notes)
+		fAssignee.Append(r.Assignee)
+		if r.UpdatedMs != 0 {
+			updated := time.UnixMilli(r.UpdatedMs).UTC()
+			fUpdated.Append(&updated)
+		} else {
+			fUpdated.Append(nil)
+		}
+		fAge.Append(issueAgeSeconds(r.TimeMs, ageRefMs) / 60)
+		if opts.IncludeRaw {
+			fRaw.Append(r.Raw)
+		}
+	}
+
+	// Columns in frame order; optional ones only when their option is set.
+	// The triage columns (Notes, Assignee, Updated Time) are only returned
+	// when Fields names them.
+	triage := len(opts.Fields) > 0
+	columns := []struct {
+		field *data.Field
+		on    bool
+	}{
+		{fTime, true},
+		{fLocalTime, opts.DisplayLocation != nil},
+		{fID, true},
+		{fTitle, true},
+		{fDisplayTitle, opts.TitleTemplate != ""},
+		{fSeverity, true},
+		{fPriorityValue, true},
+		{fStatus, true},
+		{fCategory, true},
+		{fDevice, true},
+		{fDeviceName, opts.DeviceNames != nil},
+		{fDeviceIP, opts.DeviceIPs != nil},
+		{fMAC, true},
+		{fSite, true},
+		{fSiteShort, opts.SiteHierarchies != nil},
+		{fSitePath, opts.SiteHierarchies != nil},
+		{fRule, true},
+		{fDetails, true},
+		{fNotes, triage},
+		{fAssignee, triage},
+		{fUpdated, triage},
+		{fAge, true},
+		{fIssueURL, true},
+	}
+	known := make([]string, 0, len(columns))
+	for _, c := range columns {
+		known = append(known, c.field.Name)
+	}
+	selected, unknown := selectColumns(opts.Fields, known)
+	if len(unknown) > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "unknown fields ignored: " + strings.Join(unknown, ", "),
+		})
+	}
+	for _, c := range columns {
+		if !c.on {
+			continue
+		}
+		if _, ok := selected[c.field.Name]; ok || selected == nil {
+			frame.Fields = append(frame.Fields, c.field)
+		}
+	}
+	if opts.IncludeRaw {
+		frame.Fields = append(frame.Fields, fRaw)
+	}
+
+	if len(issueRows) == 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     "No issues found for the selected time range/filters",
+		})
+	}
+	if len(notices) > 0 {
+		frame.SetMeta(&data.FrameMeta{Notices: notices})
+	}
+	return frame
+}
+
+// rawFieldConfig hides the "Raw" column from tables, legends, tooltips and
+// visualizations; transformations and data links can still use it.
+func rawFieldConfig() *data.FieldConfig {
+	return &data.FieldConfig{Custom: map[string]any{
+		"hidden":   true,
+		"hideFrom": map[string]any{"legend": true, "tooltip": true, "viz": true},
+	}}
+}
+
+// defaultIssueLinkTemplate links to the issue details page of the
+// Catalyst Center UI.
+const defaultIssueLinkTemplate = "{{.BaseURL}}/dna/assurance/issueDetails?issueId={{.IssueID}}"
+
+// deviceLinkConfig returns the config of the "Device ID" field: a data link
+// to the device 360 page of the Catalyst Center UI at linkBase, so a click
+// on a device ID opens its assurance details.
+func deviceLinkConfig(linkBase string) *data.FieldConfig {
+	return &data.FieldConfig{
+		Links: []data.DataLink{{
+			Title:       "Device 360",
+			URL:         linkBase + "/dna/assurance/device/details?id=${__value.raw}",
+			TargetBlank: true,
+		}},
+	}
+}
+
+// issueLinkData is what issue link templates are executed against.
+type issueLinkData struct {
+	BaseURL string
+	IssueID string
+}
+
+// parseIssueLinkTemplate parses an issue link template; an empty src yields
+// defaultIssueLinkTemplate.
+func parseIssueLinkTemplate(src string) (*template.Template, error) {
+	if strings.TrimSpace(src) == "" {
+		src = defaultIssueLinkTemplate
+	}
+	return template.New("issueLink").Option("missingkey=error").Parse(src)
+}
+
+// renderIssueURLs renders the issue link of each row. An invalid template
+// falls back to defaultIssueLinkTemplate with a warning notice. Rows without
+// an ID, or whose rendering fails, get no link.
+func renderIssueURLs(src, linkBase string, rows []issueRow) ([]string, *data.Notice) {
+	var notice *data.Notice
+	tmpl, err := parseIssueLinkTemplate(src)
+	if err != nil {
+		notice = &data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "invalid issue link template, using the default: " + err.Error(),
+		}
+		tmpl, _ = parseIssueLinkTemplate("")
+	}
+
+	out := make([]string, len(rows))
+	var buf bytes.Buffer
+	for i, r := range rows {
+		if r.ID == "" {
+			continue
+		}
+		buf.Reset()
+		if err := tmpl.Execute(&buf, issueLinkData{BaseURL: linkBase, IssueID: url.QueryEscape(r.ID)}); err == nil {
+			out[i] = buf.String()
+		}
+	}
+	return out, notice
+}
+
+// selectColumns resolves a field selection against the known column names,
+// case-insensitively. It returns nil when names is empty, meaning all columns.
+// Time is always selected so the frame stays usable as a time series; names
+// that match no column are returned as unknown.
+func selectColumns(names, known []string) (selected map[string]struct{}, unknown []string) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	byLower := make(map[string]string, len(known))
+	for _, k := range known {
+		byLower[strings.ToLower(k)] = k
+	}
+	selected = map[string]struct{}{"Time": {}}
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if k, ok := byLower[strings.ToLower(n)]; ok {
+			selected[k] = struct{}{}
+		} else if n != "" {
+			unknown = append(unknown, n)
+		}
+	}
+	return selected, unknown
+}
+
+// defaultSitePathSeparator joins site hierarchy levels in "Site Path".
+const defaultSitePathSeparator = " > "
+
+// sitePath renders a slash-delimited site hierarchy as a breadcrumb, e.g.
+// "Global/US/NYC" -> "Global > US > NYC". Without a hierarchy it falls back
+// to the leaf name.
+func sitePath(hierarchy, leaf, sep string) string {
+	if sep == "" {
+		sep = defaultSitePathSeparator
+	}
+	var levels []string
+	for _, l := range strings.Split(hierarchy, "/") {
+		if l = strings.TrimSpace(l); l != "" {
+			levels = append(levels, l)
+		}
+	}
+	if len(levels) == 0 {
+		return leaf
+	}
+	return strings.Join(levels, sep)
+}
+
+// distinctColumn is an issues frame column that Distinct mode can list.
+type distinctColumn struct {
+	name string // column name in the issues frame
+	get  func(issueRow) string
+}
+
+// distinctColumns maps the accepted Distinct values, lower-cased with spaces
+// removed, to columns. Both the short form ("site") and the column name
+// ("Site Name") are accepted.
+var distinctColumns = func() map[string]distinctColumn {
+	cols := []struct {
+		aliases []string
+		col     distinctColumn
+	}{
+		{[]string{"id", "issueid"}, distinctColumn{"Issue ID", func(r issueRow) string { return r.ID }}},
+		{[]string{"title"}, distinctColumn{"Title", func(r issueRow) string { return r.Title }}},
+		{[]string{"priority", "severity"}, distinctColumn{"Priority", func(r issueRow) string { return r.Severity }}},
+		{[]string{"status"}, distinctColumn{"Status", func(r issueRow) string { return r.Status }}},
+		{[]string{"category"}, distinctColumn{"Category", func(r issueRow) string { return r.Category }}},
+		{[]string{"device", "deviceid"}, distinctColumn{"Device ID", func(r issueRow) string { return r.Device }}},
+		{[]string{"devicename"}, distinctColumn{"Device Name", func(r issueRow) string { return r.DeviceName }}},
+		{[]string{"deviceip"}, distinctColumn{"Device IP", func(r issueRow) string { return r.DeviceIP }}},
+		{[]string{"mac"}, distinctColumn{"MAC", func(r issueRow) string { return r.MAC }}},
+		{[]string{"site", "sitename"}, distinctColumn{"Site Name", func(r issueRow) string { return r.Site }}},
+		{[]string{"siteshort", "siteshortname"}, distinctColumn{"Site Short Name", func(r issueRow) string { return r.SiteShort }}},
+		{[]string{"sitepath"}, distinctColumn{"Site Path", func(r issueRow) string { return r.SitePath }}},
+		{[]string{"rule"}, distinctColumn{"Rule", func(r issueRow) string { return r.Rule }}},
+	}
+	m := make(map[string]distinctColumn)
+	for _, c := range cols {
+		for _, a := range c.aliases {
+			m[a] = c.col
+		}
+	}
+	return m
+}()
+
+// distinctFrame returns a single-column frame with the sorted, distinct,
+// non-empty values of the named field across rows. It is meant for template
+// variables, where full issue rows are of no use.
+func distinctFrame(refID, field string, rows []issueRow) (*data.Frame, error) {
+	key := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(field), " ", ""))
+	col, ok := distinctColumns[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown distinct field %q", field)
+	}
+	seen := make(map[string]struct{})
+	values := make([]string, 0)
+	for _, r := range rows {
+		v := col.get(r)
+		if v == "" {
+			continue
+		}
+		if _, dup := seen[v]; !dup {
+			seen[v] = struct{}{}
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return data.NewFrame(frameName(refID, frameKindIssues, true), data.NewField(col.name, nil, values)), nil
+}
+
+// appendNotices adds notices to the frame's metadata, creating it if needed.
+func appendNotices(frame *data.Frame, notices ...data.Notice) {
+	if len(notices) == 0 {
+		return
+	}
+	if frame.Meta == nil {
+		frame.SetMeta(&data.FrameMeta{})
+	}
+	frame.Meta.Notices = append(frame.Meta.Notices, notices...)
+}
+
+// rawIssuesFrame returns the issues with their JSON fields as columns, for
+// fields the curated issues frame doesn't map. Columns are the union of the
+// keys of all issues, sorted by name. A column whose values are all numbers
+// is numeric and one whose values are all booleans is boolean; anything else
+// is a string column, with objects and arrays JSON-encoded. Issues lacking a
+// key get a null.
+func rawIssuesFrame(refID string, issues []map[string]any) *data.Frame {
+	keySet := make(map[string]struct{})
+	for _, it := range issues {
+		for k := range it {
+			keySet[k] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	frame := data.NewFrame(frameName(refID, frameKindIssues, true))
+	for _, k := range keys {
+		frame.Fields = append(frame.Fields, rawColumn(k, issues))
+	}
+	if len(issues) == 0 {
+		frame.SetMeta(&data.FrameMeta{Notices: []data.Notice{{
+			Severity: data.NoticeSeverityInfo,
+			Text:     "No issues found for the selected time range/filters",
+		}}})
+	}
+	return frame
+}
+
+// rawColumn builds the nullable field for key k of the issues, typed by the
+// values present (see rawIssuesFrame).
+func rawColumn(k string, issues []map[string]any) *data.Field {
+	numeric, boolean := true, true
+	for _, it := range issues {
+		switch it[k].(type) {
+		case nil:
+		case float64:
+			boolean = false
+		case bool:
+			numeric = false
+		default:
+			numeric, boolean = false, false
+		}
+	}
+
+	switch {
+	case numeric:
+		vals := make([]*float64, len(issues))
+		for i, it := range issues {
+			if f, ok := it[k].(float64); ok {
+				vals[i] = &f
+			}
+		}
+		return data.NewField(k, nil, vals)
+	case boolean:
+		vals := make([]*bool, len(issues))
+		for i, it := range issues {
+			if b, ok := it[k].(bool); ok {
+				vals[i] = &b
+			}
+		}
+		return data.NewField(k, nil, vals)
+	default:
+		vals := make([]*string, len(issues))
+		for i, it := range issues {
+			switch v := it[k].(type) {
+			case nil:
+			case string:
+				vals[i] = &v
+			default:
+				b, _ := json.Marshal(v)
+				str := string(b)
+				vals[i] = &str
+			}
+		}
+		return data.NewField(k, nil, vals)
+	}
+}
+
+// issueCountFrame returns a single-row frame with the number of issues per
+// priority (P1..P4) and in total. Issues without a known priority only count
+// towards Total.
+func issueCountFrame(refID string, issues []map[string]any) *data.Frame {
+	counts := make(map[string]int64, len(allowedPriority))
+	for _, it := range issues {
+		if p, ok := normalizePriority(issueStr(it, "priority"), issueStr(it, "severity")); ok {
+			counts[p]++
+		}
+	}
+	frame := data.NewFrame(frameName(refID, frameKindIssueCount, true))
+	for _, p := range []string{"P1", "P2", "P3", "P4"} {
+		frame.Fields = append(frame.Fields, data.NewField(p, nil, []int64{counts[p]}))
+	}
+	frame.Fields = append(frame.Fields, data.NewField("Total", nil, []int64{int64(len(issues))}))
+	return frame
+}
+
+// issueTotalFrame returns the single-row frame of an issueCountFast query:
+// the total number of matching issues.
+func issueTotalFrame(refID string, total int64) *data.Frame {
+	return data.NewFrame(frameName(refID, frameKindIssueCount, true), data.NewField("Total", nil, []int64{total}))
+}
+
+// maxTrendBuckets caps how many buckets an issueTrend frame has; narrower
+// buckets are widened to fit the time range.
+const maxTrendBuckets = 1000
+
+// trendBucketMs returns the bucket width in milliseconds for a trend over
+// [fromMs, toMs]: bucketSeconds (default 3600), widened when the range would
+// need more than maxTrendBuckets buckets. widened reports the latter.
+func trendBucketMs(bucketSeconds int, fromMs, toMs int64) (bucketMs int64, widened bool) {
+	if bucketSeconds <= 0 {
+		bucketSeconds = 3600
+	}
+	bucketMs = int64(bucketSeconds) * 1000
+	if span := toMs - fromMs; span/bucketMs >= maxTrendBuckets {
+		bucketMs = span/(maxTrendBuckets-1) + 1
+		return bucketMs, true
+	}
+	return bucketMs, false
+}
+
+// issueTrendFrame counts issues per time bucket of bucketMs over [fromMs,
+// toMs], timed by timeField like the Time column. It has a Time field (the
+// bucket start, aligned to multiples of bucketMs) and one count field per
+// issue status plus Total; issues outside the range are left out.
+func issueTrendFrame(refID string, issues []map[string]any, timeField string, fromMs, toMs, bucketMs int64) *data.Frame {
+	start := fromMs - fromMs%bucketMs
+	n := 0
+	if toMs >= start {
+		n = int((toMs-start)/bucketMs) + 1
+	}
+	statuses := []string{"ACTIVE", "RESOLVED", "IGNORED"}
+	counts := make(map[string][]int64, len(statuses)+1)
+	for _, st := range append(statuses, "") {
+		counts[st] = make([]int64, n)
+	}
+	for _, it := range issues {
+		ms := issueTimeFieldMs(it, timeField, 0)
+		if ms < fromMs || ms > toMs {
+			continue
+		}
+		i := (ms - start) / bucketMs
+		if st, ok := normalizeIssueStatus(issueStr(it, "issueStatus"), issueStr(it, "status")); ok {
+			counts[st][i]++
+		}
+		counts[""][i]++ // Total
+	}
+
+	times := make([]time.Time, n)
+	for i := range times {
+		times[i] = time.UnixMilli(start + int64(i)*bucketMs).UTC()
+	}
+	frame := data.NewFrame(frameName(refID, frameKindIssueTrend, true), data.NewField("Time", nil, times))
+	for _, st := range statuses {
+		frame.Fields = append(frame.Fields, data.NewField(strings.ToUpper(st[:1])+strings.ToLower(st[1:]), nil, counts[st]))
+	}
+	frame.Fields = append(frame.Fields, data.NewField("Total", nil, counts[""]))
+	return frame
+}
+
+// priorityValue maps a priority to its number (P1 is 1 … P4 is 4) so panels
+// can apply thresholds to it; anything unrecognized is nil, which keeps it
+// out of aggregations.
+func priorityValue(p string) *int64 {
+	np, ok := normalizePriority(p, "")
+	if !ok {
+		return nil
+	}
+	n := int64(np[1] - '0')
+	return &n
+}
+
+// Priority returns the issue priority; it lets title templates use
+// {{.Priority}} alongside the other issueRow fields.
+func (r issueRow) Priority() string { return r.Severity }
+
+// renderDisplayTitles renders src as a text/template over each row. Rows whose
+// rendering fails, or all rows if src doesn't parse, fall back to the raw
+// title, and a single warning notice describes the first failure.
+func renderDisplayTitles(src string, rows []issueRow) ([]string, *data.Notice) {
+	out := make([]string, len(rows))
+	for i, r := range rows {
+		out[i] = r.Title
+	}
+	if src == "" {
+		return out, nil
+	}
+
+	tmpl, err := template.New("title").Parse(src)
+	if err != nil {
+		return out, &data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "invalid title template, showing raw titles: " + err.Error(),
+		}
+	}
+
+	var firstErr error
+	failed := 0
+	var buf bytes.Buffer
+	for i, r := range rows {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, r); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+		out[i] = buf.String()
+	}
+	if firstErr != nil {
+		return out, &data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("title template failed for %d of %d issues, showing raw titles: %v", failed, len(rows), firstErr),
+		}
+	}
+	return out, nil
+}
+
+// localTimeLayout is the "Local Time" column format: RFC 3339 with the zone
+// offset, so exported CSVs stay unambiguous.
+const localTimeLayout = "2006-01-02T15:04:05-07:00"
+
+// formatLocalTime renders t in loc using localTimeLayout.
+func formatLocalTime(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(localTimeLayout)
+}
+
+// unwrapValue returns the inner value of a {"value": ..., "unit": ...}
+// wrapper, as sent by some Catalyst versions, or v unchanged otherwise.
+func unwrapValue(v any) any {
+	if m, ok := v.(map[string]any); ok {
+		if inner, ok := m["value"]; ok {
+			return inner
+		}
+	}
+	return v
+}
+
+// v2IssueFields maps the v1 issue keys the transforms read to where the v2
+// issues schema of newer Catalyst Center releases keeps them, in order of
+// preference. Dotted paths reach into nested objects.
+var v2IssueFields = map[string][]string{
+	"issueId":     {"id"},
+	"name":        {"summary", "issueName"},
+	"priority":    {"issueSeverity.priority"},
+	"severity":    {"issueSeverity.level", "issueSeverity.severity"},
+	"issueStatus": {"state", "issueState"},
+	"category":    {"categoryName", "issueCategory"},
+	"deviceId":    {"entityId", "device.id"},
+	"siteId":      {"site.id"},
+	"ruleId":      {"rule.id", "issueRuleId"},
+	"description": {"issueDescription", "summaryDescription"},
+	"timestamp":   {"mostRecentOccurredTime", "lastOccurredTime"},
+	"macAddress":  {"clientMacAddress"},
+}
+
+// isV2Issue reports whether an issue is in the v2 schema: it has no issueId
+// but an id, or its severity is the nested issueSeverity object.
+func isV2Issue(it map[string]any) bool {
+	if _, nested := it["issueSeverity"].(map[string]any); nested {
+		return true
+	}
+	_, v1 := it["issueId"]
+	_, v2 := it["id"]
+	return v2 && !v1
+}
+
+// issueValue returns the value of the v1 key k. For v2 issues a key that is
+// absent is looked up at its v2IssueFields locations; v1 issues, and keys
+// present under their v1 name, are read as they are.
+func issueValue(it map[string]any, k string) (any, bool) {
+	if v, ok := it[k]; ok && v != nil {
+		return v, true
+	}
+	if !isV2Issue(it) {
+		return nil, false
+	}
+	for _, path := range v2IssueFields[k] {
+		var v any = it
+		for _, part := range strings.Split(path, ".") {
+			m, ok := v.(map[string]any)
+			if !ok {
+				v = nil
+				break
+			}
+			v = m[part]
+		}
+		if v != nil {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// issueStr returns the string value of key k, or "" when absent or not a string.
+// Values wrapped as {"value": ...} are unwrapped; see issueValue for v2 issues.
+func issueStr(it map[string]any, k string) string {
+	if v, ok := issueValue(it, k); ok {
+		if s, ok2 := unwrapValue(v).(string); ok2 {
+			return s
+		}
+	}
+	return ""
+}
+
+// issueNum returns the integral value of key k, or 0 when absent or not numeric.
+// Values wrapped as {"value": ...} are unwrapped; see issueValue for v2 issues.
+func issueNum(it map[string]any, k string) int64 {
+	if v, ok := issueValue(it, k); ok {
+		switch x := unwrapValue(v).(type) {
+		case float64:
+			return int64(x)
+		case int64:
+			return x
+		case json.Number:
+			n, _ := x.Int64()
+			return n
+		}
+	}
+	return 0
+}
+
+// issueTimeMs returns the issue's timestamp in epoch milliseconds, coalesced
+// from the known time fields, or fallbackMs when none is set.
+func issueTimeMs(it map[string]any, fallbackMs int64) int64 {
+	if ms := firstNonZero(issueNum(it, "timestamp"), issueNum(it, "firstOccurredTime"), issueNum(it, "startTime")); ms != 0 {
+		return ms
+	}
+	return fallbackMs
+}
+
+// issueUpdatedMs returns when the issue was last updated in epoch
+// milliseconds, coalesced from the known update fields, or 0 when none is
+// set. RFC 3339 strings are accepted as well as epoch milliseconds.
+func issueUpdatedMs(it map[string]any) int64 {
+	for _, k := range []string{"lastUpdatedTime", "updatedTime", "lastModifiedTime"} {
+		if ms := issueNum(it, k); ms != 0 {
+			return ms
+		}
+		if t, err := time.Parse(time.RFC3339, issueStr(it, k)); err == nil {
+			return t.UnixMilli()
+		}
+	}
+	return 0
+}
+
+// timeFields are the issue keys a query may pick to drive the Time column.
+var timeFields = map[string]struct{}{
+	"timestamp":         {},
+	"firstOccurredTime": {},
+	"lastOccurredTime":  {},
+	"mostRecentTime":    {},
+	"startTime":         {},
+	"endTime":           {},
+	"lastUpdatedTime":   {},
+}
+
+// knownTimeField returns field trimmed when it is one of timeFields, or ""
+// when it is unset or unknown.
+func knownTimeField(field string) string {
+	field = strings.TrimSpace(field)
+	if _, ok := timeFields[field]; !ok {
+		return ""
+	}
+	return field
+}
+
+// issueTimeFieldMs is issueTimeMs with field, when set and present on the
+// issue, taking precedence over the coalesce chain.
+func issueTimeFieldMs(it map[string]any, field string, fallbackMs int64) int64 {
+	if field != "" {
+		if ms := issueNum(it, field); ms != 0 {
+			return ms
+		}
+	}
+	return issueTimeMs(it, fallbackMs)
+}
+
+// issueAgeSeconds returns how long before refMs an issue at timeMs occurred.
+// Issues timestamped after refMs have an age of zero.
+func issueAgeSeconds(timeMs, refMs int64) int64 {
+	if timeMs >= refMs {
+		return 0
+	}
+	return (refMs - timeMs) / 1000
+}
+
+// ageReferenceMs returns the instant issue ages are measured against: the end
+// of the query time range, or now when the range is unset.
+func ageReferenceMs(tr backend.TimeRange) int64 {
+	if tr.To.IsZero() {
+		return time.Now().UnixMilli()
+	}
+	return tr.To.UnixMilli()
+}
+
+// issueID returns the issue's ID, coalesced from the known ID fields.
+func issueID(it map[string]any) string {
+	return firstNonEmpty(issueStr(it, "issueId"), issueStr(it, "id"), issueStr(it, "instanceId"))
+}
+
+// dedupeIssues drops issues whose ID was already seen, keeping the first
+// occurrence and the original order. Offset paging can return the same issue
+// twice when new issues arrive mid-scan. Issues without an ID are kept.
+func dedupeIssues(issues []map[string]any) []map[string]any {
+	seen := make(map[string]struct{}, len(issues))
+	out := make([]map[string]any, 0, len(issues))
+	for _, it := range issues {
+		if id := issueID(it); id != "" {
+			if _, dup := seen[id]; dup {
+				continue
+			}
+			seen[id] = struct{}{}
+		}
+		out = append(out, it)
+	}
+	return out
+}
+
+// filterMinAge drops issues younger than minAgeSeconds relative to refMs,
+// timed like the Age column: by timeField when set, else the coalesce chain.
+// A non-positive minAgeSeconds disables the filter.
+func filterMinAge(issues []map[string]any, minAgeSeconds int, timeField string, fallbackMs, refMs int64) []map[string]any {
+	if minAgeSeconds <= 0 {
+		return issues
+	}
+	out := make([]map[string]any, 0, len(issues))
+	for _, it := range issues {
+		if issueAgeSeconds(issueTimeFieldMs(it, timeField, fallbackMs), refMs) >= int64(minAgeSeconds) {
+			out = append(out, it)
+		}
+	}
+	return out
+}
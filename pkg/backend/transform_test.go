@@ -0,0 +1,583 @@
+package backend
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestFilterMinAge_Boundaries(t *testing.T) {
+	const ref = int64(1_700_000_600_000)
+	issues := []map[string]any{
+		{"issueId": "old", "timestamp": float64(ref - 600_000)},   // 600s old
+		{"issueId": "edge", "timestamp": float64(ref - 300_000)},  // exactly 300s old
+		{"issueId": "young", "timestamp": float64(ref - 299_000)}, // 299s old
+		{"issueId": "future", "timestamp": float64(ref + 60_000)}, // clamps to age 0
+	}
+
+	got := filterMinAge(issues, 300, "", 0, ref)
+	if len(got) != 2 || got[0]["issueId"] != "old" || got[1]["issueId"] != "edge" {
+		t.Fatalf("filterMinAge(300) kept %v, want [old edge]", got)
+	}
+
+	if got := filterMinAge(issues, 0, "", 0, ref); len(got) != len(issues) {
+		t.Fatalf("filterMinAge(0) kept %d issues, want %d", len(got), len(issues))
+	}
+}
+
+func TestFilterMinAge_TimeField(t *testing.T) {
+	const ref = int64(1_700_000_600_000)
+	// The coalesced timestamp makes every issue old; lastUpdatedTime decides.
+	issues := []map[string]any{
+		{"issueId": "edge", "timestamp": float64(ref - 3_600_000), "lastUpdatedTime": float64(ref - 300_000)},
+		{"issueId": "young", "timestamp": float64(ref - 3_600_000), "lastUpdatedTime": float64(ref - 299_000)},
+		{"issueId": "unset", "timestamp": float64(ref - 3_600_000)}, // falls back to timestamp
+	}
+
+	got := filterMinAge(issues, 300, "lastUpdatedTime", 0, ref)
+	if len(got) != 2 || got[0]["issueId"] != "edge" || got[1]["issueId"] != "unset" {
+		t.Fatalf("filterMinAge(300, lastUpdatedTime) kept %v, want [edge unset]", got)
+	}
+}
+
+func TestIssueAgeSeconds(t *testing.T) {
+	if got := issueAgeSeconds(1_000, 61_000); got != 60 {
+		t.Fatalf("issueAgeSeconds = %d, want 60", got)
+	}
+	if got := issueAgeSeconds(61_000, 1_000); got != 0 {
+		t.Fatalf("issueAgeSeconds(future) = %d, want 0", got)
+	}
+}
+
+func TestIssuesToFrame_LocalTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("zone database unavailable: %v", err)
+	}
+	issues := []map[string]any{{"issueId": "i1", "timestamp": float64(1_700_000_000_000)}}
+
+	frame := issuesToFrame("A", issues, frameOptions{DisplayLocation: loc}, 0)
+	ts := frame.Fields[0].At(0).(time.Time)
+	if ts.Location() != time.UTC {
+		t.Fatalf("Time location = %v, want UTC", ts.Location())
+	}
+	f, idx := frame.FieldByName("Local Time")
+	if idx < 0 {
+		t.Fatal("missing Local Time field")
+	}
+	if got, want := f.At(0).(string), "2023-11-14T17:13:20-05:00"; got != want {
+		t.Fatalf("Local Time = %q, want %q", got, want)
+	}
+
+	// Without a display zone the column is omitted.
+	if _, idx := issuesToFrame("A", issues, frameOptions{}, 0).FieldByName("Local Time"); idx >= 0 {
+		t.Fatal("Local Time should be omitted by default")
+	}
+}
+
+func TestParseInstanceSettings_DisplayTimezone(t *testing.T) {
+	if _, err := ParseInstanceSettings([]byte(`{"displayTimezone":"Not/AZone"}`), nil); err == nil {
+		t.Fatal("expected error for unknown zone")
+	}
+	s, err := ParseInstanceSettings([]byte(`{"displayTimezone":"UTC"}`), nil)
+	if err != nil || s.DisplayLocation == nil {
+		t.Fatalf("UTC zone = (%v,%v), want loaded location", s.DisplayLocation, err)
+	}
+}
+
+func TestFrameName(t *testing.T) {
+	if got := frameName("A", frameKindIssues, true); got != "A" {
+		t.Fatalf("primary frame name = %q, want A", got)
+	}
+	if got := frameName("A", frameKindSites, false); got != "A/sites" {
+		t.Fatalf("secondary frame name = %q, want A/sites", got)
+	}
+	if got := issuesToFrame("B", nil, frameOptions{}, 0).Name; got != "B" {
+		t.Fatalf("issues frame name = %q, want B", got)
+	}
+}
+
+func TestIssueGetters_WrappedValues(t *testing.T) {
+	it := map[string]any{
+		"plainCount":   float64(3),
+		"wrappedCount": map[string]any{"value": float64(5), "unit": "count"},
+		"plainName":    "AP-1",
+		"wrappedName":  map[string]any{"value": "AP-2"},
+		"noValue":      map[string]any{"unit": "count"},
+	}
+	if got := issueNum(it, "plainCount"); got != 3 {
+		t.Errorf("plain num = %d, want 3", got)
+	}
+	if got := issueNum(it, "wrappedCount"); got != 5 {
+		t.Errorf("wrapped num = %d, want 5", got)
+	}
+	if got := issueNum(it, "noValue"); got != 0 {
+		t.Errorf("wrapper without value = %d, want 0", got)
+	}
+	if got := issueStr(it, "plainName"); got != "AP-1" {
+		t.Errorf("plain str = %q, want AP-1", got)
+	}
+	if got := issueStr(it, "wrappedName"); got != "AP-2" {
+		t.Errorf("wrapped str = %q, want AP-2", got)
+	}
+}
+
+func TestIssuesToFrame_TitleTemplate(t *testing.T) {
+	issues := []map[string]any{{"name": "AP-Disconnect", "priority": "P1", "siteId": "s1"}}
+	opts := frameOptions{
+		SiteNames:     map[string]string{"s1": "Site-X"},
+		TitleTemplate: "[{{.Priority}}] {{.Title}} @ {{.Site}}",
+	}
+	frame := issuesToFrame("A", issues, opts, 0)
+	f, idx := frame.FieldByName("Display Title")
+	if idx < 0 {
+		t.Fatal("missing Display Title field")
+	}
+	if got, want := f.At(0).(string), "[P1] AP-Disconnect @ Site-X"; got != want {
+		t.Fatalf("Display Title = %q, want %q", got, want)
+	}
+
+	// Execution errors fall back to the raw title with a notice.
+	opts.TitleTemplate = "{{.NoSuchField}}"
+	frame = issuesToFrame("A", issues, opts, 0)
+	f, _ = frame.FieldByName("Display Title")
+	if got := f.At(0).(string); got != "AP-Disconnect" {
+		t.Fatalf("fallback Display Title = %q, want raw title", got)
+	}
+	if frame.Meta == nil || len(frame.Meta.Notices) != 1 {
+		t.Fatalf("expected one notice, got meta %+v", frame.Meta)
+	}
+}
+
+func TestDistinctFrame(t *testing.T) {
+	rows := []issueRow{
+		{Site: "Site-B", Category: "Wireless"},
+		{Site: "", Category: "Wired"},
+		{Site: "Site-A", Category: "Wireless"},
+		{Site: "Site-B", Category: ""},
+	}
+	frame, err := distinctFrame("A", "site", rows)
+	if err != nil {
+		t.Fatalf("distinctFrame error: %v", err)
+	}
+	if len(frame.Fields) != 1 || frame.Fields[0].Name != "Site Name" {
+		t.Fatalf("unexpected fields: %v", frame.Fields)
+	}
+	want := []string{"Site-A", "Site-B"}
+	if n := frame.Fields[0].Len(); n != len(want) {
+		t.Fatalf("distinct values = %d, want %d", n, len(want))
+	}
+	for i, w := range want {
+		if got := frame.Fields[0].At(i).(string); got != w {
+			t.Errorf("value %d = %q, want %q", i, got, w)
+		}
+	}
+
+	// Column names are accepted too.
+	if frame, err := distinctFrame("A", "Category", rows); err != nil || frame.Fields[0].Len() != 2 {
+		t.Fatalf("distinct category = (%v, %v), want 2 values", frame, err)
+	}
+	if _, err := distinctFrame("A", "nope", rows); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestSitePath(t *testing.T) {
+	tests := []struct {
+		hierarchy, leaf, sep, want string
+	}{
+		{"Global/US/NYC/Floor 2", "Floor 2", "", "Global > US > NYC > Floor 2"},
+		{"/Global/US/", "US", " / ", "Global / US"},
+		{"", "Floor 2", "", "Floor 2"},
+		{"", "", "", ""},
+	}
+	for _, tt := range tests {
+		if got := sitePath(tt.hierarchy, tt.leaf, tt.sep); got != tt.want {
+			t.Errorf("sitePath(%q,%q,%q) = %q, want %q", tt.hierarchy, tt.leaf, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestIssuesToFrame_SitePath(t *testing.T) {
+	issues := []map[string]any{{"issueId": "i1", "siteId": "s1"}, {"issueId": "i2", "siteId": "s2"}}
+	opts := frameOptions{
+		SiteNames:       map[string]string{"s1": "NYC", "s2": "Lab"},
+		SiteHierarchies: map[string]string{"s1": "Global/US/NYC"},
+	}
+	f, idx := issuesToFrame("A", issues, opts, 0).FieldByName("Site Path")
+	if idx < 0 {
+		t.Fatal("missing Site Path field")
+	}
+	if got := f.At(0).(string); got != "Global > US > NYC" {
+		t.Errorf("Site Path[0] = %q", got)
+	}
+	if got := f.At(1).(string); got != "Lab" {
+		t.Errorf("Site Path[1] = %q, want leaf fallback", got)
+	}
+
+	// Without enrichment the column is omitted.
+	if _, idx := issuesToFrame("A", issues, frameOptions{}, 0).FieldByName("Site Path"); idx >= 0 {
+		t.Fatal("Site Path should be omitted without enrichment")
+	}
+}
+
+func TestIssuesToFrame_FieldSelection(t *testing.T) {
+	issues := []map[string]any{{"issueId": "i1", "name": "AP down", "priority": "P1"}}
+
+	frame := issuesToFrame("A", issues, frameOptions{Fields: []string{"Time", "Title", "Priority"}}, 0)
+	var names []string
+	for _, f := range frame.Fields {
+		names = append(names, f.Name)
+	}
+	if len(names) != 3 || names[0] != "Time" || names[1] != "Title" || names[2] != "Priority" {
+		t.Fatalf("fields = %v, want [Time Title Priority]", names)
+	}
+	if frame.Meta != nil {
+		t.Fatalf("unexpected notices: %+v", frame.Meta.Notices)
+	}
+
+	// Time is kept even when not listed; unknown names raise a notice.
+	frame = issuesToFrame("A", issues, frameOptions{Fields: []string{"status", "Bogus"}}, 0)
+	if len(frame.Fields) != 2 || frame.Fields[0].Name != "Time" || frame.Fields[1].Name != "Status" {
+		t.Fatalf("unexpected fields: %v", frame.Fields)
+	}
+	if frame.Meta == nil || len(frame.Meta.Notices) != 1 {
+		t.Fatalf("expected one notice for unknown fields, got %+v", frame.Meta)
+	}
+
+	// An empty selection keeps every column.
+	if n := len(issuesToFrame("A", issues, frameOptions{}, 0).Fields); n != 14 {
+		t.Fatalf("default fields = %d, want 14", n)
+	}
+}
+
+func TestRenderIssueURLs(t *testing.T) {
+	rows := []issueRow{{ID: "a b"}, {ID: ""}}
+
+	urls, notice := renderIssueURLs("", "https://gw/catalyst", rows)
+	if notice != nil {
+		t.Fatalf("unexpected notice: %+v", notice)
+	}
+	if want := "https://gw/catalyst/dna/assurance/issueDetails?issueId=a+b"; urls[0] != want {
+		t.Errorf("default URL = %q, want %q", urls[0], want)
+	}
+	if urls[1] != "" {
+		t.Errorf("row without ID got URL %q", urls[1])
+	}
+
+	urls, _ = renderIssueURLs("{{.BaseURL}}/issues/{{.IssueID}}", "https://dnac", rows)
+	if urls[0] != "https://dnac/issues/a+b" {
+		t.Errorf("custom URL = %q", urls[0])
+	}
+
+	// A broken template falls back to the default with a notice.
+	urls, notice = renderIssueURLs("{{.BaseURL", "https://dnac", rows)
+	if notice == nil || urls[0] != "https://dnac/dna/assurance/issueDetails?issueId=a+b" {
+		t.Errorf("fallback = (%q, %v)", urls[0], notice)
+	}
+}
+
+func TestIssuesToFrame_TimeField(t *testing.T) {
+	issues := []map[string]any{
+		{"issueId": "i1", "timestamp": float64(1_000), "lastOccurredTime": float64(5_000)},
+		{"issueId": "i2", "timestamp": float64(2_000)},
+		{"issueId": "i3"},
+	}
+	frame := issuesToFrame("A", issues, frameOptions{TimeField: "lastOccurredTime"}, 9_000)
+	want := []int64{5_000, 2_000, 9_000} // chosen field, coalesce chain, fallback
+	for i, w := range want {
+		if got := frame.Fields[0].At(i).(time.Time).UnixMilli(); got != w {
+			t.Errorf("row %d time = %d, want %d", i, got, w)
+		}
+	}
+}
+
+func TestIssuesToFrame_PriorityValue(t *testing.T) {
+	issues := []map[string]any{
+		{"priority": "P1"}, {"priority": "P2"}, {"priority": "p3"}, {"severity": "P4"}, {"priority": "HIGH"}, {},
+	}
+	frame := issuesToFrame("A", issues, frameOptions{Fields: []string{"Priority Value"}}, 0)
+	field := frame.Fields[1]
+	if field.Name != "Priority Value" {
+		t.Fatalf("field = %q, want Priority Value", field.Name)
+	}
+	want := []int64{1, 2, 3, 4, 0, 0} // 0: null, unknown priority
+	for i, w := range want {
+		got := field.At(i).(*int64)
+		if (w == 0) != (got == nil) || (got != nil && *got != w) {
+			t.Errorf("row %d = %v, want %d", i, got, w)
+		}
+	}
+}
+
+func TestRawIssuesFrame_MergesKeys(t *testing.T) {
+	issues := []map[string]any{
+		{"issueId": "i1", "priority": "P1", "updatedTime": float64(1700000000000), "muted": true},
+		{"issueId": "i2", "notes": "check uplink", "updatedTime": float64(1700000005000), "custom": map[string]any{"team": "net"}},
+	}
+	frame := rawIssuesFrame("A", issues)
+	var names []string
+	for _, f := range frame.Fields {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "custom,issueId,muted,notes,priority,updatedTime" {
+		t.Fatalf("columns = %s", got)
+	}
+
+	updated, _ := frame.FieldByName("updatedTime")
+	if v := updated.At(1).(*float64); v == nil || *v != 1700000005000 {
+		t.Errorf("updatedTime[1] = %v, want a number", v)
+	}
+	muted, _ := frame.FieldByName("muted")
+	if v := muted.At(0).(*bool); v == nil || !*v {
+		t.Errorf("muted[0] = %v, want true", v)
+	}
+	if v := muted.At(1).(*bool); v != nil {
+		t.Errorf("muted[1] = %v, want null", *v)
+	}
+	custom, _ := frame.FieldByName("custom")
+	if v := custom.At(1).(*string); v == nil || *v != `{"team":"net"}` {
+		t.Errorf("custom[1] = %v, want JSON", v)
+	}
+	notes, _ := frame.FieldByName("notes")
+	if v := notes.At(0).(*string); v != nil {
+		t.Errorf("notes[0] = %q, want null", *v)
+	}
+}
+
+func TestIssuesToFrame_DeviceLink(t *testing.T) {
+	issues := []map[string]any{{"issueId": "i1", "deviceId": "d1"}}
+	frame := issuesToFrame("A", issues, frameOptions{LinkBase: "https://dnac.example.com/proxy"}, 0)
+	f, idx := frame.FieldByName("Device ID")
+	if idx < 0 {
+		t.Fatal("missing Device ID field")
+	}
+	if f.Config == nil || len(f.Config.Links) != 1 {
+		t.Fatalf("Device ID config = %+v, want one data link", f.Config)
+	}
+	want := "https://dnac.example.com/proxy/dna/assurance/device/details?id=${__value.raw}"
+	if got := f.Config.Links[0].URL; got != want {
+		t.Fatalf("link URL = %q, want %q", got, want)
+	}
+
+	// Without a link base there is nothing to link to.
+	f, _ = issuesToFrame("A", issues, frameOptions{}, 0).FieldByName("Device ID")
+	if f.Config != nil {
+		t.Fatalf("Device ID config = %+v, want none without a link base", f.Config)
+	}
+}
+
+func TestIssueTrendFrame_TwoBuckets(t *testing.T) {
+	const from, to = int64(1_699_999_200_000), int64(1_700_006_399_999) // two whole hours
+	issues := []map[string]any{
+		{"timestamp": float64(from + 60_000), "status": "ACTIVE"},
+		{"timestamp": float64(from + 120_000), "issueStatus": "resolved"},
+		{"timestamp": float64(from + 3_600_000), "status": "ACTIVE"},
+		{"timestamp": float64(from + 3_700_000), "status": "IGNORED"},
+		{"timestamp": float64(from + 3_800_000)},           // no status: Total only
+		{"timestamp": float64(to + 1), "status": "ACTIVE"}, // outside the range
+	}
+	frame := issueTrendFrame("A", issues, "", from, to, 3_600_000)
+	if n, _ := frame.RowLen(); n != 2 {
+		t.Fatalf("buckets = %d, want 2", n)
+	}
+	want := map[string][]int64{
+		"Active":   {1, 1},
+		"Resolved": {1, 0},
+		"Ignored":  {0, 1},
+		"Total":    {2, 3},
+	}
+	for name, vals := range want {
+		f, idx := frame.FieldByName(name)
+		if idx < 0 {
+			t.Fatalf("missing field %q", name)
+		}
+		for i, w := range vals {
+			if got := f.At(i).(int64); got != w {
+				t.Errorf("%s[%d] = %d, want %d", name, i, got, w)
+			}
+		}
+	}
+	if ts := frame.Fields[0].At(1).(time.Time); ts.UnixMilli() != from+3_600_000 {
+		t.Errorf("second bucket = %v, want the start of the second hour", ts)
+	}
+}
+
+func TestTrendBucketMs(t *testing.T) {
+	if ms, widened := trendBucketMs(0, 0, 86_400_000); ms != 3_600_000 || widened {
+		t.Fatalf("default = (%d,%v), want (3600000,false)", ms, widened)
+	}
+	if ms, widened := trendBucketMs(1, 0, 86_400_000); !widened || 86_400_000/ms >= maxTrendBuckets {
+		t.Fatalf("narrow = (%d,%v), want widened below %d buckets", ms, widened, maxTrendBuckets)
+	}
+}
+
+func TestIssuesToFrame_IncludeRaw(t *testing.T) {
+	issues := []map[string]any{{
+		"issueId":  "i1",
+		"priority": "P1",
+		"notes":    "rebooted",
+		"tags":     []any{"core", "dc1"},
+		"extra":    map[string]any{"count": float64(3)},
+	}}
+
+	frame := issuesToFrame("A", issues, frameOptions{IncludeRaw: true, Fields: []string{"Title"}}, 0)
+	raw := frame.Fields[len(frame.Fields)-1]
+	if raw.Name != "Raw" {
+		t.Fatalf("last field = %q, want Raw", raw.Name)
+	}
+	if raw.Config == nil || raw.Config.Custom["hidden"] != true {
+		t.Fatalf("Raw config = %+v, want it hidden", raw.Config)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(raw.At(0).(string)), &got); err != nil {
+		t.Fatalf("Raw is not JSON: %v", err)
+	}
+	if !reflect.DeepEqual(got, issues[0]) {
+		t.Fatalf("Raw round-trips to %v, want %v", got, issues[0])
+	}
+
+	for _, f := range issuesToFrame("A", issues, frameOptions{}, 0).Fields {
+		if f.Name == "Raw" {
+			t.Fatal("Raw present without IncludeRaw")
+		}
+	}
+}
+
+func TestIssuesToFrame_V1AndV2Schemas(t *testing.T) {
+	v1 := map[string]any{
+		"issueId":     "i1",
+		"name":        "AP down",
+		"priority":    "P1",
+		"issueStatus": "ACTIVE",
+		"category":    "Availability",
+		"deviceId":    "dev-1",
+		"siteId":      "site-1",
+		"ruleId":      "ap_down",
+		"description": "AP went down",
+		"timestamp":   float64(1_700_000_000_000),
+	}
+	v2 := map[string]any{
+		"id":                     "i1",
+		"summary":                "AP down",
+		"issueSeverity":          map[string]any{"priority": "P1", "level": "HIGH"},
+		"state":                  "ACTIVE",
+		"categoryName":           "Availability",
+		"entityId":               "dev-1",
+		"site":                   map[string]any{"id": "site-1"},
+		"rule":                   map[string]any{"id": "ap_down"},
+		"issueDescription":       "AP went down",
+		"mostRecentOccurredTime": float64(1_700_000_000_000),
+	}
+	if isV2Issue(v1) || !isV2Issue(v2) {
+		t.Fatalf("isV2Issue(v1, v2) = %t, %t; want false, true", isV2Issue(v1), isV2Issue(v2))
+	}
+
+	want := map[string]any{
+		"Time":      time.UnixMilli(1_700_000_000_000).UTC(),
+		"Issue ID":  "i1",
+		"Title":     "AP down",
+		"Priority":  "P1",
+		"Status":    "ACTIVE",
+		"Category":  "Availability",
+		"Device ID": "dev-1",
+		"Site Name": "site-1",
+		"Rule":      "ap_down",
+		"Details":   "AP went down",
+	}
+	for name, issue := range map[string]map[string]any{"v1": v1, "v2": v2} {
+		frame := issuesToFrame("A", []map[string]any{issue}, frameOptions{}, 0)
+		for _, f := range frame.Fields {
+			w, ok := want[f.Name]
+			if !ok {
+				continue
+			}
+			if got := f.At(0); got != w {
+				t.Errorf("%s: %s = %v, want %v", name, f.Name, got, w)
+			}
+		}
+	}
+}
+
+func TestIssuesToFrame_AgeMinutes(t *testing.T) {
+	const ref = int64(1_700_000_000_000)
+	issues := []map[string]any{
+		{"issueId": "old", "timestamp": float64(ref - 90*60_000 - 30_000)}, // 90.5 minutes old
+		{"issueId": "future", "timestamp": float64(ref + 60_000)},          // clamps to 0
+	}
+
+	frame := issuesToFrame("A", issues, frameOptions{AgeReferenceMs: ref}, 0)
+	var age *data.Field
+	for _, f := range frame.Fields {
+		if f.Name == "Age (minutes)" {
+			age = f
+		}
+	}
+	if age == nil {
+		t.Fatal("no Age (minutes) field")
+	}
+	if got := []int64{age.At(0).(int64), age.At(1).(int64)}; got[0] != 90 || got[1] != 0 {
+		t.Fatalf("ages = %v, want [90 0]", got)
+	}
+
+	// The field selection can leave it out.
+	frame = issuesToFrame("A", issues, frameOptions{AgeReferenceMs: ref, Fields: []string{"Title"}}, 0)
+	for _, f := range frame.Fields {
+		if f.Name == "Age (minutes)" {
+			t.Fatal("Age (minutes) present though not selected")
+		}
+	}
+}
+
+func TestIssuesToFrame_TriageColumns(t *testing.T) {
+	issues := []map[string]any{
+		{"issueId": "i1", "notes": "RMA opened", "assignee": "alice", "lastUpdatedTime": float64(1_700_000_000_000)},
+		{"issueId": "i2", "comments": "waiting on ISP", "assignedTo": "bob", "updatedTime": "2023-11-14T22:13:20Z"},
+		{"issueId": "i3"},
+	}
+
+	// Not returned unless selected.
+	frame := issuesToFrame("A", issues, frameOptions{}, 0)
+	for _, f := range frame.Fields {
+		if f.Name == "Notes" || f.Name == "Assignee" || f.Name == "Updated Time" {
+			t.Fatalf("%s present though not selected", f.Name)
+		}
+	}
+
+	frame = issuesToFrame("A", issues, frameOptions{Fields: []string{"Issue ID", "notes", "Assignee", "Updated Time"}}, 0)
+	if frame.Meta != nil {
+		t.Fatalf("unexpected notices %+v", frame.Meta.Notices)
+	}
+	var names []string
+	for _, f := range frame.Fields {
+		names = append(names, f.Name)
+	}
+	if want := []string{"Time", "Issue ID", "Notes", "Assignee", "Updated Time"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("fields = %v, want %v", names, want)
+	}
+	for i, want := range []string{"RMA opened", "waiting on ISP", ""} {
+		if got := frame.Fields[2].At(i).(string); got != want {
+			t.Errorf("row %d: Notes = %q, want %q", i, got, want)
+		}
+	}
+	if got := frame.Fields[3].At(1).(string); got != "bob" {
+		t.Errorf("Assignee = %q, want bob", got)
+	}
+	updated := frame.Fields[4]
+	if updated.Type() != data.FieldTypeNullableTime {
+		t.Fatalf("Updated Time type = %v, want nullable time", updated.Type())
+	}
+	want := time.UnixMilli(1_700_000_000_000).UTC()
+	for i := range 2 {
+		if got := updated.At(i).(*time.Time); got == nil || !got.Equal(want) {
+			t.Errorf("row %d: Updated Time = %v, want %v", i, got, want)
+		}
+	}
+	if got := updated.At(2).(*time.Time); got != nil {
+		t.Errorf("row 2: Updated Time = %v, want null", got)
+	}
+}
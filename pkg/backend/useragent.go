@@ -0,0 +1,28 @@
+package backend
+
+import "net/http"
+
+// Version is the plugin version, reported in the default User-Agent. Release
+// builds set it from main, which gets it via -ldflags "-X main.version=...".
+var Version = "dev"
+
+// defaultUserAgent identifies outbound requests as coming from this plugin,
+// e.g. "grafana-catalyst-datasource/1.2.0", for Catalyst Center audit logs.
+func defaultUserAgent() string {
+	return "grafana-catalyst-datasource/" + Version
+}
+
+// userAgentTransport sets the User-Agent header of every request: issues
+// pages, lookups, health probes and token requests alike.
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+// RoundTrip sends a copy of req carrying the User-Agent, as a RoundTripper
+// must not modify the request it is given.
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
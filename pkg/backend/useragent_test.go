@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestQueryData_UserAgent(t *testing.T) {
+	var mu sync.Mutex
+	agents := map[string]string{} // path -> User-Agent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.URL.Path] = r.Header.Get("User-Agent")
+		mu.Unlock()
+		if strings.Contains(r.URL.Path, "/auth/token") {
+			_, _ = w.Write([]byte(`{"Token":"abc","expiresIn":3600}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":[{"issueId":"i1"}]}`))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		jsonData string
+		want     string
+	}{
+		{`{"baseUrl":"` + srv.URL + `"}`, "grafana-catalyst-datasource/" + Version},
+		{`{"baseUrl":"` + srv.URL + `","userAgent":"noc-grafana/2"}`, "noc-grafana/2"},
+	} {
+		clear(agents)
+		pc := testPluginContext(srv.URL)
+		pc.DataSourceInstanceSettings.JSONData = []byte(tc.jsonData)
+		pc.DataSourceInstanceSettings.DecryptedSecureJSONData = map[string]string{"username": "u", "password": "p"}
+		if _, err := NewDatasource().QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: pc,
+			Queries:       []backend.DataQuery{testQuery("A", `{"queryType":"alerts"}`)},
+		}); err != nil {
+			t.Fatalf("QueryData error: %v", err)
+		}
+		if len(agents) < 2 {
+			t.Fatalf("requests = %v, want the token and issues requests", agents)
+		}
+		for path, ua := range agents {
+			if ua != tc.want {
+				t.Errorf("%s: User-Agent = %q, want %q", path, ua, tc.want)
+			}
+		}
+	}
+}
@@ -51,7 +51,7 @@ const QueryEditor: React.FC<Props> = ({ query, onChange, onRunQuery }) => {
   const [priority, setPriority] = useState<CatalystPriority[]>(query.priority ?? []);
   const [issueStatus, setIssueStatus] = useState<CatalystIssueStatus | ''>(query.issueStatus ?? '');
   const [aiDriven, setAiDriven] = useState<string>(query.aiDriven ? 'true' : query.aiDriven === 'false' ? 'false' : '');
-  const [limit, setLimit] = useState<number>(query.limit ?? 25);
+  const [limit, setLimit] = useState<number | undefined>(query.limit);
 
   // Debounced versions of each field to avoid excessive backend requests
   const dSiteId = useDebounced(siteId, 400);
@@ -91,7 +91,8 @@ const QueryEditor: React.FC<Props> = ({ query, onChange, onRunQuery }) => {
       priority: (dPriority && dPriority.length > 0) ? dPriority : undefined,
       issueStatus: dIssueStatus === '' ? undefined : dIssueStatus,
       aiDriven: dAiDriven === '' ? undefined : dAiDriven,
-      limit: dLimit ?? 25,
+      // Unset means the data source's default limit.
+      limit: dLimit,
       // clear deprecated aliases (backend already handles)
       severity: undefined,
       status: undefined,
@@ -179,9 +180,9 @@ const QueryEditor: React.FC<Props> = ({ query, onChange, onRunQuery }) => {
         <Field label="Limit">
           <Input
             type="number"
-            value={limit}
-            onChange={(e) => setLimit(Number(e.currentTarget.value) || 100)}
-            placeholder="Max rows"
+            value={limit ?? ''}
+            onChange={(e) => setLimit(Number(e.currentTarget.value) || undefined)}
+            placeholder="Default"
             width={12}
           />
         </Field>
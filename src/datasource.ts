@@ -6,9 +6,9 @@
 // - Implementing template variable queries (metricFindQuery)
 // - Efficiently extracting unique values for template variables from recent issues
 
-import { DataSourceWithBackend } from '@grafana/runtime';
-import type { CoreApp, DataSourceInstanceSettings, MetricFindValue } from '@grafana/data';
-import { DEFAULT_QUERY as DEFAULTS, type CatalystQuery, type CatalystJsonData, type CatalystVariableQuery } from './types';
+import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
+import type { CoreApp, DataSourceInstanceSettings, MetricFindValue, ScopedVars } from '@grafana/data';
+import { DEFAULT_QUERY as DEFAULTS, QUERY_TYPES, type CatalystQuery, type CatalystJsonData, type CatalystVariableQuery } from './types';
 
 type InstanceSettings = DataSourceInstanceSettings<CatalystJsonData>;
 
@@ -28,9 +28,22 @@ export class DataSource extends DataSourceWithBackend<CatalystQuery, CatalystJso
   }
 
   // Prevents Grafana from executing empty or invalid queries.
-  // Only queries of type 'alerts' are allowed.
+  // Only queries of a known type (see QUERY_TYPES) are allowed.
   filterQuery(query: CatalystQuery): boolean {
-    return !!query && query.queryType === 'alerts';
+    return !!query && QUERY_TYPES.includes(query.queryType);
+  }
+
+  // Interpolates dashboard variables in the text filters before the query is
+  // sent. Multi-value site variables are joined with commas, which the
+  // backend splits into a multi-site filter.
+  applyTemplateVariables(query: CatalystQuery, scopedVars: ScopedVars): CatalystQuery {
+    const srv = getTemplateSrv();
+    return {
+      ...query,
+      siteId: query.siteId ? srv.replace(query.siteId, scopedVars, 'csv') : query.siteId,
+      deviceId: query.deviceId ? srv.replace(query.deviceId, scopedVars) : query.deviceId,
+      macAddress: query.macAddress ? srv.replace(query.macAddress, scopedVars) : query.macAddress,
+    };
   }
 
   /**
@@ -1,10 +1,18 @@
 import type { DataQuery, DataSourceJsonData } from '@grafana/data';
 
 /**
- * The only query type supported in this version of the plugin.
- * This corresponds to fetching issues/alerts from the Catalyst Center API.
+ * Query types supported by the backend.
+ * - alerts: issues/alerts from the Catalyst Center API
+ * - issueCount: issue counts per priority, as a single row
+ * - issueCountFast: total issue count from the count endpoint, as a single row
+ * - clientHealth: client health scores by client type
+ * - networkHealth: overall network health score over time
+ * - raw: issues with their JSON fields as columns, unmapped
  */
-export type QueryType = 'alerts';
+export type QueryType = 'alerts' | 'issueCount' | 'issueCountFast' | 'issueTrend' | 'clientHealth' | 'networkHealth' | 'raw';
+
+/** All known query types; queries of any other type are not sent. */
+export const QUERY_TYPES: QueryType[] = ['alerts', 'issueCount', 'issueCountFast', 'issueTrend', 'clientHealth', 'networkHealth', 'raw'];
 
 // Define specific, strict types for query parameters to improve type safety.
 export type CatalystPriority = 'P1' | 'P2' | 'P3' | 'P4';
@@ -24,12 +32,20 @@ export interface CatalystQuery extends DataQuery {
   queryType: QueryType;
 
   // Filters that map directly to Catalyst Center API parameters.
-  siteId?: string;
+  siteId?: string; // may be comma-separated for several sites
+  sites?: string[]; // additional site IDs
   deviceId?: string;
   macAddress?: string;
   priority?: CatalystPriority[];
   issueStatus?: CatalystIssueStatus;
   aiDriven?: string; // Should be 'true' or 'false' as a string.
+  category?: string; // issue category, comma-separated for several
+  issueId?: string;
+  rule?: string; // issue name as reported by the API, e.g. ap_down
+  deviceRole?: string; // ACCESS, DISTRIBUTION, CORE, BORDER or AP; comma-separated for several
+  deviceReachability?: string; // Reachable, Unreachable or Ping Reachable
+  sortBy?: string;
+  sortOrder?: 'asc' | 'desc';
 
   // UI-friendly aliases (optional). The frontend can map these to the main fields.
   severity?: string; // alias for priority
@@ -45,6 +61,24 @@ export interface CatalystQuery extends DataQuery {
    * This can improve readability but may impact query performance.
    */
   enrich?: boolean;
+  /** Send the filters as a JSON body to the POST issues query endpoint. */
+  useQueryBody?: boolean;
+  /** Frame name to use instead of the refID, e.g. for legends and joins. */
+  frameName?: string;
+  /** Bucket width in seconds for issueTrend queries (default 3600). */
+  bucketSeconds?: number;
+  /** Scope clientHealth queries to one client type; empty keeps all. */
+  clientType?: 'WIRED' | 'WIRELESS' | 'ALL';
+  /** Extra networkHealth bucket keys to return as fields, e.g. goodCount. */
+  healthMetrics?: string[];
+  /** Live-update an alerts table with issues that appear later. */
+  stream?: boolean;
+  /** Poll interval in seconds of a streamed query (default 30, at least 10). */
+  streamIntervalSeconds?: number;
+  /** Add a hidden Raw column with each issue's original JSON. */
+  includeRaw?: boolean;
+  /** Send the range end 1ms later so issues stamped exactly at it are kept. */
+  inclusiveEnd?: boolean;
 }
 
 /**
@@ -52,7 +86,6 @@ export interface CatalystQuery extends DataQuery {
  */
 export const DEFAULT_QUERY: Partial<CatalystQuery> = {
   queryType: 'alerts',
-  limit: 25,
   enrich: false,
 };
 